@@ -0,0 +1,124 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/danzek/sms-backup-and-restore-parser/smsbackuprestore"
+)
+
+// DiffCommand compares two SMS backups or two calls backups of the same device, outputting the records added, removed
+// (often deleted from the device), and modified between them (sbrparser diff [flags] old.xml new.xml).
+func DiffCommand(exePath string, args []string) {
+	start := time.Now()
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	pOutputDirectory := flags.String("d", exePath, "Directory path for diff output (current executable directory is default)")
+	pFormat := flags.String("format", "tsv", "Output format: tsv, json, or ndjson")
+	pTimeZone := flags.String("tz", "UTC", "IANA time zone for timestamps in output, e.g. America/Chicago")
+	pTimeFormat := flags.String("time-format", "default", "Layout for timestamps in output: default, rfc3339, iso8601 (with milliseconds), or a Go time layout")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprint(os.Stderr, "Missing required arguments: Specify paths to the older and newer xml backup files.\n"+
+			"Example: sbrparser.exe diff sms-20180101000000.xml sms-20180213135542.xml\n")
+		return
+	}
+	location, err := time.LoadLocation(*pTimeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time zone: %s\n", *pTimeZone)
+		return
+	}
+	outputOpts := []smsbackuprestore.OutputOption{
+		smsbackuprestore.WithTimeZone(location),
+		smsbackuprestore.WithTimeLayout(TimeLayout(*pTimeFormat)),
+	}
+	generate, fileNames := smsbackuprestore.GenerateDiffOutput, "diff.tsv and diff_summary.tsv"
+	switch *pFormat {
+	case "tsv":
+	case "json":
+		generate, fileNames = smsbackuprestore.GenerateDiffJSON, "diff.json and diff_summary.json"
+	case "ndjson":
+		outputOpts = append(outputOpts, smsbackuprestore.WithNDJSON())
+		generate, fileNames = smsbackuprestore.GenerateDiffJSON, "diff.ndjson and diff_summary.ndjson"
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid output format: %s\n", *pFormat)
+		return
+	}
+	if outputDirInfo, err := os.Stat(*pOutputDirectory); os.IsNotExist(err) || !outputDirInfo.IsDir() {
+		fmt.Fprintf(os.Stderr, "Invalid output directory path: %s", *pOutputDirectory)
+		return
+	}
+
+	var backups [2]*smsbackuprestore.Backup
+	for i, xmlFilePath := range flags.Args() {
+		fmt.Printf("\nParsing %s (this may take a little while) ...\n", xmlFilePath)
+		backups[i], err = smsbackuprestore.Open(xmlFilePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+			return
+		}
+	}
+
+	var d *smsbackuprestore.Diff
+	switch older, newer := backups[0], backups[1]; {
+	case older.Messages != nil && newer.Messages != nil:
+		d = smsbackuprestore.DiffMessages(older.Messages, newer.Messages)
+	case older.Calls != nil && newer.Calls != nil:
+		d = smsbackuprestore.DiffCalls(older.Calls, newer.Calls)
+	default:
+		fmt.Fprint(os.Stderr, "Unable to compare an SMS backup with a calls backup.\n")
+		return
+	}
+
+	fmt.Println("\nCreating diff output...")
+	if err := generate(d, *pOutputDirectory, outputOpts...); err != nil {
+		fmt.Printf("Error encountered:\n%q\n", err)
+		return
+	}
+	fmt.Println("Finished generating diff output")
+	fmt.Printf("%s files contain the records that differ and a summary by contact\n", fileNames)
+
+	// print summary
+	added, removed, modified := d.Counts()
+	fmt.Println("\nDiff Summary")
+	fmt.Println("===============================================================")
+	fmt.Printf("Added: %d\nRemoved: %d\nModified: %d\n", added, removed, modified)
+	for _, s := range d.Summary() {
+		name := s.Contact
+		if s.ContactName != "" {
+			name = fmt.Sprintf("%s (%s)", s.Contact, s.ContactName)
+		}
+		fmt.Printf("%s: %d added, %d removed, %d modified, %s to %s\n", name, s.Added, s.Removed, s.Modified,
+			s.First.Format(location, TimeLayout(*pTimeFormat)), s.Last.Format(location, TimeLayout(*pTimeFormat)))
+	}
+
+	fmt.Printf("\nCompleted in %.2f seconds.\n", time.Since(start).Seconds())
+	fmt.Printf("Output saved to %s\n", *pOutputDirectory)
+}
@@ -0,0 +1,131 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danzek/sms-backup-and-restore-parser/smsbackuprestore"
+)
+
+// ImportCommand rebuilds an SMS or calls backup that can be restored by the app from sms.tsv or calls.tsv output (or
+// their csv equivalents), e.g. after editing it (sbrparser import [flags] file).
+func ImportCommand(exePath string, args []string) {
+	start := time.Now()
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	pOutputDirectory := flags.String("d", exePath, "Directory path for imported backup (current executable directory is default)")
+	pOutputFile := flags.String("o", "", "File name of imported backup (sms-imported.xml or calls-imported.xml is default)")
+	pFormat := flags.String("format", "", "Format of the file to import: tsv or csv (determined by file extension is default)")
+	pDelimiter := flags.String("delimiter", ",", "Field delimiter of csv file")
+	pTimeZone := flags.String("tz", "UTC", "IANA time zone of timestamps in the file, e.g. America/Chicago")
+	pTimeFormat := flags.String("time-format", "default", "Layout of edited timestamps in the file: default, rfc3339, iso8601, or a Go time layout (must include milliseconds if the file has no Raw Date column)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, "Missing required argument: Specify path to sms or calls tsv/csv file to import.\n"+
+			"Example: sbrparser.exe import -format csv sms.csv\n")
+		return
+	}
+	inputPath := flags.Arg(0)
+
+	location, err := time.LoadLocation(*pTimeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time zone: %s\n", *pTimeZone)
+		return
+	}
+	delimiter := []rune(*pDelimiter)
+	if len(delimiter) != 1 || !smsbackuprestore.ValidDelimiter(delimiter[0]) {
+		fmt.Fprintf(os.Stderr, "Delimiter must be a single character other than a quote or line break: %q\n", *pDelimiter)
+		return
+	}
+	inputOpts := []smsbackuprestore.OutputOption{
+		smsbackuprestore.WithTimeZone(location),
+		smsbackuprestore.WithTimeLayout(TimeLayout(*pTimeFormat)),
+		smsbackuprestore.WithDelimiter(delimiter[0]),
+	}
+
+	format := strings.ToLower(*pFormat)
+	if format == "" {
+		format = "tsv"
+		if strings.EqualFold(filepath.Ext(inputPath), ".csv") {
+			format = "csv"
+		}
+	}
+	importFile := smsbackuprestore.ImportTSV
+	switch format {
+	case "tsv":
+	case "csv":
+		importFile = smsbackuprestore.ImportCSV
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid input format: %s\n", *pFormat)
+		return
+	}
+	if outputDirInfo, err := os.Stat(*pOutputDirectory); os.IsNotExist(err) || !outputDirInfo.IsDir() {
+		fmt.Fprintf(os.Stderr, "Invalid output directory path: %s", *pOutputDirectory)
+		return
+	}
+
+	fmt.Printf("\nImporting %s ...\n", inputPath)
+	f, err := os.Open(inputPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open file: %s\n%v\n", inputPath, err)
+		return
+	}
+	backup, err := importFile(f, inputOpts...)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing file: %s\n%v\n", inputPath, err)
+		return
+	}
+
+	outputFile := *pOutputFile
+	if outputFile == "" {
+		outputFile = "calls-imported.xml"
+		if backup.Messages != nil {
+			outputFile = "sms-imported.xml"
+		}
+	}
+	outputPath := filepath.Join(*pOutputDirectory, outputFile)
+
+	fmt.Println("\nCreating backup...")
+	if err := smsbackuprestore.WriteBackupXML(backup, outputPath); err != nil {
+		fmt.Printf("Error encountered:\n%q\n", err)
+		return
+	}
+	if backup.Messages != nil {
+		fmt.Printf("Imported backup contains %s messages\n", backup.Messages.Count)
+	} else {
+		fmt.Printf("Imported backup contains %s calls\n", backup.Calls.Count)
+	}
+
+	fmt.Printf("\nCompleted in %.2f seconds.\n", time.Since(start).Seconds())
+	fmt.Printf("Imported backup saved to %s\n", outputPath)
+}
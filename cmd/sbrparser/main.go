@@ -29,67 +29,179 @@ package main
 import (
 	"fmt"
 	"flag"
+	"io"
 	"os"
-	"io/ioutil"
-	"encoding/xml"
+	"errors"
+	"strings"
 	"github.com/danzek/sms-backup-and-restore-parser/smsbackuprestore"
 	"time"
 	"path/filepath"
-	"strings"
-	"regexp"
-	"strconv"
-	"unicode/utf16"
-	"bytes"
 )
 
-// SMSOutput calls GenerateSMSOutput() and prints status/errors.
-func SMSOutput(m *smsbackuprestore.Messages, outputDir string) {
-	// generate sms
-	fmt.Println("\nCreating SMS output...")
-	err := smsbackuprestore.GenerateSMSOutput(m, outputDir)
-	if err != nil {
-		fmt.Printf("Error encountered:\n%q\n", err)
-	} else {
-		fmt.Println("Finished generating SMS output")
-		fmt.Println("sms.tsv file contains tab-separated values (TSV), i.e. use tab character as the delimiter")
+// FormatDescription describes the contents of an output file of the given format (-format flag) for status messages.
+func FormatDescription(fileName string, format string) string {
+	switch format {
+	case "csv":
+		return fmt.Sprintf("%s file contains comma-separated values (CSV) with message text exactly as backed up", fileName)
+	case "json":
+		return fmt.Sprintf("%s file contains a JSON array with one object per record", fileName)
+	case "ndjson":
+		return fmt.Sprintf("%s file contains newline-delimited JSON with one object per line", fileName)
+	default:
+		return fmt.Sprintf("%s file contains tab-separated values (TSV), i.e. use tab character as the delimiter", fileName)
 	}
 }
 
-// MMSOutput calls DecodeImages() and GenerateMMSOutput() and prints status/errors.
-func MMSOutput(m *smsbackuprestore.Messages, outputDir string) {
-	// decode and output mms images
-	fmt.Println("\nCreating images output...")
-	numImagesIdentified, numImagesSuccessfullyWritten, imgOutputErrors := smsbackuprestore.DecodeImages(m, outputDir)
-	if imgOutputErrors != nil && len(imgOutputErrors) > 0 {
-		for e := range imgOutputErrors {
-			fmt.Printf("\t%q\n", e)
-		}
-	}
-	fmt.Println("Finished decoding images")
-	fmt.Printf("%d images were identified and %d were successfully written to file\n", numImagesIdentified, numImagesSuccessfullyWritten)
-	fmt.Println("Image file names are in format: <original file name (if known)>_<mms index>-<sms index>.<file extension>")
-
-	// generate mms output
-	fmt.Println("\nCreating MMS output...")
-	mmsOutputErr := smsbackuprestore.GenerateMMSOutput(m, outputDir)
-	if mmsOutputErr != nil {
-		fmt.Printf("Error encountered:\n%q\n", mmsOutputErr)
-	} else {
-		fmt.Println("Finished generating MMS output")
-		fmt.Println("mms.tsv file contains tab-separated values (TSV), i.e. use tab character as the delimiter")
+// FormatSummary describes the output of the given format (-format flag) once every backup has been written to it, for
+// status messages. Formats whose files are described after each backup (see FormatDescription) have no summary.
+func FormatSummary(format string) string {
+	switch format {
+	case "sqlite":
+		return "backup.sqlite file contains a SQLite database of all backups"
+	case "xlsx":
+		return "backup.xlsx file contains an Excel workbook of all backups with SMS, MMS, and Calls worksheets"
+	case "parquet":
+		return "sms.parquet, mms_parts.parquet, and calls.parquet files contain Apache Parquet tables of all backups"
+	case "html":
+		return "Open html/index.html in a web browser to view conversations"
+	case "mbox":
+		return "messages.mbox file contains SMS and MMS messages of all backups as emails in mbox format"
+	case "eml":
+		return "eml directory contains one email (.eml) file per SMS and MMS message, named after its backup file"
+	default:
+		return ""
 	}
 }
 
-// CallsOutput calls GenerateCallOutput() and prints status/errors.
-func CallsOutput (c *smsbackuprestore.Calls, outputDir string) {
-	// generate calls
-	fmt.Println("\nCreating calls output...")
-	err := smsbackuprestore.GenerateCallOutput(c, outputDir)
+// outputWriter is the RecordWriter of an output format given with the -format flag, which every backup is written to.
+type outputWriter struct {
+	format string
+	w      smsbackuprestore.RecordWriter
+	err    error // error writing the current backup, after which none of its records are written
+}
+
+// RecordOutput prints the status/errors of writing the backup at xmlFilePath in an output format (e.g. tsv).
+func RecordOutput(o *outputWriter, xmlFilePath string, backupType smsbackuprestore.BackupType) {
+	if o.err != nil {
+		fmt.Printf("\nError writing backup to %s output:\n%q\n", o.format, o.err)
+		return
+	}
+
+	fmt.Printf("\nFinished writing backup to %s output\n", o.format)
+	switch o.format {
+	case "tsv", "csv", "json", "ndjson":
+		// files are named after the backup file, e.g. sms-20180213135542_sms.tsv
+		base := filepath.Base(xmlFilePath)
+		prefix := strings.TrimSuffix(base, filepath.Ext(base)) + "_"
+		if backupType == smsbackuprestore.CallsBackup {
+			fmt.Println(FormatDescription(prefix+"calls."+o.format, o.format))
+		} else {
+			fmt.Println(FormatDescription(prefix+"sms."+o.format, o.format))
+			fmt.Println(FormatDescription(prefix+"mms."+o.format, o.format))
+		}
+	}
+}
+
+// AttachmentsOutput prints the status/errors of decoding the images and attachments of a backup with an
+// AttachmentWriter.
+func AttachmentsOutput(w *smsbackuprestore.AttachmentWriter) {
+	fmt.Println("\nImages and attachments output:")
+	for _, e := range w.Errors {
+		fmt.Printf("\t%q\n", e)
+	}
+	fmt.Println("Finished decoding images and attachments")
+	fmt.Printf("%d images and attachments were identified and %d were successfully written to file\n", w.Identified, w.Written)
+	fmt.Println("Images are in the images directory and other attachments (e.g. video, audio, vCards) in the attachments directory")
+	fmt.Println("File names are in format: <backup file name>_<original file name (if known)>_<mms index>-<part index>.<file extension>")
+}
+
+// StreamOutput reads the records of a backup one at a time and writes each of them to the writer of every output
+// format, decoding the attachments of MMS messages if requested, then prints status/errors. Only one record is held in
+// memory at a time, so formats that write records as they are given (e.g. tsv) use bounded memory however large the
+// backup is.
+func StreamOutput(p *smsbackuprestore.FileParser, xmlFilePath string, outputDir string, writers []*outputWriter, decodeAttachments bool, timezoneReport *smsbackuprestore.TimezoneReport) {
+	h, err := p.Header()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+		return
+	}
+	backupType := h.BackupType()
+
+	// begin the backup in each format that records of this type are written to
+	var active []*outputWriter
+	for _, o := range writers {
+		if backupType == smsbackuprestore.CallsBackup && (o.format == "mbox" || o.format == "eml") {
+			fmt.Printf("\n%s output contains messages only; skipping calls in %s\n", o.format, filepath.Base(xmlFilePath))
+			continue
+		}
+		o.err = nil
+		if bw, ok := o.w.(smsbackuprestore.BackupWriter); ok {
+			o.err = bw.BeginBackup(xmlFilePath, h)
+		}
+		active = append(active, o)
+	}
+	var attachments *smsbackuprestore.AttachmentWriter
+	if decodeAttachments && backupType == smsbackuprestore.MessagesBackup {
+		attachments = smsbackuprestore.NewAttachmentWriter(outputDir, smsbackuprestore.WithSource(xmlFilePath))
+	}
+
+	var numSMS, numMMS, numCalls int
+	for {
+		record, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+			fmt.Fprint(os.Stderr, "Output contains the records preceding the error.\n")
+			break
+		}
+
+		timezoneReport.AddRecord(record)
+
+		// index of record among those of its type
+		var i int
+		switch rec := record.(type) {
+		case *smsbackuprestore.SMS:
+			i, numSMS = numSMS, numSMS+1
+		case *smsbackuprestore.MMS:
+			i, numMMS = numMMS, numMMS+1
+			if attachments != nil {
+				attachments.WriteMMS(i, rec)
+			}
+		case *smsbackuprestore.Call:
+			i, numCalls = numCalls, numCalls+1
+		}
+
+		for _, o := range active {
+			if o.err == nil {
+				o.err = smsbackuprestore.WriteRecord(o.w, i, record)
+			}
+		}
+	}
+
+	// print validation / qc / stats to stdout
+	smsbackuprestore.PrintCountQC(h, numSMS, numMMS, numCalls)
+
+	if attachments != nil {
+		AttachmentsOutput(attachments)
+	}
+	for _, o := range active {
+		RecordOutput(o, xmlFilePath, backupType)
+	}
+}
+
+// TimezoneOutput calls GenerateTimezoneOutput() and prints status/errors.
+func TimezoneOutput(r *smsbackuprestore.TimezoneReport, outputDir string, opts []smsbackuprestore.OutputOption) {
+	fmt.Println("\nCreating time zone report...")
+	err := smsbackuprestore.GenerateTimezoneOutput(r, outputDir, opts...)
 	if err != nil {
 		fmt.Printf("Error encountered:\n%q\n", err)
 	} else {
-		fmt.Println("Finished generating calls output")
-		fmt.Println("calls.tsv file contains tab-separated values (TSV), i.e. use tab character as the delimiter")
+		fmt.Println("Finished generating time zone report")
+		if r.Unrecognized > 0 {
+			fmt.Printf("UTC offset could not be determined for %d records with unrecognized readable dates\n", r.Unrecognized)
+		}
+		fmt.Println("timezones.tsv file contains tab-separated values (TSV), i.e. use tab character as the delimiter")
 	}
 }
 
@@ -108,6 +220,61 @@ func GetExecutablePath() (string, error) {
 	return exePath, nil
 }
 
+// formatList is the value of the -format flag, which may be repeated or list formats separated by commas.
+type formatList []string
+
+// String method for formatList type returns the formats separated by commas.
+func (f *formatList) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set method for formatList type adds the formats listed in value, ignoring any already given.
+func (f *formatList) Set(value string) error {
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" || f.contains(format) {
+			continue
+		}
+		*f = append(*f, format)
+	}
+	return nil
+}
+
+// contains reports whether format was given.
+func (f formatList) contains(format string) bool {
+	for _, given := range f {
+		if given == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidFormat reports whether format is a registered output format.
+func ValidFormat(format string) bool {
+	for _, registered := range smsbackuprestore.Formats() {
+		if format == registered {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeLayout returns the time layout for the value of the -time-format flag, which may name one of the predefined
+// layouts or be a Go time layout itself.
+func TimeLayout(format string) string {
+	switch format {
+	case "default":
+		return smsbackuprestore.TimeLayoutDefault
+	case "rfc3339":
+		return smsbackuprestore.TimeLayoutRFC3339
+	case "iso8601":
+		return smsbackuprestore.TimeLayoutISO8601
+	default:
+		return format
+	}
+}
+
 // main function for command-line SMS Backup & Restore app XML output parser.
 func main() {
 	// time program execution
@@ -119,10 +286,93 @@ func main() {
 		panic(err)
 	}
 
+	// subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "merge":
+			MergeCommand(exePath, os.Args[2:])
+			return
+		case "diff":
+			DiffCommand(exePath, os.Args[2:])
+			return
+		case "split":
+			SplitCommand(exePath, os.Args[2:])
+			return
+		case "import":
+			ImportCommand(exePath, os.Args[2:])
+			return
+		}
+	}
+
 	// parse command-line args/flags
 	pOutputDirectory := flag.String("d", exePath, "Directory path for parsed output (current executable directory is default)")
+	pTimeZone := flag.String("tz", "UTC", "IANA time zone for timestamps in output, e.g. America/Chicago")
+	pTimeFormat := flag.String("time-format", "default", "Layout for timestamps in output: default, rfc3339, iso8601 (with milliseconds), or a Go time layout")
+	var formats formatList
+	flag.Var(&formats, "format", "Output format(s), comma-separated or repeated: tsv (default), csv (RFC 4180, with message text exactly as backed up), json, ndjson, sqlite, xlsx, parquet, html (chat-style report), mbox, or eml (emails of messages)")
+	pDelimiter := flag.String("delimiter", ",", "Field delimiter for csv output")
+	pQuoteAll := flag.Bool("quote-all", false, "Quote every field of csv output rather than only fields that require it")
+	pAttachments := flag.String("attachments", "blob", "Storage of MMS images in sqlite output: blob (image data) or path (path of decoded image file)")
+	pHeader := flag.String("header", "display", "Column header naming: display (e.g. \"Date Sent\"), snake (e.g. date_sent), or none")
 	flag.Parse()
 
+	// validate timestamp output options
+	location, err := time.LoadLocation(*pTimeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time zone: %s\n", *pTimeZone)
+		return
+	}
+	outputOpts := []smsbackuprestore.OutputOption{
+		smsbackuprestore.WithTimeZone(location),
+		smsbackuprestore.WithTimeLayout(TimeLayout(*pTimeFormat)),
+	}
+
+	// validate output formats
+	if len(formats) == 0 {
+		formats = formatList{"tsv"}
+	}
+	decodeAttachments := false
+	for _, format := range formats {
+		if !ValidFormat(format) {
+			fmt.Fprintf(os.Stderr, "Invalid output format: %s\n", format)
+			return
+		}
+		if format != "mbox" && format != "eml" {
+			// images and other attachments are referenced by (or stored in) every other format
+			decodeAttachments = true
+		}
+	}
+
+	// validate delimited output options
+	delimiter := []rune(*pDelimiter)
+	if len(delimiter) != 1 || !smsbackuprestore.ValidDelimiter(delimiter[0]) {
+		fmt.Fprintf(os.Stderr, "Delimiter must be a single character other than a quote or line break: %q\n", *pDelimiter)
+		return
+	}
+	outputOpts = append(outputOpts, smsbackuprestore.WithDelimiter(delimiter[0]))
+	if *pQuoteAll {
+		outputOpts = append(outputOpts, smsbackuprestore.WithQuoting(smsbackuprestore.QuoteAll))
+	}
+	switch *pHeader {
+	case "display":
+		outputOpts = append(outputOpts, smsbackuprestore.WithHeaderStyle(smsbackuprestore.HeaderDisplay))
+	case "snake":
+		outputOpts = append(outputOpts, smsbackuprestore.WithHeaderStyle(smsbackuprestore.HeaderSnakeCase))
+	case "none":
+		outputOpts = append(outputOpts, smsbackuprestore.WithHeaderStyle(smsbackuprestore.HeaderNone))
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid header style: %s\n", *pHeader)
+		return
+	}
+	switch *pAttachments {
+	case "blob":
+	case "path":
+		outputOpts = append(outputOpts, smsbackuprestore.WithAttachmentPaths())
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid attachment storage: %s\n", *pAttachments)
+		return
+	}
+
 	// validate output directory
 	if outputDirInfo, err := os.Stat(*pOutputDirectory); os.IsNotExist(err) || !outputDirInfo.IsDir() {
 		fmt.Fprintf(os.Stderr, "Invalid output directory path: %s", *pOutputDirectory)
@@ -131,6 +381,22 @@ func main() {
 	fmt.Printf("Output directory set to %s\n", *pOutputDirectory)
 
 	if len(flag.Args()) > 0 {
+		timezoneReport := new(smsbackuprestore.TimezoneReport)
+
+		// all backups are written to a single writer per format
+		var writers []*outputWriter
+		for _, format := range formats {
+			w, err := smsbackuprestore.NewRecordWriter(format, *pOutputDirectory, outputOpts...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating %s output:\n%q\n", format, err)
+				for _, o := range writers {
+					o.w.Close()
+				}
+				return
+			}
+			writers = append(writers, &outputWriter{format: format, w: w})
+		}
+
 		for _, xmlFilePath := range flag.Args() {
 			// ensure file is valid (file path to xml file with sms backup and restore output)
 			fileInfo, err := os.Stat(xmlFilePath)
@@ -142,73 +408,42 @@ func main() {
 				return
 			}
 
-			// get just file name and perform verification checks (assumes default lowercase naming convention)
-			fileName := filepath.Base(xmlFilePath)
-			if (strings.HasPrefix(fileName, "calls-") || strings.HasPrefix(fileName, "sms-")) && filepath.Ext(fileName) == ".xml" {
-				// open xml file
-				f, err := os.Open(xmlFilePath)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error opening XML file: %s\n", xmlFilePath)
-					break
-				}
-				defer f.Close()
+			// status message
+			fmt.Printf("\nParsing %s (this may take a little while) ...\n", xmlFilePath)
+
+			// read backup, determining file type from root element (or file name if root element cannot be read)
+			parser, err := smsbackuprestore.OpenParser(xmlFilePath)
+			if errors.Is(err, smsbackuprestore.ErrUnknownBackupType) {
+				fmt.Fprintf(os.Stderr, "Unable to determine type of backup (expected <smses> or <calls> root element): %s\n", filepath.Base(xmlFilePath))
+				continue
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+				continue
+			}
 
-				// status message
-				fmt.Printf("\nLoading %s into memory and parsing (this may take a little while) ...\n", xmlFilePath)
+			StreamOutput(parser, xmlFilePath, *pOutputDirectory, writers, decodeAttachments, timezoneReport)
+			parser.Close()
+		}
 
-				// read entire file into data variable
-				data, fileReadErr := ioutil.ReadFile(xmlFilePath)
-				if fileReadErr != nil {
-					panic(fileReadErr)
-				}
-				
-				// remove null bytes encoded as XML entities because the Java developer of SMS Backup & Restore doesn't understand UTF-8 nor XML
-				data = bytes.Replace(data, []byte("&#0;"), []byte(""), -1)
-
-				// attempt to render emoji's properly due to SMS Backup & Restore app rendering of emoji's as HTML entitites in decimal (slow)
-				re := regexp.MustCompile(`&#(\d{5});&#(\d{5});`)
-				data = smsbackuprestore.ReplaceAllBytesSubmatchFunc(re, data, func(groups [][]byte) []byte {
-					high, _ := strconv.Atoi(string(groups[2]))
-					low, _ := strconv.Atoi(string(groups[1]))
-
-					return []byte(fmt.Sprintf("&#%d;", int(utf16.Decode([]uint16{uint16(low), uint16(high)})[0])))
-				})
-
-				// determine file type
-				if strings.HasPrefix(fileName, "sms-") {
-					// sms backup
-					// instantiate messages object
-					m := new(smsbackuprestore.Messages)
-					if err := xml.Unmarshal(data, m); err != nil {
-						panic(err)
-					}
-
-					// print validation / qc / stats to stdout
-					m.PrintMessageCountQC()
-
-					// generate sms
-					SMSOutput(m, *pOutputDirectory)
-
-					// generate mms
-					MMSOutput(m, *pOutputDirectory)
-				} else {
-					// calls backup
-					// instantiate calls object
-					c := new(smsbackuprestore.Calls)
-					if err := xml.Unmarshal(data, c); err != nil {
-						panic(err)
-					}
-
-					// print validation / qc / stats to stdout
-					c.PrintCallCountQC()
-
-					// generate calls output
-					CallsOutput(c, *pOutputDirectory)
+		for _, o := range writers {
+			if err := o.w.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error finishing %s output:\n%q\n", o.format, err)
+			} else if summary := FormatSummary(o.format); summary != "" {
+				fmt.Println("\n" + summary)
+			}
+			if x, ok := o.w.(*smsbackuprestore.XLSXExport); ok && x.Truncated > 0 {
+				fmt.Printf("WARNING: %d cells exceeded Excel's limit of 32,767 characters and were truncated; use csv or json output for their full text\n", x.Truncated)
+			}
+			if s, ok := o.w.(*smsbackuprestore.SQLiteExport); ok && len(s.Errors) > 0 {
+				fmt.Printf("WARNING: %d MMS parts could not be decoded and have no data in backup.sqlite:\n", len(s.Errors))
+				for _, e := range s.Errors {
+					fmt.Printf("\t%q\n", e)
 				}
-			} else {
-				fmt.Fprintf(os.Stderr, "Unexpected file name: %s\n", fileName)
 			}
 		}
+
+		// generate timezone report across all backups
+		TimezoneOutput(timezoneReport, *pOutputDirectory, outputOpts)
 	} else {
 		fmt.Fprint(os.Stderr, "Missing required argument: Specify path to xml backup file(s).\n" +
 			"Example: sbrparser.exe C:\\Users\\4n68r\\Documents\\sms-20180213135542.xml\n")  // todo -- use name of executable
@@ -0,0 +1,111 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danzek/sms-backup-and-restore-parser/smsbackuprestore"
+)
+
+// MergeCommand combines SMS or calls backups into a single backup that can be restored by the app, dropping records
+// found in more than one backup (sbrparser merge [flags] file...).
+func MergeCommand(exePath string, args []string) {
+	start := time.Now()
+
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	pOutputDirectory := flags.String("d", exePath, "Directory path for merged backup (current executable directory is default)")
+	pOutputFile := flags.String("o", "", "File name of merged backup (sms-merged.xml or calls-merged.xml is default)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprint(os.Stderr, "Missing required argument: Specify paths to xml backup files to merge.\n"+
+			"Example: sbrparser.exe merge sms-20180212135542.xml sms-20180213135542.xml\n")
+		return
+	}
+	if outputDirInfo, err := os.Stat(*pOutputDirectory); os.IsNotExist(err) || !outputDirInfo.IsDir() {
+		fmt.Fprintf(os.Stderr, "Invalid output directory path: %s", *pOutputDirectory)
+		return
+	}
+
+	var merger smsbackuprestore.Merger
+	for _, xmlFilePath := range flags.Args() {
+		fmt.Printf("\nParsing %s (this may take a little while) ...\n", xmlFilePath)
+		backup, err := smsbackuprestore.Open(xmlFilePath)
+		if errors.Is(err, smsbackuprestore.ErrUnknownBackupType) {
+			fmt.Fprintf(os.Stderr, "Unable to determine type of backup (expected <smses> or <calls> root element): %s\n", filepath.Base(xmlFilePath))
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+			continue
+		}
+
+		if err := merger.AddBackup(xmlFilePath, backup); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	merged := merger.Backup()
+	if merged == nil {
+		fmt.Fprint(os.Stderr, "No backups were merged.\n")
+		return
+	}
+
+	outputFile := *pOutputFile
+	if outputFile == "" {
+		outputFile = "calls-merged.xml"
+		if merged.Messages != nil {
+			outputFile = "sms-merged.xml"
+		}
+	}
+	outputPath := filepath.Join(*pOutputDirectory, outputFile)
+
+	fmt.Println("\nCreating merged backup...")
+	if err := smsbackuprestore.WriteBackupXML(merged, outputPath); err != nil {
+		fmt.Printf("Error encountered:\n%q\n", err)
+		return
+	}
+	fmt.Println("Finished creating merged backup")
+
+	// report duplicates dropped from each backup
+	fmt.Println("\nMerge Report")
+	fmt.Println("===============================================================")
+	for _, source := range merger.Sources() {
+		fmt.Printf("%s: %d records, %d duplicates dropped\n", source.Name, source.Records, source.Duplicates)
+	}
+	if merged.Messages != nil {
+		fmt.Printf("Merged backup contains %s messages (%d SMS, %d MMS)\n", merged.Messages.Count, len(merged.Messages.SMS), len(merged.Messages.MMS))
+	} else {
+		fmt.Printf("Merged backup contains %s calls\n", merged.Calls.Count)
+	}
+
+	fmt.Printf("\nCompleted in %.2f seconds.\n", time.Since(start).Seconds())
+	fmt.Printf("Merged backup saved to %s\n", outputPath)
+}
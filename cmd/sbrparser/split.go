@@ -0,0 +1,193 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danzek/sms-backup-and-restore-parser/smsbackuprestore"
+)
+
+// ParseSize parses a size such as "500MB" or "2GB" (in multiples of 1024 bytes) or a number of bytes.
+func ParseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size, multiplier = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return n * multiplier, nil
+}
+
+// SplitFileName returns a file name for a part of a split backup, e.g. "sms-20180101000000-2018-01.xml", replacing
+// characters in the name of the part (such as a contact name) that may not be allowed in file names.
+func SplitFileName(xmlFilePath string, partName string) string {
+	base := strings.TrimSuffix(filepath.Base(xmlFilePath), filepath.Ext(xmlFilePath))
+	safeName := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, partName)
+	return base + "-" + strings.TrimSpace(safeName) + ".xml"
+}
+
+// UniqueFileName returns fileName, or fileName with a numeric suffix before its extension (e.g.
+// "sms-20180101000000-Bob-2.xml") if it matches a name in used, ignoring case. Parts whose names only differ by
+// characters replaced by SplitFileName, or by case on case-insensitive file systems, therefore do not overwrite each
+// other. The name returned is added to used, in lower case.
+func UniqueFileName(fileName string, used map[string]bool) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	name := fileName
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// SplitBySize splits the backup at xmlFilePath into backups no larger than maxSize in outputDir, reading its records one
+// at a time so that backups too large to hold in memory can be split, and prints status/errors.
+func SplitBySize(xmlFilePath string, outputDir string, maxSize int64, usedFileNames map[string]bool) {
+	parser, err := smsbackuprestore.OpenParser(xmlFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+		return
+	}
+	defer parser.Close()
+
+	fmt.Printf("\nSplitting %s into backups of at most %d bytes...\n", filepath.Base(xmlFilePath), maxSize)
+	files, err := smsbackuprestore.SplitBySize(parser.Parser, maxSize, func(name string) string {
+		return filepath.Join(outputDir, UniqueFileName(SplitFileName(xmlFilePath, name), usedFileNames))
+	})
+	records := "messages"
+	if h, _ := parser.Header(); h != nil && h.BackupType() == smsbackuprestore.CallsBackup {
+		records = "calls"
+	}
+	for _, f := range files {
+		fmt.Printf("%s: %d %s\n", filepath.Base(f.Path), f.Count, records)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error splitting XML file: %s\n%v\n", xmlFilePath, err)
+	}
+}
+
+// SplitCommand partitions backups into several backups that can each be restored by the app, by date, contact, or
+// size (sbrparser split [flags] file...).
+func SplitCommand(exePath string, args []string) {
+	start := time.Now()
+
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	pOutputDirectory := flags.String("d", exePath, "Directory path for split backups (current executable directory is default)")
+	pBy := flags.String("by", "year", "Partition records by: year, month, contact (name, or number if unknown), number, or size")
+	pMaxSize := flags.String("max-size", "1GB", "Maximum size of each backup when splitting by size, e.g. 500MB")
+	pTimeZone := flags.String("tz", "UTC", "IANA time zone for the dates of records when splitting by year or month")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprint(os.Stderr, "Missing required argument: Specify path to xml backup file(s) to split.\n"+
+			"Example: sbrparser.exe split -by month sms-20180213135542.xml\n")
+		return
+	}
+	location, err := time.LoadLocation(*pTimeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time zone: %s\n", *pTimeZone)
+		return
+	}
+	var by smsbackuprestore.SplitBy
+	var maxSize int64
+	switch *pBy {
+	case "year":
+		by = smsbackuprestore.SplitByYear
+	case "month":
+		by = smsbackuprestore.SplitByMonth
+	case "contact":
+		by = smsbackuprestore.SplitByContact
+	case "number":
+		by = smsbackuprestore.SplitByNumber
+	case "size":
+		if maxSize, err = ParseSize(*pMaxSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid maximum size: %s\n", *pMaxSize)
+			return
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid partitioning: %s\n", *pBy)
+		return
+	}
+	if outputDirInfo, err := os.Stat(*pOutputDirectory); os.IsNotExist(err) || !outputDirInfo.IsDir() {
+		fmt.Fprintf(os.Stderr, "Invalid output directory path: %s", *pOutputDirectory)
+		return
+	}
+
+	// names of the split backups written so far, so that none is overwritten
+	usedFileNames := make(map[string]bool)
+
+	for _, xmlFilePath := range flags.Args() {
+		fmt.Printf("\nParsing %s (this may take a little while) ...\n", xmlFilePath)
+		if *pBy == "size" {
+			SplitBySize(xmlFilePath, *pOutputDirectory, maxSize, usedFileNames)
+			continue
+		}
+		backup, err := smsbackuprestore.Open(xmlFilePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing XML file: %s\n%v\n", xmlFilePath, err)
+			continue
+		}
+
+		parts := smsbackuprestore.SplitBackup(backup, by, location)
+		fmt.Printf("\nSplitting %s into %d backups...\n", filepath.Base(xmlFilePath), len(parts))
+		for _, part := range parts {
+			fileName := UniqueFileName(SplitFileName(xmlFilePath, part.Name), usedFileNames)
+			if err := smsbackuprestore.WriteBackupXML(part.Backup, filepath.Join(*pOutputDirectory, fileName)); err != nil {
+				fmt.Printf("Error encountered:\n%q\n", err)
+				continue
+			}
+			if m := part.Backup.Messages; m != nil {
+				fmt.Printf("%s: %s messages\n", fileName, m.Count)
+			} else {
+				fmt.Printf("%s: %s calls\n", fileName, part.Backup.Calls.Count)
+			}
+		}
+	}
+
+	fmt.Printf("\nCompleted in %.2f seconds.\n", time.Since(start).Seconds())
+	fmt.Printf("Output saved to %s\n", *pOutputDirectory)
+}
@@ -0,0 +1,46 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package main
+
+import "testing"
+
+func TestUniqueFileName(t *testing.T) {
+	used := make(map[string]bool)
+	for _, tt := range []struct {
+		partName string
+		want     string
+	}{
+		{"A/B", "sms-1-A_B.xml"},
+		{"A_B", "sms-1-A_B-2.xml"},
+		{"a_b", "sms-1-a_b-3.xml"},
+		{"Bob", "sms-1-Bob.xml"},
+		{"A_B-2", "sms-1-A_B-2-2.xml"},
+		{"BOB", "sms-1-BOB-2.xml"},
+	} {
+		if got := UniqueFileName(SplitFileName("backups/sms-1.xml", tt.partName), used); got != tt.want {
+			t.Errorf("part %q: got %q, want %q", tt.partName, got, tt.want)
+		}
+	}
+}
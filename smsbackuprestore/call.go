@@ -25,46 +25,80 @@ SOFTWARE.
 package smsbackuprestore
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 	"strconv"
 )
 
+// callHeaders are the column headers of call output.
+// The raw columns contain phone numbers, contact names, and dates as they appear in the backup, rather than normalized
+// or formatted, so that they are imported unchanged.
+var callHeaders = []string{
+	"Call Index #",
+	"Number",
+	"Duration (Seconds)",
+	"Date",
+	"Type",
+	"Readable Date",
+	"Contact Name",
+	"Presentation",
+	"Subscription ID",
+	"Subscription Component Name",
+	"Post Dial Digits",
+	"Features",
+	"Other Attributes",
+	"UTC Offset",
+	"Raw Number",
+	"Raw Contact Name",
+	"Raw Date",
+}
+
 // GenerateCallOutput outputs a tab-delimited file named "calls.tsv" containing parsed calls from the backup file.
-func GenerateCallOutput(c *Calls, outputDir string) error {
-	callOutput, err := os.Create(filepath.Join(outputDir, "calls.tsv"))
-	if err != nil {
-		return fmt.Errorf("Unable to create file: calls.tsv\n%q", err)
-	}
-	defer callOutput.Close()
+func GenerateCallOutput(c *Calls, outputDir string, opts ...OutputOption) error {
+	return generateCallTable(c, outputDir, newOutputConfig(opts))
+}
 
-	// print header row
-	headers := []string{
-		"Call Index #",
-		"Number",
-		"Duration (Seconds)",
-		"Date",
-		"Type",
-		"Readable Date",
-		"Contact Name",
-	}
-	fmt.Fprintf(callOutput, "%s\n", strings.Join(headers, "\t"))
+// GenerateCallCSV outputs an RFC 4180 comma-separated file named "calls.csv" containing parsed calls from the backup
+// file.
+func GenerateCallCSV(c *Calls, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+	cfg.csv = true
+	return generateCallTable(c, outputDir, cfg)
+}
+
+// generateCallTable outputs a delimited file containing parsed calls. The file is created even if there are no calls.
+func generateCallTable(c *Calls, outputDir string, cfg *outputConfig) error {
+	w := newTableRecordWriter(outputDir, cfg)
+	err := w.open(&w.calls, "calls", callHeaders)
 
 	// iterate over calls
-	for i, call := range c.Calls {
-		row := []string{
-			strconv.Itoa(i),
-			call.Number.String(),
-			strconv.Itoa(call.Duration),
-			call.Date.String(),
-			call.Type.String(),
-			call.ReadableDate,
-			RemoveCommasBeforeSuffixes(call.ContactName),
-		}
-		fmt.Fprintf(callOutput, "%s\n", strings.Join(row, "\t"))
+	for i := 0; err == nil && i < len(c.Calls); i++ {
+		err = w.WriteCall(i, &c.Calls[i])
 	}
 
-	return nil
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// callRow returns the columns of call output for the call with the given index.
+func callRow(i int, call *Call, cfg *outputConfig) []string {
+	return []string{
+		strconv.Itoa(i),
+		call.Number.String(),
+		strconv.Itoa(call.Duration),
+		cfg.formatTime(call.Date),
+		call.Type.String(),
+		call.ReadableDate,
+		RemoveCommasBeforeSuffixes(call.ContactName),
+		call.Presentation.String(),
+		call.SubscriptionID,
+		call.SubscriptionComponentName,
+		call.PostDialDigits,
+		call.Features.String(),
+		cfg.text(FormatAttributes(call.OtherAttributes)),
+		formatRecordOffset(call.UTCOffset()),
+		string(call.Number),
+		call.ContactName,
+		string(call.Date),
+	}
 }
@@ -0,0 +1,512 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffChange is the kind of difference found for a record when comparing two backups.
+type DiffChange int
+
+const (
+	// DiffAdded marks a record found only in the newer backup.
+	DiffAdded DiffChange = iota
+	// DiffRemoved marks a record found only in the older backup, which often means it was deleted from the device.
+	DiffRemoved
+	// DiffModified marks a record found in both backups with different attributes.
+	DiffModified
+)
+
+// String method for DiffChange type returns the kind of difference, e.g. "Removed".
+func (dc DiffChange) String() string {
+	switch dc {
+	case DiffAdded:
+		return "Added"
+	case DiffRemoved:
+		return "Removed"
+	case DiffModified:
+		return "Modified"
+	default:
+		return strconv.Itoa(int(dc))
+	}
+}
+
+// DiffRecord is a record that differs between two backups.
+type DiffRecord struct {
+	Change        DiffChange
+	Old           Record   // record in the older backup (*SMS, *MMS, or *Call), or nil if added
+	New           Record   // record in the newer backup, or nil if removed
+	OldIndex      int      // index of the record in the older backup, or -1 if added
+	NewIndex      int      // index of the record in the newer backup, or -1 if removed
+	ChangedFields []string // names (as in JSON output) of the attributes that differ, if modified
+}
+
+// Record returns the newer version of the record, or the older version if it was removed.
+func (d *DiffRecord) Record() Record {
+	if d.New != nil {
+		return d.New
+	}
+	return d.Old
+}
+
+// DiffSummary counts the differences found for one contact.
+type DiffSummary struct {
+	Contact     string // normalized phone number, or numbers separated by semicolons for group messages
+	ContactName string // contact name, if known
+	Added       int
+	Removed     int
+	Modified    int
+	First       AndroidTS // date of the earliest record that differs
+	Last        AndroidTS // date of the latest record that differs
+}
+
+// Diff holds the differences between two SMS backups or two calls backups, in chronological order.
+type Diff struct {
+	Records []DiffRecord
+}
+
+// diffRecordInfo returns the record type, date, contact, and contact name of a record.
+func diffRecordInfo(record Record) (recordType string, date AndroidTS, contact string, contactName string) {
+	switch rec := record.(type) {
+	case *SMS:
+		return "SMS", rec.Date, rec.Address.String(), RemoveCommasBeforeSuffixes(rec.ContactName)
+	case *MMS:
+		var numbers []string
+		for _, number := range strings.Split(string(rec.Address), "~") {
+			numbers = append(numbers, PhoneNumber(number).String())
+		}
+		return "MMS", rec.Date, strings.Join(numbers, ";"), RemoveCommasBeforeSuffixes(rec.ContactName)
+	case *Call:
+		return "Call", rec.Date, rec.Number.String(), RemoveCommasBeforeSuffixes(rec.ContactName)
+	}
+	return "", "", "", ""
+}
+
+// diffIdentity returns the stable identity of a record, which does not change when its other attributes (such as
+// whether it has been read) do: its type, date, and normalized address or number.
+func diffIdentity(record Record) string {
+	recordType, date, contact, _ := diffRecordInfo(record)
+	return recordType + "\x00" + string(date) + "\x00" + contact
+}
+
+// diffFields returns the JSON representation of a record as a map from attribute name to value, for comparison. The
+// index of the record is excluded since it is expected to differ.
+func diffFields(record Record) map[string]interface{} {
+	cfg := newOutputConfig(nil)
+	var j interface{}
+	switch rec := record.(type) {
+	case *SMS:
+		j = newSMSJSON(0, rec, cfg)
+	case *MMS:
+		j = newMMSJSON(0, rec, cfg)
+	case *Call:
+		j = newCallJSON(0, rec, cfg)
+	}
+
+	var fields map[string]interface{}
+	data, _ := json.Marshal(j)
+	json.Unmarshal(data, &fields)
+	delete(fields, "index")
+	return fields
+}
+
+// changedFields returns the sorted names of the attributes that differ between two versions of a record.
+func changedFields(older Record, newer Record) []string {
+	oldFields, newFields := diffFields(older), diffFields(newer)
+	var changed []string
+	for name, value := range newFields {
+		if !reflect.DeepEqual(oldFields[name], value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+
+	// the JSON representation of parts includes the size of their data but not the data itself
+	oldMMS, _ := older.(*MMS)
+	newMMS, _ := newer.(*MMS)
+	if oldMMS != nil && newMMS != nil && mmsKey(oldMMS) != mmsKey(newMMS) {
+		if _, ok := newFields["parts"]; ok && reflect.DeepEqual(oldFields["parts"], newFields["parts"]) {
+			changed = append(changed, "parts")
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// diffRecords compares records by identity. Records with the same identity are paired in order.
+func diffRecords(d *Diff, oldRecords []Record, newRecords []Record) {
+	unmatched := make(map[string][]int)
+	for i, record := range oldRecords {
+		identity := diffIdentity(record)
+		unmatched[identity] = append(unmatched[identity], i)
+	}
+
+	matched := make([]bool, len(oldRecords))
+	for newIndex, record := range newRecords {
+		identity := diffIdentity(record)
+		candidates := unmatched[identity]
+		if len(candidates) == 0 {
+			d.Records = append(d.Records, DiffRecord{Change: DiffAdded, New: record, OldIndex: -1, NewIndex: newIndex})
+			continue
+		}
+
+		oldIndex := candidates[0]
+		unmatched[identity] = candidates[1:]
+		matched[oldIndex] = true
+		if changed := changedFields(oldRecords[oldIndex], record); len(changed) > 0 {
+			d.Records = append(d.Records, DiffRecord{
+				Change:        DiffModified,
+				Old:           oldRecords[oldIndex],
+				New:           record,
+				OldIndex:      oldIndex,
+				NewIndex:      newIndex,
+				ChangedFields: changed,
+			})
+		}
+	}
+
+	for oldIndex, record := range oldRecords {
+		if !matched[oldIndex] {
+			d.Records = append(d.Records, DiffRecord{Change: DiffRemoved, Old: record, OldIndex: oldIndex, NewIndex: -1})
+		}
+	}
+}
+
+// sort orders the records of d chronologically.
+func (d *Diff) sort() {
+	sort.SliceStable(d.Records, func(i, j int) bool {
+		_, a, _, _ := diffRecordInfo(d.Records[i].Record())
+		_, b, _, _ := diffRecordInfo(d.Records[j].Record())
+		return a.Time().Before(b.Time())
+	})
+}
+
+// DiffMessages compares two SMS backups of the same device. SMS and MMS messages are matched by their stable identity
+// (type of record, date, and normalized address), and matched messages whose other attributes differ are reported as
+// modified.
+func DiffMessages(older *Messages, newer *Messages) *Diff {
+	d := new(Diff)
+	var oldSMS, newSMS, oldMMS, newMMS []Record
+	for i := range older.SMS {
+		oldSMS = append(oldSMS, &older.SMS[i])
+	}
+	for i := range newer.SMS {
+		newSMS = append(newSMS, &newer.SMS[i])
+	}
+	for i := range older.MMS {
+		oldMMS = append(oldMMS, &older.MMS[i])
+	}
+	for i := range newer.MMS {
+		newMMS = append(newMMS, &newer.MMS[i])
+	}
+	diffRecords(d, oldSMS, newSMS)
+	diffRecords(d, oldMMS, newMMS)
+	d.sort()
+	return d
+}
+
+// DiffCalls compares two calls backups of the same device. Calls are matched by their date and normalized number,
+// and matched calls whose other attributes differ are reported as modified.
+func DiffCalls(older *Calls, newer *Calls) *Diff {
+	d := new(Diff)
+	var oldCalls, newCalls []Record
+	for i := range older.Calls {
+		oldCalls = append(oldCalls, &older.Calls[i])
+	}
+	for i := range newer.Calls {
+		newCalls = append(newCalls, &newer.Calls[i])
+	}
+	diffRecords(d, oldCalls, newCalls)
+	d.sort()
+	return d
+}
+
+// Summary counts the differences for each contact, ordered by contact.
+func (d *Diff) Summary() []DiffSummary {
+	byContact := make(map[string]*DiffSummary)
+	var contacts []string
+	for i := range d.Records {
+		r := &d.Records[i]
+		_, date, contact, name := diffRecordInfo(r.Record())
+		s := byContact[contact]
+		if s == nil {
+			s = &DiffSummary{Contact: contact}
+			byContact[contact] = s
+			contacts = append(contacts, contact)
+		}
+		if s.ContactName == "" && name != "(Unknown)" {
+			s.ContactName = name
+		}
+
+		switch r.Change {
+		case DiffAdded:
+			s.Added++
+		case DiffRemoved:
+			s.Removed++
+		case DiffModified:
+			s.Modified++
+		}
+		if s.First == "" || date.Time().Before(s.First.Time()) {
+			s.First = date
+		}
+		if s.Last == "" || date.Time().After(s.Last.Time()) {
+			s.Last = date
+		}
+	}
+
+	sort.Strings(contacts)
+	summary := make([]DiffSummary, len(contacts))
+	for i, contact := range contacts {
+		summary[i] = *byContact[contact]
+	}
+	return summary
+}
+
+// Counts returns the number of records added, removed, and modified.
+func (d *Diff) Counts() (added, removed, modified int) {
+	for _, r := range d.Records {
+		switch r.Change {
+		case DiffAdded:
+			added++
+		case DiffRemoved:
+			removed++
+		case DiffModified:
+			modified++
+		}
+	}
+	return added, removed, modified
+}
+
+// diffHeaders are the column headers of diff output.
+var diffHeaders = []string{
+	"Change",
+	"Record Type",
+	"Old Index #",
+	"New Index #",
+	"Date",
+	"Address",
+	"Contact Name",
+	"Type",
+	"Text",
+	"Changed Fields",
+}
+
+// diffSummaryHeaders are the column headers of diff summary output.
+var diffSummaryHeaders = []string{
+	"Address",
+	"Contact Name",
+	"Added",
+	"Removed",
+	"Modified",
+	"First Date",
+	"Last Date",
+}
+
+// diffIndex formats the index of a record in one of the compared backups, which is -1 if it is not in that backup.
+func diffIndex(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+// diffRow returns the columns of diff output for a record.
+func diffRow(r *DiffRecord, cfg *outputConfig) []string {
+	recordType, date, contact, name := diffRecordInfo(r.Record())
+	var typeName, text string
+	switch rec := r.Record().(type) {
+	case *SMS:
+		typeName, text = rec.Type.String(), rec.Body
+	case *MMS:
+		typeName = rec.Direction().String()
+		var parts []string
+		for _, part := range rec.Parts {
+			if part.ContentType == "text/plain" {
+				parts = append(parts, part.Text)
+			}
+		}
+		text = strings.Join(parts, " ")
+	case *Call:
+		typeName = rec.Type.String()
+	}
+
+	return []string{
+		r.Change.String(),
+		recordType,
+		diffIndex(r.OldIndex),
+		diffIndex(r.NewIndex),
+		cfg.formatTime(date),
+		contact,
+		name,
+		typeName,
+		cfg.text(text),
+		strings.Join(r.ChangedFields, ";"),
+	}
+}
+
+// GenerateDiffOutput outputs tab-delimited files named "diff.tsv", containing each record added, removed, or modified
+// between two backups, and "diff_summary.tsv", containing the number of differences and their date range for each
+// contact. Modified records are output as they appear in the newer backup.
+func GenerateDiffOutput(d *Diff, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+
+	diffOutput, err := os.Create(filepath.Join(outputDir, "diff.tsv"))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: diff.tsv\n%q", err)
+	}
+	defer diffOutput.Close()
+
+	table := newTableWriter(diffOutput, cfg)
+	table.writeHeader(diffHeaders)
+	for i := range d.Records {
+		table.writeRow(diffRow(&d.Records[i], cfg))
+	}
+	if err := table.flush(); err != nil {
+		return err
+	}
+
+	summaryOutput, err := os.Create(filepath.Join(outputDir, "diff_summary.tsv"))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: diff_summary.tsv\n%q", err)
+	}
+	defer summaryOutput.Close()
+
+	table = newTableWriter(summaryOutput, cfg)
+	table.writeHeader(diffSummaryHeaders)
+	for _, s := range d.Summary() {
+		table.writeRow([]string{
+			s.Contact,
+			s.ContactName,
+			strconv.Itoa(s.Added),
+			strconv.Itoa(s.Removed),
+			strconv.Itoa(s.Modified),
+			cfg.formatTime(s.First),
+			cfg.formatTime(s.Last),
+		})
+	}
+	return table.flush()
+}
+
+// diffJSON is the JSON representation of a DiffRecord.
+type diffJSON struct {
+	Change        string      `json:"change"`
+	RecordType    string      `json:"record_type"`
+	OldIndex      *int        `json:"old_index"`
+	NewIndex      *int        `json:"new_index"`
+	ChangedFields []string    `json:"changed_fields,omitempty"`
+	Old           interface{} `json:"old,omitempty"`
+	New           interface{} `json:"new,omitempty"`
+}
+
+// diffSummaryJSON is the JSON representation of a DiffSummary.
+type diffSummaryJSON struct {
+	Address     string        `json:"address"`
+	ContactName string        `json:"contact_name"`
+	Added       int           `json:"added"`
+	Removed     int           `json:"removed"`
+	Modified    int           `json:"modified"`
+	First       jsonTimestamp `json:"first_date"`
+	Last        jsonTimestamp `json:"last_date"`
+}
+
+// diffRecordJSON converts a record with the given index for JSON output.
+func diffRecordJSON(record Record, i int, cfg *outputConfig) interface{} {
+	switch rec := record.(type) {
+	case *SMS:
+		return newSMSJSON(i, rec, cfg)
+	case *MMS:
+		return newMMSJSON(i, rec, cfg)
+	case *Call:
+		return newCallJSON(i, rec, cfg)
+	}
+	return nil
+}
+
+// GenerateDiffJSON outputs JSON files named "diff.json" and "diff_summary.json" (or ".ndjson" with WithNDJSON)
+// containing the same information as GenerateDiffOutput. Each record includes the full old and new versions of the
+// record, as output by GenerateSMSJSON, GenerateMMSJSON, and GenerateCallJSON.
+func GenerateDiffJSON(d *Diff, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+	fileName := jsonFileName("diff", cfg)
+
+	diffOutput, err := os.Create(filepath.Join(outputDir, fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", fileName, err)
+	}
+	defer diffOutput.Close()
+
+	j := newJSONWriter(diffOutput, cfg)
+	for i := range d.Records {
+		r := &d.Records[i]
+		recordType, _, _, _ := diffRecordInfo(r.Record())
+		record := diffJSON{Change: r.Change.String(), RecordType: recordType, ChangedFields: r.ChangedFields}
+		if r.Old != nil {
+			record.OldIndex = &r.OldIndex
+			record.Old = diffRecordJSON(r.Old, r.OldIndex, cfg)
+		}
+		if r.New != nil {
+			record.NewIndex = &r.NewIndex
+			record.New = diffRecordJSON(r.New, r.NewIndex, cfg)
+		}
+		j.write(record)
+	}
+	if err := j.close(); err != nil {
+		return err
+	}
+
+	fileName = jsonFileName("diff_summary", cfg)
+	summaryOutput, err := os.Create(filepath.Join(outputDir, fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", fileName, err)
+	}
+	defer summaryOutput.Close()
+
+	j = newJSONWriter(summaryOutput, cfg)
+	for _, s := range d.Summary() {
+		j.write(diffSummaryJSON{
+			Address:     s.Contact,
+			ContactName: s.ContactName,
+			Added:       s.Added,
+			Removed:     s.Removed,
+			Modified:    s.Modified,
+			First:       newJSONTimestamp(s.First, cfg),
+			Last:        newJSONTimestamp(s.Last, cfg),
+		})
+	}
+	return j.close()
+}
@@ -0,0 +1,174 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// diffString describes a DiffRecord for comparison, e.g. "Modified SMS 0 1 [read]".
+func diffString(r DiffRecord) string {
+	recordType, _, _, _ := diffRecordInfo(r.Record())
+	return fmt.Sprintf("%s %s %d %d %v", r.Change, recordType, r.OldIndex, r.NewIndex, r.ChangedFields)
+}
+
+func TestDiffMessages(t *testing.T) {
+	sms := func(date AndroidTS, body string, read ReadStatus) SMS {
+		return SMS{Date: date, Address: "13125551212", Type: 1, Body: body, Read: read}
+	}
+	mms := func(date AndroidTS, text string, data string) MMS {
+		return MMS{Date: date, Address: "13125551212~13125553434", MessageBox: 1, Parts: []Part{
+			{ContentType: "text/plain", Text: text},
+			{ContentType: "image/png", Base64Data: data},
+		}}
+	}
+
+	tests := []struct {
+		name         string
+		older, newer *Messages
+		want         []string
+	}{
+		{
+			name:  "unchanged",
+			older: &Messages{SMS: []SMS{sms("1", "hi", 0)}, MMS: []MMS{mms("2", "look", "AAAA")}},
+			newer: &Messages{SMS: []SMS{sms("1", "hi", 0)}, MMS: []MMS{mms("2", "look", "AAAA")}},
+		},
+		{
+			name:  "added",
+			older: &Messages{SMS: []SMS{sms("1", "hi", 0)}},
+			newer: &Messages{SMS: []SMS{sms("1", "hi", 0), sms("2", "bye", 0)}, MMS: []MMS{mms("3", "look", "AAAA")}},
+			want:  []string{"Added SMS -1 1 []", "Added MMS -1 0 []"},
+		},
+		{
+			name:  "removed",
+			older: &Messages{SMS: []SMS{sms("1", "hi", 0), sms("2", "bye", 0)}, MMS: []MMS{mms("3", "look", "AAAA")}},
+			newer: &Messages{SMS: []SMS{sms("1", "hi", 0)}},
+			want:  []string{"Removed SMS 1 -1 []", "Removed MMS 0 -1 []"},
+		},
+		{
+			name:  "modified",
+			older: &Messages{SMS: []SMS{sms("1", "hi", 0)}},
+			newer: &Messages{SMS: []SMS{{Date: "1", Address: "(312) 555-1212", Type: 1, Body: "hi", Read: 1}}},
+			want:  []string{"Modified SMS 0 0 [address read]"},
+		},
+		{
+			name:  "MMS part text changed",
+			older: &Messages{MMS: []MMS{mms("1", "look", "AAAA")}},
+			newer: &Messages{MMS: []MMS{mms("1", "look!", "AAAA")}},
+			want:  []string{"Modified MMS 0 0 [parts]"},
+		},
+		{
+			name:  "MMS part data changed",
+			older: &Messages{MMS: []MMS{mms("1", "look", "AAAA")}},
+			newer: &Messages{MMS: []MMS{mms("1", "look", "BBBB")}},
+			want:  []string{"Modified MMS 0 0 [parts]"},
+		},
+		{
+			name:  "same date and address paired in order",
+			older: &Messages{SMS: []SMS{sms("1", "first", 0), sms("1", "second", 0)}},
+			newer: &Messages{SMS: []SMS{sms("1", "first", 0), sms("1", "second", 1)}},
+			want:  []string{"Modified SMS 1 1 [read]"},
+		},
+		{
+			name:  "same date and address, one removed",
+			older: &Messages{SMS: []SMS{sms("1", "first", 0), sms("1", "second", 0)}},
+			newer: &Messages{SMS: []SMS{sms("1", "first", 0)}},
+			want:  []string{"Removed SMS 1 -1 []"},
+		},
+		{
+			name:  "chronological order",
+			older: &Messages{SMS: []SMS{sms("3", "later", 0)}, MMS: []MMS{mms("1", "earlier", "AAAA")}},
+			newer: &Messages{SMS: []SMS{sms("2", "middle", 0)}},
+			want:  []string{"Removed MMS 0 -1 []", "Added SMS -1 0 []", "Removed SMS 0 -1 []"},
+		},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, r := range DiffMessages(tt.older, tt.newer).Records {
+			got = append(got, diffString(r))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffCalls(t *testing.T) {
+	older := &Calls{Calls: []Call{
+		{Date: "1", Number: "13125551212", Type: 1, Duration: 65},
+		{Date: "2", Number: "13125551212", Type: 3},
+		{Date: "3", Number: "13125553434", Type: 2, Duration: 10},
+	}}
+	newer := &Calls{Calls: []Call{
+		{Date: "1", Number: "+1 312 555 1212", Type: 1, Duration: 65, ContactName: "Alice"},
+		{Date: "3", Number: "13125553434", Type: 2, Duration: 12},
+		{Date: "4", Number: "13125553434", Type: 1, Duration: 5},
+	}}
+
+	var got []string
+	for _, r := range DiffCalls(older, newer).Records {
+		got = append(got, diffString(r))
+	}
+	want := []string{"Modified Call 0 0 [contact_name number]", "Removed Call 1 -1 []",
+		"Modified Call 2 1 [duration]", "Added Call -1 2 []"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffSummary(t *testing.T) {
+	older := &Messages{
+		SMS: []SMS{
+			{Date: "1704060000000", Address: "13125551212", Body: "removed", ContactName: "(Unknown)"},
+			{Date: "1704070000000", Address: "13125553434", Body: "read", ContactName: "Bob"},
+		},
+		MMS: []MMS{{Date: "1704065000000", Address: "13125551212~13125553434", ContactName: "Alice, Bob"}},
+	}
+	newer := &Messages{
+		SMS: []SMS{
+			{Date: "1704070000000", Address: "13125553434", Body: "read", ContactName: "Bob", Read: 1},
+			{Date: "1704080000000", Address: "(312) 555-1212", Body: "added", ContactName: "Smith, Jr., Alice"},
+			{Date: "1704050000000", Address: "13125551212", Body: "added earlier", ContactName: "(Unknown)"},
+		},
+	}
+
+	d := DiffMessages(older, newer)
+	want := []DiffSummary{
+		{Contact: "13125551212", ContactName: "Smith Jr., Alice", Added: 2, Removed: 1, First: "1704050000000",
+			Last: "1704080000000"},
+		{Contact: "13125551212;13125553434", ContactName: "Alice, Bob", Removed: 1, First: "1704065000000",
+			Last: "1704065000000"},
+		{Contact: "13125553434", ContactName: "Bob", Modified: 1, First: "1704070000000", Last: "1704070000000"},
+	}
+	if got := d.Summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got summary\n%+v\nwant\n%+v", got, want)
+	}
+	if added, removed, modified := d.Counts(); added != 2 || removed != 2 || modified != 1 {
+		t.Errorf("got %d added, %d removed, and %d modified, want 2, 2, and 1", added, removed, modified)
+	}
+}
@@ -25,6 +25,10 @@ SOFTWARE.
 package smsbackuprestore
 
 import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
 	"strings"
 	"unicode"
 	"regexp"
@@ -171,6 +175,21 @@ func GetFileExtensionFromContentType(contentType string) string {
 	return ext
 }
 
+// SafeFileName reduces a name from a backup, such as the name of an MMS part, to a file name that cannot refer to
+// another directory: directories are removed, characters that may not be allowed in file names are replaced with
+// "_", and leading and trailing dots and spaces are removed, so that "../../photo.jpg" becomes "photo.jpg". An empty
+// string is returned if nothing is left.
+func SafeFileName(name string) string {
+	name = path.Base(strings.Trim(strings.Replace(name, "\\", "/", -1), "/"))
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, ". ")
+}
+
 // CleanupMessageBody removes newlines and tabs from strings.
 func CleanupMessageBody(body string) string {
 	// strip unwanted characters from SMS body
@@ -179,3 +198,30 @@ func CleanupMessageBody(body string) string {
 	body = strings.Replace(body, "\t", " ", -1)
 	return body
 }
+
+// FormatAttributes formats XML attributes as space-delimited name="value" pairs, e.g. for outputting attributes that
+// are not recognized by this parser.
+func FormatAttributes(attrs []xml.Attr) string {
+	var pairs []string
+	for _, attr := range attrs {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", attr.Name.Local, attr.Value))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// parseNullableInt parses an integer attribute value, treating "null" (written by the SMS Backup & Restore app for
+// missing values) and empty values as 0.
+func parseNullableInt(value string) (int, error) {
+	if value == "" || value == "null" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// nullableIntString formats an integer attribute value parsed by parseNullableInt, returning an empty string for 0.
+func nullableIntString(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
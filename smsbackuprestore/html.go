@@ -0,0 +1,362 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportFS holds the templates and stylesheet of the HTML report, so it can be written without any network access.
+//
+//go:embed report
+var reportFS embed.FS
+
+var reportTemplates = template.Must(template.ParseFS(reportFS, "report/*.html"))
+
+// chatEvent is a single message or call in a conversation of the HTML report.
+type chatEvent struct {
+	date        AndroidTS
+	millis      int64
+	Time        string
+	Kind        string // SMS or MMS
+	Sent        bool
+	Sender      string // shown for received messages in group conversations
+	Text        string
+	Images      []string
+	Attachments []string
+	Call        bool
+	Missed      bool
+}
+
+// chatConversation is the messages and calls exchanged with a contact, or a group of contacts, in the HTML report.
+type chatConversation struct {
+	Title      string
+	Numbers    []string
+	Events     []chatEvent
+	SMS        int
+	MMS        int
+	Calls      int
+	First      string
+	Last       string
+	FileName   string
+	lastMillis int64
+}
+
+// ChatReport collects the messages and calls of one or more backups into conversations for GenerateHTMLReport.
+type ChatReport struct {
+	conversations map[string]*chatConversation // keyed by normalized number(s) of the participants
+	names         map[string]string            // contact names keyed by normalized number
+}
+
+// conversation returns the conversation with the given participants, creating it if necessary.
+func (r *ChatReport) conversation(numbers []string) *chatConversation {
+	if r.conversations == nil {
+		r.conversations = make(map[string]*chatConversation)
+	}
+
+	sort.Strings(numbers)
+	key := strings.Join(numbers, ";")
+	c, ok := r.conversations[key]
+	if !ok {
+		c = &chatConversation{Numbers: numbers}
+		r.conversations[key] = c
+	}
+	return c
+}
+
+// addName records the contact name of a number, ignoring the placeholder the app writes for unknown contacts.
+func (r *ChatReport) addName(number string, name string) {
+	if r.names == nil {
+		r.names = make(map[string]string)
+	}
+	name = strings.TrimSpace(name)
+	if name != "" && name != "(Unknown)" && r.names[number] == "" {
+		r.names[number] = name
+	}
+}
+
+// AddMessages adds all SMS and MMS messages of the backup read from source (the path of the backup file) to the
+// report. Image parts of MMS messages reference the files written by DecodeAttachments with WithSource(source).
+func (r *ChatReport) AddMessages(m *Messages, source string) {
+	cfg := newOutputConfig([]OutputOption{WithSource(source)})
+	for i := range m.SMS {
+		r.addSMS(&m.SMS[i])
+	}
+	for mmsIndex := range m.MMS {
+		r.addMMS(mmsIndex, &m.MMS[mmsIndex], cfg)
+	}
+}
+
+// AddCalls adds all calls to the report as events in the conversation with the same number.
+func (r *ChatReport) AddCalls(calls *Calls) {
+	for i := range calls.Calls {
+		r.addCall(&calls.Calls[i])
+	}
+}
+
+// addSMS adds an SMS message to the conversation with its number.
+func (r *ChatReport) addSMS(sms *SMS) {
+	number := sms.Address.String()
+	c := r.conversation([]string{number})
+	r.addName(number, RemoveCommasBeforeSuffixes(sms.ContactName))
+	c.SMS++
+	c.Events = append(c.Events, chatEvent{
+		date:   sms.Date,
+		millis: sms.Date.Time().UnixMilli(),
+		Kind:   "SMS",
+		Sent:   sms.Type != 1, // everything but received messages was composed on the device
+		Text:   sms.Body,
+	})
+}
+
+// addMMS adds the MMS message with the given index to the conversation with its participants. Its image parts
+// reference the files written by DecodeAttachments with the source of cfg.
+func (r *ChatReport) addMMS(mmsIndex int, mms *MMS, cfg *outputConfig) {
+	var numbers []string
+	for _, number := range strings.Split(string(mms.Address), "~") {
+		numbers = append(numbers, PhoneNumber(number).String())
+	}
+	names := strings.Split(RemoveCommasBeforeSuffixes(mms.ContactName), ",")
+	if len(names) == len(numbers) {
+		for i, number := range numbers {
+			r.addName(number, names[i])
+		}
+	}
+
+	event := chatEvent{
+		date:   mms.Date,
+		millis: mms.Date.Time().UnixMilli(),
+		Kind:   "MMS",
+		Sent:   mms.Direction() == DirectionSent,
+	}
+	if len(numbers) > 1 && !event.Sent {
+		event.Sender = mms.Sender().String()
+	}
+
+	var text []string
+	for partIndex, part := range mms.Parts {
+		switch {
+		case strings.Contains(part.ContentType, "image/"):
+			if imagePath := cfg.attachmentPath(&part, mmsIndex, partIndex); imagePath != "" {
+				event.Images = append(event.Images, path.Join("..", imagePath))
+			}
+		case part.ContentType == "text/plain":
+			text = append(text, part.Text)
+		case part.ContentType != "application/smil":
+			name := part.Name
+			if name == "" || name == "null" {
+				name = part.ContentType
+			}
+			event.Attachments = append(event.Attachments, "Attachment: "+name)
+		}
+	}
+	event.Text = strings.Join(text, "\n")
+
+	c := r.conversation(numbers)
+	c.MMS++
+	c.Events = append(c.Events, event)
+}
+
+// addCall adds a call as an event in the conversation with its number.
+func (r *ChatReport) addCall(call *Call) {
+	number := call.Number.String()
+	c := r.conversation([]string{number})
+	r.addName(number, RemoveCommasBeforeSuffixes(call.ContactName))
+	c.Calls++
+
+	text := fmt.Sprintf("%s call", call.Type.String())
+	if call.Duration > 0 {
+		text += fmt.Sprintf(" (%s)", time.Duration(call.Duration)*time.Second)
+	}
+	c.Events = append(c.Events, chatEvent{
+		date:   call.Date,
+		millis: call.Date.Time().UnixMilli(),
+		Text:   text,
+		Call:   true,
+		Missed: call.Type == 3 || call.Type == 5, // missed or rejected
+	})
+}
+
+// htmlRecordWriter is the RecordWriter of the html format. It collects records into a ChatReport, which is written by
+// GenerateHTMLReport when it is closed.
+type htmlRecordWriter struct {
+	report    ChatReport
+	outputDir string
+	opts      []OutputOption
+	cfg       *outputConfig
+}
+
+// newHTMLRecordWriter returns an htmlRecordWriter writing a report to outputDir according to opts.
+func newHTMLRecordWriter(outputDir string, opts []OutputOption) *htmlRecordWriter {
+	return &htmlRecordWriter{outputDir: outputDir, opts: opts, cfg: newOutputConfig(opts)}
+}
+
+// BeginBackup starts the records of another backup, whose images are referenced as with WithSource(source).
+func (h *htmlRecordWriter) BeginBackup(source string, hdr *Header) error {
+	h.cfg.source = sourceName(source)
+	return nil
+}
+
+// WriteSMS adds an SMS message to the report.
+func (h *htmlRecordWriter) WriteSMS(i int, sms *SMS) error {
+	h.report.addSMS(sms)
+	return nil
+}
+
+// WriteMMS adds the MMS message with the given index to the report.
+func (h *htmlRecordWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	h.report.addMMS(mmsIndex, mms, h.cfg)
+	return nil
+}
+
+// WriteCall adds a call to the report.
+func (h *htmlRecordWriter) WriteCall(i int, call *Call) error {
+	h.report.addCall(call)
+	return nil
+}
+
+// Close writes the report with GenerateHTMLReport.
+func (h *htmlRecordWriter) Close() error {
+	return GenerateHTMLReport(&h.report, h.outputDir, h.opts...)
+}
+
+// sortedConversations orders the events of each conversation chronologically, formats their timestamps, and returns
+// the conversations ordered by most recent activity.
+func (r *ChatReport) sortedConversations(cfg *outputConfig) []*chatConversation {
+	var conversations []*chatConversation
+	for _, c := range r.conversations {
+		sort.SliceStable(c.Events, func(i, j int) bool {
+			return c.Events[i].millis < c.Events[j].millis
+		})
+		for i := range c.Events {
+			e := &c.Events[i]
+			e.Time = cfg.formatTime(e.date)
+			if e.Sender != "" {
+				e.Sender = r.displayName(e.Sender)
+			}
+		}
+		if n := len(c.Events); n > 0 {
+			c.First = c.Events[0].Time
+			c.Last = c.Events[n-1].Time
+			c.lastMillis = c.Events[n-1].millis
+		}
+
+		var names []string
+		for _, number := range c.Numbers {
+			names = append(names, r.displayName(number))
+		}
+		c.Title = strings.Join(names, ", ")
+		conversations = append(conversations, c)
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		if conversations[i].lastMillis != conversations[j].lastMillis {
+			return conversations[i].lastMillis > conversations[j].lastMillis
+		}
+		return conversations[i].Title < conversations[j].Title
+	})
+	for i, c := range conversations {
+		c.FileName = fmt.Sprintf("conversation-%d.html", i+1)
+	}
+	return conversations
+}
+
+// displayName returns the contact name of a number, or the number itself if the name is not known.
+func (r *ChatReport) displayName(number string) string {
+	if name := r.names[number]; name != "" {
+		return name
+	}
+	if number == "" || number == "null" {
+		return "(Unknown)"
+	}
+	return number
+}
+
+// GenerateHTMLReport outputs an HTML report to a directory named "html" that renders each conversation as a chat, with
+// sent and received messages on opposite sides, images written by DecodeAttachments shown inline, and calls interleaved
+// as events. Its index.html file lists every conversation with counts and date ranges. The report does not reference
+// any external resources, so it can be viewed offline.
+func GenerateHTMLReport(r *ChatReport, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+	htmlDir := filepath.Join(outputDir, "html")
+	if err := os.MkdirAll(htmlDir, os.ModePerm); err != nil {
+		return fmt.Errorf("Unable to create directory: %s\n%q", htmlDir, err)
+	}
+
+	css, err := reportFS.ReadFile("report/report.css")
+	if err != nil {
+		return err
+	}
+
+	conversations := r.sortedConversations(cfg)
+	index := struct {
+		CSS           template.CSS
+		Conversations []*chatConversation
+		SMS           int
+		MMS           int
+		Calls         int
+	}{CSS: template.CSS(css), Conversations: conversations}
+	for _, c := range conversations {
+		index.SMS += c.SMS
+		index.MMS += c.MMS
+		index.Calls += c.Calls
+	}
+	if err := writeHTMLPage(filepath.Join(htmlDir, "index.html"), "index.html", index); err != nil {
+		return err
+	}
+
+	for _, c := range conversations {
+		page := struct {
+			CSS          template.CSS
+			Conversation *chatConversation
+		}{template.CSS(css), c}
+		if err := writeHTMLPage(filepath.Join(htmlDir, c.FileName), "conversation.html", page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHTMLPage executes the named template of the HTML report with the given data and writes it to a file.
+func writeHTMLPage(outputPath string, templateName string, data interface{}) error {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", outputPath, err)
+	}
+	defer f.Close()
+
+	if err := reportTemplates.ExecuteTemplate(f, templateName, data); err != nil {
+		return fmt.Errorf("Error writing HTML report %s: %q", outputPath, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,114 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHTMLRecordWriter(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	w, err := NewRecordWriter("html", dir, WithTimeLayout(TimeLayoutISO8601))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBackup(w, "sms.xml", &Backup{Messages: m}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBackup(w, "calls.xml", &Backup{Calls: c}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := make(map[string]string)
+	files, err := filepath.Glob(filepath.Join(dir, "html", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(b)
+		pages[filepath.Base(f)] = page
+		// viewable offline
+		if strings.Contains(page, "http:") || strings.Contains(page, "https:") || strings.Contains(page, "<script") {
+			t.Errorf("%s references external resources or scripts", filepath.Base(f))
+		}
+	}
+	if len(pages) != 5 {
+		t.Fatalf("got pages %v, want index.html and 4 conversations", files)
+	}
+
+	// conversations are listed by most recent activity, titled by contact name
+	index := pages["index.html"]
+	if !strings.Contains(index, "4 conversations &middot; 2 SMS &middot; 1 MMS &middot; 2 calls") {
+		t.Errorf("index.html does not count the records:\n%s", index)
+	}
+	var titles []string
+	link := regexp.MustCompile(`<a href="conversation-\d+.html">([^<]*)</a>`)
+	for _, match := range link.FindAllStringSubmatch(index, -1) {
+		titles = append(titles, match[1])
+	}
+	if want := []string{"Bob", "Alice, Bob", "Smith Jr., Bob", "Alice"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got conversations %q, want %q", titles, want)
+	}
+
+	// a call and a message with the same number are interleaved chronologically
+	bob := pages["conversation-1.html"]
+	call, sms := strings.Index(bob, `class="event call missed"`), strings.Index(bob, `class="event sent"`)
+	if call < 0 || sms < call {
+		t.Errorf("conversation-1.html does not show the missed call before the sent SMS:\n%s", bob)
+	}
+
+	// group messages show their sender and images decoded from the backup
+	group := pages["conversation-2.html"]
+	for _, want := range []string{`<div class="sender">Alice</div>`, `<img src="../images/sms_image.png_0-2.png"`} {
+		if !strings.Contains(group, want) {
+			t.Errorf("conversation-2.html does not contain %s:\n%s", want, group)
+		}
+	}
+
+	// message text is escaped
+	if want := "&#34;Tom &amp; Jerry&#34; &lt;3"; !strings.Contains(pages["conversation-3.html"], want) {
+		t.Errorf("conversation-3.html does not contain %s:\n%s", want, pages["conversation-3.html"])
+	}
+}
@@ -0,0 +1,555 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportTSV rebuilds an SMS or calls backup from the tab-delimited output of GenerateSMSOutput or GenerateCallOutput
+// (sms.tsv or calls.tsv), e.g. after editing it in a spreadsheet, so that it can be written with WriteBackupXML and
+// restored by the app. Dates are imported from the raw date columns, which contain them to the millisecond as they
+// appear in the backup, unless the formatted date column has been edited. Formatted dates are parsed using the time
+// zone and layout given by opts, which must match those the output was generated with. Without the raw date columns,
+// the layout must include milliseconds (e.g. TimeLayoutISO8601), as the app identifies duplicate messages by their
+// dates, so TimeLayoutDefault and TimeLayoutRFC3339 cannot be imported.
+//
+// The type of backup is determined by the header row. Columns may be reordered or removed (except Date, and Number for
+// calls), as the header names them. Output generated without a header row (see HeaderNone) must have all of its
+// columns in their original order. Enumerated values such as Type and Status are validated against the names output
+// by this package, and may also be given as the integers stored in the backup. The Index and UTC Offset columns are
+// ignored. Phone numbers and contact names are likewise imported from the raw columns, unless the normalized column has
+// been edited or the raw column removed.
+//
+// Since TSV output replaces line breaks and tabs in message bodies with spaces, import the output of GenerateSMSCSV
+// instead to keep message bodies intact. MMS output cannot be imported, as it does not contain attachment data.
+func ImportTSV(r io.Reader, opts ...OutputOption) (*Backup, error) {
+	br := bufio.NewReader(r)
+	lineNumber := 0
+	return importTable(func() ([]string, int, error) {
+		for {
+			line, err := br.ReadString('\n')
+			if line == "" && err != nil {
+				return nil, 0, err
+			}
+			lineNumber++
+			if err != nil && err != io.EOF {
+				return nil, 0, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line != "" {
+				return strings.Split(line, "\t"), lineNumber, nil
+			}
+		}
+	}, newOutputConfig(opts))
+}
+
+// ImportCSV rebuilds an SMS or calls backup from the comma-separated output of GenerateSMSCSV or GenerateCallCSV
+// (sms.csv or calls.csv), as with ImportTSV. Fields are separated by the delimiter given by opts (see WithDelimiter).
+// Message bodies are imported exactly as they appear in the file, including carriage returns, which encoding/csv would
+// remove from line breaks within quoted fields.
+func ImportCSV(r io.Reader, opts ...OutputOption) (*Backup, error) {
+	cfg := newOutputConfig(opts)
+	cr := &csvReader{r: bufio.NewReader(r), delimiter: cfg.delimiter}
+	return importTable(cr.read, cfg)
+}
+
+// csvReader reads records of RFC 4180 CSV, keeping quoted fields exactly as they appear in the file. Records may end
+// with a line feed or a carriage return and line feed, and blank lines are skipped.
+type csvReader struct {
+	r          *bufio.Reader
+	delimiter  rune
+	lineNumber int
+}
+
+// read returns the fields of the next record that is not a blank line and the line on which it starts, or io.EOF
+// after the last record.
+func (c *csvReader) read() ([]string, int, error) {
+	for {
+		fields, lineNumber, err := c.readRecord()
+		if err != nil || len(fields) > 1 || fields[0] != "" {
+			return fields, lineNumber, err
+		}
+	}
+}
+
+// readRecord returns the fields of the next record and the line on which it starts, or io.EOF after the last record.
+func (c *csvReader) readRecord() ([]string, int, error) {
+	if _, err := c.r.Peek(1); err != nil {
+		return nil, 0, err
+	}
+	c.lineNumber++
+	lineNumber := c.lineNumber
+
+	var fields []string
+	var field strings.Builder
+	for {
+		r, _, err := c.r.ReadRune()
+		quoted := err == nil && r == '"'
+		if quoted {
+			// quoted field, which ends at a quote that is not followed by another quote
+			for {
+				if r, _, err = c.r.ReadRune(); err == io.EOF {
+					return nil, 0, fmt.Errorf("Line %d: quoted field is not terminated", lineNumber)
+				} else if err != nil {
+					return nil, 0, err
+				}
+				if r == '"' {
+					if r, _, err = c.r.ReadRune(); err != nil || r != '"' {
+						break
+					}
+				} else if r == '\n' {
+					c.lineNumber++
+				}
+				field.WriteRune(r)
+			}
+			if err == nil && r == '\r' {
+				if r, _, err = c.r.ReadRune(); err == nil && r != '\n' {
+					return nil, 0, fmt.Errorf("Line %d: unexpected carriage return after quoted field", c.lineNumber)
+				}
+			}
+			if err == nil && r != c.delimiter && r != '\n' {
+				return nil, 0, fmt.Errorf("Line %d: unexpected %q after quoted field", c.lineNumber, r)
+			}
+		} else {
+			for err == nil && r != c.delimiter && r != '\n' {
+				field.WriteRune(r)
+				r, _, err = c.r.ReadRune()
+			}
+		}
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+
+		value := field.String()
+		field.Reset()
+		if err == nil && r == c.delimiter {
+			fields = append(fields, value)
+			continue
+		}
+		if !quoted && err == nil {
+			value = strings.TrimSuffix(value, "\r") // record terminated by a carriage return and line feed
+		}
+		return append(fields, value), lineNumber, nil
+	}
+}
+
+// importRow is a row of delimited output being imported, with its columns looked up by snake case header name.
+type importRow struct {
+	fields     []string
+	columns    map[string]int
+	lineNumber int
+	cfg        *outputConfig
+}
+
+// get returns the value of the named column, or an empty string if the column was removed.
+func (row *importRow) get(column string) string {
+	value, _ := row.lookup(column)
+	return value
+}
+
+// lookup returns the value of the named column and whether the column is present.
+func (row *importRow) lookup(column string) (string, bool) {
+	if i, ok := row.columns[column]; ok {
+		return row.fields[i], true
+	}
+	return "", false
+}
+
+// importRaw returns the value of the raw column for the named column if normalize(raw) equals the value of the named
+// column, so that values are imported as they appear in the backup unless the named column has been edited. Otherwise,
+// the value of the named column is returned.
+func (row *importRow) importRaw(column string, normalize func(string) string) string {
+	raw, ok := row.lookup("raw_" + column)
+	if !ok {
+		return row.get(column)
+	}
+	value, ok := row.lookup(column)
+	if !ok || normalize(raw) == value {
+		return raw
+	}
+	return value
+}
+
+// errorf returns an error identifying the line of the row.
+func (row *importRow) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Line %d: %s", row.lineNumber, fmt.Sprintf(format, args...))
+}
+
+// invalid returns an error for a value of the named column that cannot be imported.
+func (row *importRow) invalid(column string) error {
+	return row.errorf("invalid %s: %q", column, row.get(column))
+}
+
+// importTable rebuilds a backup from rows of delimited output returned by next, which returns io.EOF after the last
+// row.
+func importTable(next func() ([]string, int, error), cfg *outputConfig) (*Backup, error) {
+	fields, lineNumber, err := next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty file: %w", ErrUnknownBackupType)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		fields[0] = strings.TrimPrefix(fields[0], "\uFEFF") // byte order mark added by spreadsheet applications
+	}
+
+	headers, backupType := importHeaders(fields)
+	if backupType == UnknownBackup && isHeaderRow(fields, mmsHeaders) {
+		return nil, errors.New("MMS output cannot be imported, as it does not contain attachment data")
+	}
+	header := backupType != UnknownBackup
+	if !header {
+		// without a header row, the type of backup is determined by the number of columns
+		switch len(fields) {
+		case len(smsHeaders):
+			headers, backupType = smsHeaders, MessagesBackup
+		case len(callHeaders):
+			headers, backupType = callHeaders, CallsBackup
+		default:
+			return nil, fmt.Errorf("Line %d: %d columns: %w", lineNumber, len(fields), ErrUnknownBackupType)
+		}
+	}
+
+	columns := make(map[string]int, len(headers))
+	for i, h := range headers {
+		name := SnakeCaseHeader(h)
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("Line %d: duplicate column: %s", lineNumber, h)
+		}
+		columns[name] = i
+	}
+	required := []string{"date"}
+	if backupType == CallsBackup {
+		required = append(required, "number")
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("Line %d: missing column: %s", lineNumber, name)
+		}
+	}
+	if _, ok := columns["raw_date"]; !ok && !keepsMilliseconds(cfg.timeLayout, cfg.location) {
+		return nil, fmt.Errorf("time layout does not include milliseconds and there is no raw_date column, so dates "+
+			"cannot be imported exactly: %q", cfg.timeLayout)
+	}
+
+	b := &Backup{}
+	if backupType == MessagesBackup {
+		b.Messages = &Messages{XMLName: xml.Name{Local: "smses"}}
+	} else {
+		b.Calls = &Calls{XMLName: xml.Name{Local: "calls"}}
+	}
+
+	for {
+		if header {
+			if fields, lineNumber, err = next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		header = true
+
+		if len(fields) != len(headers) {
+			return nil, fmt.Errorf("Line %d: expected %d columns, found %d", lineNumber, len(headers), len(fields))
+		}
+		row := &importRow{fields: fields, columns: columns, lineNumber: lineNumber, cfg: cfg}
+		if b.Messages != nil {
+			sms, err := importSMS(row)
+			if err != nil {
+				return nil, err
+			}
+			b.Messages.SMS = append(b.Messages.SMS, *sms)
+		} else {
+			call, err := importCall(row)
+			if err != nil {
+				return nil, err
+			}
+			b.Calls.Calls = append(b.Calls.Calls, *call)
+		}
+	}
+
+	if b.Messages != nil {
+		b.Messages.Count = strconv.Itoa(len(b.Messages.SMS))
+	} else {
+		b.Calls.Count = strconv.Itoa(len(b.Calls.Calls))
+	}
+	return b, nil
+}
+
+// importHeaders identifies fields as the header row of SMS or calls output, in either HeaderStyle. UnknownBackup is
+// returned if fields are not a header row.
+func importHeaders(fields []string) ([]string, BackupType) {
+	switch {
+	case isHeaderRow(fields, smsHeaders):
+		return fields, MessagesBackup
+	case isHeaderRow(fields, callHeaders):
+		return fields, CallsBackup
+	}
+	return nil, UnknownBackup
+}
+
+// isHeaderRow returns true if each of fields names one of headers, in either HeaderStyle.
+func isHeaderRow(fields []string, headers []string) bool {
+	for _, field := range fields {
+		found := false
+		for _, h := range headers {
+			if SnakeCaseHeader(field) == SnakeCaseHeader(h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+// importSMS rebuilds the SMS in row.
+func importSMS(row *importRow) (*SMS, error) {
+	sms := &SMS{
+		XMLName:          xml.Name{Local: "sms"},
+		Protocol:         row.get("protocol"),
+		Address:          importPhoneNumber(row, "address"),
+		Subject:          row.get("subject"),
+		Body:             row.get("body"),
+		ServiceCenter:    importPhoneNumber(row, "service_center"),
+		ReadableDate:     row.get("readable_date"),
+		ContactName:      importContactName(row),
+		TypeOfAddress:    row.get("toa"),
+		ServiceCenterTOA: row.get("service_center_toa"),
+		SubscriptionID:   row.get("subscription_id"),
+		ThreadID:         row.get("thread_id"),
+		ErrorCode:        row.get("error_code"),
+		SimSlot:          row.get("sim_slot"),
+		SimIMSI:          row.get("sim_imsi"),
+	}
+
+	var err error
+	var i int
+	if i, err = importListName(row, "type", smsMessageTypeNames, 0); err != nil {
+		return nil, err
+	}
+	sms.Type = SMSMessageType(i)
+	if i, err = importMapName(row, "status", smsStatusNames, -1); err != nil {
+		return nil, err
+	}
+	sms.Status = SMSStatus(i)
+	if i, err = importMapName(row, "read", readStatusNames, 0); err != nil {
+		return nil, err
+	}
+	sms.Read = ReadStatus(i)
+	if i, err = importMapName(row, "locked", boolValueNames, 0); err != nil {
+		return nil, err
+	}
+	sms.Locked = BoolValue(i)
+	if i, err = importMapName(row, "seen", boolValueNames, 0); err != nil {
+		return nil, err
+	}
+	sms.Seen = BoolValue(i)
+	if sms.Date, err = importTimestamp(row, "date"); err != nil {
+		return nil, err
+	}
+	if sms.DateSent, err = importTimestamp(row, "date_sent"); err != nil {
+		return nil, err
+	}
+	if sms.OtherAttributes, err = ParseAttributes(row.get("other_attributes")); err != nil {
+		return nil, row.errorf("invalid other_attributes: %v", err)
+	}
+	return sms, nil
+}
+
+// importCall rebuilds the call in row.
+func importCall(row *importRow) (*Call, error) {
+	call := &Call{
+		XMLName:                   xml.Name{Local: "call"},
+		Number:                    importPhoneNumber(row, "number"),
+		ReadableDate:              row.get("readable_date"),
+		ContactName:               importContactName(row),
+		SubscriptionID:            row.get("subscription_id"),
+		SubscriptionComponentName: row.get("subscription_component_name"),
+		PostDialDigits:            row.get("post_dial_digits"),
+	}
+
+	var err error
+	if duration := row.get("duration_seconds"); duration != "" {
+		if call.Duration, err = strconv.Atoi(duration); err != nil {
+			return nil, row.invalid("duration_seconds")
+		}
+	}
+	if call.Date, err = importTimestamp(row, "date"); err != nil {
+		return nil, err
+	}
+	var i int
+	if i, err = importListName(row, "type", callTypeNames, 0); err != nil {
+		return nil, err
+	}
+	call.Type = CallType(i)
+	if i, err = importListName(row, "presentation", callPresentationNames, 0); err != nil {
+		return nil, err
+	}
+	call.Presentation = CallPresentation(i)
+	if call.Features, err = importCallFeatures(row); err != nil {
+		return nil, err
+	}
+	if call.OtherAttributes, err = ParseAttributes(row.get("other_attributes")); err != nil {
+		return nil, row.errorf("invalid other_attributes: %v", err)
+	}
+	return call, nil
+}
+
+// importPhoneNumber imports the named phone number column, which is normalized (see PhoneNumber.String).
+func importPhoneNumber(row *importRow, column string) PhoneNumber {
+	return PhoneNumber(row.importRaw(column, func(raw string) string {
+		return PhoneNumber(raw).String()
+	}))
+}
+
+// importContactName imports the contact name column, from which commas before suffixes are removed.
+func importContactName(row *importRow) string {
+	return row.importRaw("contact_name", RemoveCommasBeforeSuffixes)
+}
+
+// importListName imports the named column of an enumerated type whose String method looks up names (numbered from 1)
+// in names, returning empty for an empty value. Integers are also accepted, as output for values without a name.
+func importListName(row *importRow, column string, names []string, empty int) (int, error) {
+	value := row.get(column)
+	if value == "" {
+		return empty, nil
+	}
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return i + 1, nil
+		}
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, row.invalid(column)
+	}
+	return i, nil
+}
+
+// importMapName imports the named column of an enumerated type whose String method looks up names in names, returning
+// empty for an empty value. Integers are also accepted.
+func importMapName(row *importRow, column string, names map[int]string, empty int) (int, error) {
+	value := row.get(column)
+	if value == "" {
+		return empty, nil
+	}
+	for v, name := range names {
+		if strings.EqualFold(value, name) {
+			return v, nil
+		}
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, row.invalid(column)
+	}
+	return i, nil
+}
+
+// importCallFeatures imports the semicolon-delimited call features of row (see CallFeatures.String).
+func importCallFeatures(row *importRow) (CallFeatures, error) {
+	var features CallFeatures
+	value := row.get("features")
+	if value == "" {
+		return features, nil
+	}
+
+	for _, feature := range strings.Split(value, ";") {
+		feature = strings.TrimSpace(feature)
+		found := false
+		for i, name := range callFeatureNames {
+			if strings.EqualFold(feature, name) {
+				features |= 1 << uint(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			// bits without a name are output as an integer
+			i, err := strconv.Atoi(feature)
+			if err != nil {
+				return 0, row.invalid("features")
+			}
+			features |= CallFeatures(i)
+		}
+	}
+	return features, nil
+}
+
+// importTimestamp imports the named timestamp column, formatted according to the output settings or, as output for
+// timestamps that are not valid, as milliseconds since the Unix epoch or "null". The raw column, which contains the
+// milliseconds since the Unix epoch, is imported instead unless the named column has been edited.
+func importTimestamp(row *importRow, column string) (AndroidTS, error) {
+	value := row.importRaw(column, func(raw string) string {
+		return row.cfg.formatTime(AndroidTS(raw))
+	})
+	if value == "" || value == "null" {
+		return AndroidTS(value), nil
+	}
+	if t, err := time.ParseInLocation(row.cfg.timeLayout, value, row.cfg.location); err == nil {
+		return AndroidTS(strconv.FormatInt(t.UnixMilli(), 10)), nil
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return AndroidTS(value), nil
+	}
+	return "", row.invalid(column)
+}
+
+// keepsMilliseconds returns true if timestamps formatted using layout in loc are parsed back to the millisecond.
+func keepsMilliseconds(layout string, loc *time.Location) bool {
+	t := time.Date(2018, 1, 2, 3, 4, 5, 678000000, loc)
+	parsed, err := time.ParseInLocation(layout, t.Format(layout), loc)
+	return err == nil && parsed.Equal(t)
+}
+
+// ParseAttributes parses XML attributes formatted by FormatAttributes as space-delimited name="value" pairs.
+func ParseAttributes(s string) ([]xml.Attr, error) {
+	var attrs []xml.Attr
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " ") {
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return nil, errors.New("expected name=\"value\"")
+		}
+		name := s[:i]
+		quoted, err := strconv.QuotedPrefix(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("value of %s: %w", name, err)
+		}
+		value, _ := strconv.Unquote(quoted)
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		s = s[i+1+len(quoted):]
+	}
+	return attrs, nil
+}
@@ -0,0 +1,238 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importRoundTrip generates the delimited output of backup b in a temporary directory, using generate, and imports it
+// using importFile.
+func importRoundTrip(t *testing.T, name string, generate func(dir string, opts ...OutputOption) error,
+	importFile func(f *os.File, opts ...OutputOption) (*Backup, error)) *Backup {
+	t.Helper()
+	location, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	opts := []OutputOption{WithTimeZone(location), WithTimeLayout(TimeLayoutISO8601), WithDelimiter(';')}
+
+	dir := t.TempDir()
+	if err := generate(dir, opts...); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := importFile(f, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func importCSVFile(f *os.File, opts ...OutputOption) (*Backup, error) {
+	return ImportCSV(f, opts...)
+}
+
+func TestImportCSVRoundTrip(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := importRoundTrip(t, "sms.csv", func(dir string, opts ...OutputOption) error {
+		return GenerateSMSCSV(m, dir, opts...)
+	}, importCSVFile)
+	if b.Messages == nil {
+		t.Fatalf("imported calls backup from sms.csv")
+	}
+	if b.Messages.Count != "2" {
+		t.Errorf("got count %q, want %q", b.Messages.Count, "2")
+	}
+	if !reflect.DeepEqual(b.Messages.SMS, m.SMS) {
+		t.Errorf("round trip changed messages:\ngot  %+v\nwant %+v", b.Messages.SMS, m.SMS)
+	}
+
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = importRoundTrip(t, "calls.csv", func(dir string, opts ...OutputOption) error {
+		return GenerateCallCSV(c, dir, opts...)
+	}, importCSVFile)
+	if b.Calls == nil {
+		t.Fatalf("imported messages backup from calls.csv")
+	}
+	if !reflect.DeepEqual(b.Calls.Calls, c.Calls) {
+		t.Errorf("round trip changed calls:\ngot  %+v\nwant %+v", b.Calls.Calls, c.Calls)
+	}
+}
+
+func TestImportTSVWithoutHeader(t *testing.T) {
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := importRoundTrip(t, "calls.tsv", func(dir string, opts ...OutputOption) error {
+		return GenerateCallOutput(c, dir, append(opts, WithHeaderStyle(HeaderNone))...)
+	}, func(f *os.File, opts ...OutputOption) (*Backup, error) {
+		return ImportTSV(f, opts...)
+	})
+	if b.Calls == nil {
+		t.Fatalf("imported messages backup from calls.tsv")
+	}
+	if !reflect.DeepEqual(b.Calls.Calls, c.Calls) {
+		t.Errorf("round trip changed calls:\ngot  %+v\nwant %+v", b.Calls.Calls, c.Calls)
+	}
+}
+
+func TestImportTimeLayoutWithoutMilliseconds(t *testing.T) {
+	const tsv = "Number\tDate\n13125551212\t2024-01-01T00:00:00Z\n"
+	for _, layout := range []string{TimeLayoutDefault, TimeLayoutRFC3339} {
+		if _, err := ImportTSV(strings.NewReader(tsv), WithTimeLayout(layout)); err == nil {
+			t.Errorf("layout %q: got no error", layout)
+		}
+	}
+	b, err := ImportTSV(strings.NewReader(tsv), WithTimeLayout(time.RFC3339Nano))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Calls.Calls[0].Date; got != "1704067200000" {
+		t.Errorf("got date %q, want %q", got, "1704067200000")
+	}
+}
+
+func TestImportRawDates(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// statuses without a name are output and imported as integers
+	m.SMS[1].Status, m.SMS[1].Read = 5, 2
+
+	// the default layout drops milliseconds, which are imported from the raw date columns
+	dir := t.TempDir()
+	if err := GenerateSMSCSV(m, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sms.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ImportCSV(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.Messages.SMS, m.SMS) {
+		t.Errorf("round trip changed messages:\ngot  %+v\nwant %+v", b.Messages.SMS, m.SMS)
+	}
+
+	// an edited date is imported from the date column
+	const formatted, edited = "2023-12-31 22:00:00 +0000 UTC", "2023-12-31 22:30:00 +0000 UTC"
+	if !strings.Contains(string(data), formatted) {
+		t.Fatalf("output does not contain date %q:\n%s", formatted, data)
+	}
+	b, err = ImportCSV(strings.NewReader(strings.Replace(string(data), formatted, edited, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Messages.SMS[0].Date; got != "1704061800000" {
+		t.Errorf("got edited date %q, want %q", got, "1704061800000")
+	}
+	if got := b.Messages.SMS[1].Date; got != m.SMS[1].Date {
+		t.Errorf("got unedited date %q, want %q", got, m.SMS[1].Date)
+	}
+}
+
+func TestImportEditedColumns(t *testing.T) {
+	const tsv = "Address\tDate\tContact Name\tRaw Address\tRaw Contact Name\n" +
+		"442079460958\t2024-01-01T00:00:00.123Z\tBob Smith Jr.\t+44 20 7946 0958\tBob Smith, Jr.\n" +
+		"13125551212\t2024-01-01T00:00:00.456Z\tAlice\t+44 20 7946 0958\tBob Smith, Jr.\n"
+	b, err := ImportTSV(strings.NewReader(tsv), WithTimeLayout(TimeLayoutISO8601))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		address     PhoneNumber
+		contactName string
+	}{
+		{"+44 20 7946 0958", "Bob Smith, Jr."}, // unedited, so imported from the raw columns
+		{"13125551212", "Alice"},
+	} {
+		sms := b.Messages.SMS[i]
+		if sms.Address != want.address || sms.ContactName != want.contactName {
+			t.Errorf("SMS %d: got %q, %q, want %q, %q", i, sms.Address, sms.ContactName, want.address, want.contactName)
+		}
+	}
+
+	// without the raw columns, the normalized columns are imported
+	const edited = "Address\tDate\tContact Name\n442079460958\t2024-01-01T00:00:00.123Z\tBob Smith Jr.\n"
+	if b, err = ImportTSV(strings.NewReader(edited), WithTimeLayout(TimeLayoutISO8601)); err != nil {
+		t.Fatal(err)
+	}
+	if sms := b.Messages.SMS[0]; sms.Address != "442079460958" || sms.ContactName != "Bob Smith Jr." {
+		t.Errorf("got %q, %q", sms.Address, sms.ContactName)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	const input = "a,\"b \"\"quoted\"\"\r\nline\"\r\n\r\n\"\",c\r\nd,\"e,f\"\n\n"
+	c := &csvReader{r: bufio.NewReader(strings.NewReader(input)), delimiter: ','}
+	for _, want := range []struct {
+		fields     []string
+		lineNumber int
+	}{
+		{[]string{"a", "b \"quoted\"\r\nline"}, 1},
+		{[]string{"", "c"}, 4},
+		{[]string{"d", "e,f"}, 5},
+	} {
+		fields, lineNumber, err := c.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fields, want.fields) || lineNumber != want.lineNumber {
+			t.Errorf("got %q on line %d, want %q on line %d", fields, lineNumber, want.fields, want.lineNumber)
+		}
+	}
+	if _, _, err := c.read(); err != io.EOF {
+		t.Errorf("got %v after last record, want io.EOF", err)
+	}
+
+	for _, input := range []string{"a,\"b\n", "a,\"b\"c\n"} {
+		c := &csvReader{r: bufio.NewReader(strings.NewReader(input)), delimiter: ','}
+		if _, _, err := c.read(); err == nil || err == io.EOF {
+			t.Errorf("%q: got %v, want error", input, err)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithNDJSON outputs newline-delimited JSON (one record per line, in files ending in .ndjson) rather than a JSON array
+// from GenerateSMSJSON, GenerateMMSJSON, and GenerateCallJSON.
+func WithNDJSON() OutputOption {
+	return func(c *outputConfig) {
+		c.ndjson = true
+	}
+}
+
+// jsonEnum is the JSON representation of an enumerated value, including both its raw and decoded values.
+type jsonEnum struct {
+	Value int    `json:"value"`
+	Name  string `json:"name"`
+}
+
+// jsonTimestamp is the JSON representation of an AndroidTS, including both milliseconds since the Unix epoch and the
+// timestamp formatted according to the output settings.
+type jsonTimestamp struct {
+	Millis    *int64 `json:"ms"`
+	Formatted string `json:"formatted"`
+}
+
+type smsJSON struct {
+	Index             int               `json:"index"`
+	Protocol          string            `json:"protocol"`
+	Address           string            `json:"address"`
+	NormalizedAddress string            `json:"normalized_address"`
+	Type              jsonEnum          `json:"type"`
+	Subject           string            `json:"subject"`
+	Body              string            `json:"body"`
+	ServiceCenter     string            `json:"service_center"`
+	Status            jsonEnum          `json:"status"`
+	Read              jsonEnum          `json:"read"`
+	Date              jsonTimestamp     `json:"date"`
+	Locked            bool              `json:"locked"`
+	DateSent          jsonTimestamp     `json:"date_sent"`
+	ReadableDate      string            `json:"readable_date"`
+	ContactName       string            `json:"contact_name"`
+	TypeOfAddress     string            `json:"toa"`
+	ServiceCenterTOA  string            `json:"sc_toa"`
+	SubscriptionID    string            `json:"sub_id"`
+	ThreadID          string            `json:"thread_id,omitempty"`
+	Seen              bool              `json:"seen"`
+	ErrorCode         string            `json:"error_code,omitempty"`
+	SimSlot           string            `json:"sim_slot,omitempty"`
+	SimIMSI           string            `json:"sim_imsi,omitempty"`
+	UTCOffset         string            `json:"utc_offset,omitempty"`
+	OtherAttributes   map[string]string `json:"other_attributes,omitempty"`
+}
+
+type mmsJSON struct {
+	Index             int               `json:"index"`
+	Direction         jsonEnum          `json:"direction"`
+	TextOnly          bool              `json:"text_only"`
+	Read              jsonEnum          `json:"read"`
+	Date              jsonTimestamp     `json:"date"`
+	Locked            bool              `json:"locked"`
+	DateSent          jsonTimestamp     `json:"date_sent"`
+	ReadableDate      string            `json:"readable_date"`
+	ContactName       string            `json:"contact_name"`
+	Seen              bool              `json:"seen"`
+	FromAddress       string            `json:"from_address"`
+	Address           string            `json:"address"`
+	Sender            string            `json:"sender"`
+	MessageClassifier string            `json:"m_cls"`
+	MessageSize       string            `json:"m_size"`
+	MessageBox        jsonEnum          `json:"msg_box"`
+	MessageType       jsonEnum          `json:"m_type"`
+	MessageID         string            `json:"m_id"`
+	Subject           string            `json:"sub"`
+	SubjectCharset    jsonEnum          `json:"sub_cs"`
+	ContentType       string            `json:"ct_t"`
+	ContentLocation   string            `json:"ct_l"`
+	TransactionID     string            `json:"tr_id"`
+	DeliveryReport    jsonEnum          `json:"d_rpt"`
+	ReadReport        jsonEnum          `json:"rr"`
+	ReadStatus        jsonEnum          `json:"read_status"`
+	Expiry            string            `json:"exp"`
+	Priority          jsonEnum          `json:"pri"`
+	ResponseStatus    jsonEnum          `json:"resp_st"`
+	SubscriptionID    string            `json:"sub_id"`
+	UTCOffset         string            `json:"utc_offset,omitempty"`
+	OtherAttributes   map[string]string `json:"other_attributes,omitempty"`
+	Addresses         []addressJSON     `json:"addresses"`
+	Parts             []partJSON        `json:"parts"`
+}
+
+type addressJSON struct {
+	Address           string   `json:"address"`
+	NormalizedAddress string   `json:"normalized_address"`
+	Type              jsonEnum `json:"type"`
+	Charset           jsonEnum `json:"charset"`
+}
+
+type partJSON struct {
+	Index            int               `json:"index"`
+	Sequence         string            `json:"seq"`
+	ContentType      string            `json:"ct"`
+	Name             string            `json:"name"`
+	FileName         string            `json:"fn"`
+	ContentDisplay   string            `json:"cd"`
+	Text             string            `json:"text"`
+	Charset          jsonEnum          `json:"chset"`
+	ContentID        string            `json:"cid"`
+	ContentLocation  string            `json:"cl"`
+	ContentTypeStart string            `json:"ctt_s"`
+	ContentTypeType  string            `json:"ctt_t"`
+	DataSize         int               `json:"data_size,omitempty"`  // size of decoded data in bytes
+	Attachment       string            `json:"attachment,omitempty"` // path of file written by DecodeAttachments
+	OtherAttributes  map[string]string `json:"other_attributes,omitempty"`
+}
+
+type callJSON struct {
+	Index                     int               `json:"index"`
+	Number                    string            `json:"number"`
+	NormalizedNumber          string            `json:"normalized_number"`
+	Duration                  int               `json:"duration"`
+	Date                      jsonTimestamp     `json:"date"`
+	Type                      jsonEnum          `json:"type"`
+	ReadableDate              string            `json:"readable_date"`
+	ContactName               string            `json:"contact_name"`
+	Presentation              jsonEnum          `json:"presentation"`
+	SubscriptionID            string            `json:"subscription_id"`
+	SubscriptionComponentName string            `json:"subscription_component_name"`
+	PostDialDigits            string            `json:"post_dial_digits"`
+	Features                  jsonEnum          `json:"features"`
+	UTCOffset                 string            `json:"utc_offset,omitempty"`
+	OtherAttributes           map[string]string `json:"other_attributes,omitempty"`
+}
+
+// newJSONTimestamp converts timestamp for JSON output.
+func newJSONTimestamp(timestamp AndroidTS, cfg *outputConfig) jsonTimestamp {
+	ts := jsonTimestamp{Formatted: cfg.formatTime(timestamp)}
+	if t := timestamp.Time(); !t.IsZero() {
+		millis := t.UnixMilli()
+		ts.Millis = &millis
+	}
+	return ts
+}
+
+// attributeMap converts attributes not recognized by this parser to a map for JSON output.
+func attributeMap(attrs []xml.Attr) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Name.Local] = attr.Value
+	}
+	return m
+}
+
+// newSMSJSON converts the SMS with the given index for JSON output.
+func newSMSJSON(i int, sms *SMS, cfg *outputConfig) *smsJSON {
+	return &smsJSON{
+		Index:             i,
+		Protocol:          sms.Protocol,
+		Address:           string(sms.Address),
+		NormalizedAddress: sms.Address.String(),
+		Type:              jsonEnum{int(sms.Type), sms.Type.String()},
+		Subject:           sms.Subject,
+		Body:              sms.Body,
+		ServiceCenter:     string(sms.ServiceCenter),
+		Status:            jsonEnum{int(sms.Status), sms.Status.String()},
+		Read:              jsonEnum{int(sms.Read), sms.Read.String()},
+		Date:              newJSONTimestamp(sms.Date, cfg),
+		Locked:            sms.Locked == 1,
+		DateSent:          newJSONTimestamp(sms.DateSent, cfg),
+		ReadableDate:      sms.ReadableDate,
+		ContactName:       sms.ContactName,
+		TypeOfAddress:     sms.TypeOfAddress,
+		ServiceCenterTOA:  sms.ServiceCenterTOA,
+		SubscriptionID:    sms.SubscriptionID,
+		ThreadID:          sms.ThreadID,
+		Seen:              sms.Seen == 1,
+		ErrorCode:         sms.ErrorCode,
+		SimSlot:           sms.SimSlot,
+		SimIMSI:           sms.SimIMSI,
+		UTCOffset:         formatRecordOffset(sms.UTCOffset()),
+		OtherAttributes:   attributeMap(sms.OtherAttributes),
+	}
+}
+
+// newMMSJSON converts the MMS with the given index for JSON output, with its addresses and parts nested within it.
+func newMMSJSON(mmsIndex int, mms *MMS, cfg *outputConfig) *mmsJSON {
+	direction := mms.Direction()
+	j := &mmsJSON{
+		Index:             mmsIndex,
+		Direction:         jsonEnum{int(direction), direction.String()},
+		TextOnly:          mms.TextOnly == 1,
+		Read:              jsonEnum{int(mms.Read), mms.Read.String()},
+		Date:              newJSONTimestamp(mms.Date, cfg),
+		Locked:            mms.Locked == 1,
+		DateSent:          newJSONTimestamp(mms.DateSent, cfg),
+		ReadableDate:      mms.ReadableDate,
+		ContactName:       mms.ContactName,
+		Seen:              mms.Seen == 1,
+		FromAddress:       string(mms.FromAddress),
+		Address:           string(mms.Address),
+		Sender:            string(mms.Sender()),
+		MessageClassifier: mms.MessageClassifier,
+		MessageSize:       mms.MessageSize,
+		MessageBox:        jsonEnum{int(mms.MessageBox), mms.MessageBox.String()},
+		MessageType:       jsonEnum{int(mms.MessageType), mms.MessageType.String()},
+		MessageID:         mms.MessageID,
+		Subject:           mms.Subject,
+		SubjectCharset:    jsonEnum{int(mms.SubjectCharset), mms.SubjectCharset.String()},
+		ContentType:       mms.ContentType,
+		ContentLocation:   mms.ContentLocation,
+		TransactionID:     mms.TransactionID,
+		DeliveryReport:    jsonEnum{int(mms.DeliveryReport), mms.DeliveryReport.String()},
+		ReadReport:        jsonEnum{int(mms.ReadReport), mms.ReadReport.String()},
+		ReadStatus:        jsonEnum{int(mms.ReadStatus), mms.ReadStatus.String()},
+		Expiry:            mms.Expiry,
+		Priority:          jsonEnum{int(mms.Priority), mms.Priority.String()},
+		ResponseStatus:    jsonEnum{int(mms.ResponseStatus), mms.ResponseStatus.String()},
+		SubscriptionID:    mms.SubscriptionID,
+		UTCOffset:         formatRecordOffset(mms.UTCOffset()),
+		OtherAttributes:   attributeMap(mms.OtherAttributes),
+		Addresses:         []addressJSON{},
+		Parts:             []partJSON{},
+	}
+
+	for _, addr := range mms.Addresses {
+		j.Addresses = append(j.Addresses, addressJSON{
+			Address:           string(addr.Address),
+			NormalizedAddress: addr.Address.String(),
+			Type:              jsonEnum{int(addr.Type), addr.Type.String()},
+			Charset:           jsonEnum{int(addr.Charset), addr.Charset.String()},
+		})
+	}
+
+	for partIndex, part := range mms.Parts {
+		p := partJSON{
+			Index:            partIndex,
+			Sequence:         part.Sequence,
+			ContentType:      part.ContentType,
+			Name:             part.Name,
+			FileName:         part.FileName,
+			ContentDisplay:   part.ContentDisplay,
+			Text:             part.Text,
+			Charset:          jsonEnum{int(part.Charset), part.Charset.String()},
+			ContentID:        part.ContentID,
+			ContentLocation:  part.ContentLocation,
+			ContentTypeStart: part.ContentTypeStart,
+			ContentTypeType:  part.ContentTypeType,
+			DataSize:         base64DecodedLen(part.Base64Data),
+			OtherAttributes:  attributeMap(part.OtherAttributes),
+		}
+		p.Attachment = cfg.attachmentPath(&mms.Parts[partIndex], mmsIndex, partIndex)
+		j.Parts = append(j.Parts, p)
+	}
+	return j
+}
+
+// newCallJSON converts the call with the given index for JSON output.
+func newCallJSON(i int, call *Call, cfg *outputConfig) *callJSON {
+	return &callJSON{
+		Index:                     i,
+		Number:                    string(call.Number),
+		NormalizedNumber:          call.Number.String(),
+		Duration:                  call.Duration,
+		Date:                      newJSONTimestamp(call.Date, cfg),
+		Type:                      jsonEnum{int(call.Type), call.Type.String()},
+		ReadableDate:              call.ReadableDate,
+		ContactName:               call.ContactName,
+		Presentation:              jsonEnum{int(call.Presentation), call.Presentation.String()},
+		SubscriptionID:            call.SubscriptionID,
+		SubscriptionComponentName: call.SubscriptionComponentName,
+		PostDialDigits:            call.PostDialDigits,
+		Features:                  jsonEnum{int(call.Features), call.Features.String()},
+		UTCOffset:                 formatRecordOffset(call.UTCOffset()),
+		OtherAttributes:           attributeMap(call.OtherAttributes),
+	}
+}
+
+// base64DecodedLen returns the number of bytes encoded by base64 data (ignoring line breaks), or 0 if there is none.
+func base64DecodedLen(data string) int {
+	if data == "null" {
+		return 0
+	}
+	data = strings.NewReplacer("\n", "", "\r", "").Replace(data)
+	padding := len(data) - len(strings.TrimRight(data, "="))
+	return base64.StdEncoding.DecodedLen(len(data)) - padding
+}
+
+// jsonWriter writes records as a JSON array or as newline-delimited JSON.
+type jsonWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+	ndjson  bool
+	count   int
+	err     error
+}
+
+// newJSONWriter returns a jsonWriter writing to w according to cfg.
+func newJSONWriter(w io.Writer, cfg *outputConfig) *jsonWriter {
+	j := &jsonWriter{w: bufio.NewWriter(w), ndjson: cfg.ndjson}
+	j.encoder = json.NewEncoder(j.w)
+	j.encoder.SetEscapeHTML(false)
+	if !j.ndjson {
+		_, j.err = j.w.WriteString("[\n")
+	}
+	return j
+}
+
+// write writes a single record. The first error encountered is returned by close.
+func (j *jsonWriter) write(record interface{}) {
+	if j.err != nil {
+		return
+	}
+	if !j.ndjson && j.count > 0 {
+		if _, j.err = j.w.WriteString(","); j.err != nil {
+			return
+		}
+	}
+	j.err = j.encoder.Encode(record)
+	j.count++
+}
+
+// close ends the JSON array if necessary and flushes buffered output, returning the first error encountered.
+func (j *jsonWriter) close() error {
+	if j.err == nil && !j.ndjson {
+		_, j.err = j.w.WriteString("]\n")
+	}
+	if err := j.w.Flush(); err != nil && j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+// jsonFileName returns the name of the JSON output file for the given base name, e.g. "sms.json" or "sms.ndjson".
+func jsonFileName(baseName string, cfg *outputConfig) string {
+	if cfg.ndjson {
+		return baseName + ".ndjson"
+	}
+	return baseName + ".json"
+}
+
+// jsonFile is a JSON output file and the jsonWriter writing records to it.
+type jsonFile struct {
+	file *os.File
+	j    *jsonWriter
+}
+
+// jsonRecordWriter is the RecordWriter of the json and ndjson formats. It writes files named "sms.json", "mms.json",
+// and "calls.json" (or "sms.ndjson" and so on). BeginBackup creates the files for the records of a backup, even if it
+// has none of a type, named after its source (e.g. "sms-20180213135542_sms.json") so that each backup has its own
+// files; otherwise each file is created when the first record of its type is written.
+type jsonRecordWriter struct {
+	outputDir string
+	cfg       *outputConfig
+	backup    string // source name of the current backup, prefixing file names
+	sms       *jsonFile
+	mms       *jsonFile
+	calls     *jsonFile
+}
+
+// newJSONRecordWriter returns a jsonRecordWriter writing files to outputDir according to cfg.
+func newJSONRecordWriter(outputDir string, cfg *outputConfig) *jsonRecordWriter {
+	return &jsonRecordWriter{outputDir: outputDir, cfg: cfg}
+}
+
+// open creates the JSON file with the given base name (e.g. "sms"), if not already created.
+func (r *jsonRecordWriter) open(f **jsonFile, baseName string) error {
+	if *f != nil {
+		return nil
+	}
+
+	fileName := backupFileName(r.backup, jsonFileName(baseName, r.cfg))
+	file, err := os.Create(filepath.Join(r.outputDir, fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", fileName, err)
+	}
+	*f = &jsonFile{file: file, j: newJSONWriter(file, r.cfg)}
+	return nil
+}
+
+// WriteSMS writes the SMS with the given index to "sms.json" or "sms.ndjson".
+func (r *jsonRecordWriter) WriteSMS(i int, sms *SMS) error {
+	if err := r.open(&r.sms, "sms"); err != nil {
+		return err
+	}
+	r.sms.j.write(newSMSJSON(i, sms, r.cfg))
+	return r.sms.j.err
+}
+
+// WriteMMS writes the MMS with the given index to "mms.json" or "mms.ndjson".
+func (r *jsonRecordWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	if err := r.open(&r.mms, "mms"); err != nil {
+		return err
+	}
+	r.mms.j.write(newMMSJSON(mmsIndex, mms, r.cfg))
+	return r.mms.j.err
+}
+
+// WriteCall writes the call with the given index to "calls.json" or "calls.ndjson".
+func (r *jsonRecordWriter) WriteCall(i int, call *Call) error {
+	if err := r.open(&r.calls, "calls"); err != nil {
+		return err
+	}
+	r.calls.j.write(newCallJSON(i, call, r.cfg))
+	return r.calls.j.err
+}
+
+// BeginBackup closes the files of the previous backup, if any, and creates "<source>_sms.json" and "<source>_mms.json"
+// for a messages backup or "<source>_calls.json" for a calls backup (or "<source>_sms.ndjson" and so on), where
+// <source> is the base name of source without extension. Attachment paths are prefixed as with WithSource(source).
+func (r *jsonRecordWriter) BeginBackup(source string, h *Header) error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	r.cfg.source = sourceName(source)
+	r.backup = r.cfg.source
+	if h.BackupType() == CallsBackup {
+		return r.open(&r.calls, "calls")
+	}
+	if err := r.open(&r.sms, "sms"); err != nil {
+		return err
+	}
+	return r.open(&r.mms, "mms")
+}
+
+// Close ends and closes each file, returning the first error encountered.
+func (r *jsonRecordWriter) Close() error {
+	var firstErr error
+	for _, f := range []*jsonFile{r.sms, r.mms, r.calls} {
+		if f == nil {
+			continue
+		}
+		if err := f.j.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.sms, r.mms, r.calls = nil, nil, nil
+	return firstErr
+}
+
+// GenerateSMSJSON outputs a JSON file named "sms.json" (or "sms.ndjson" with WithNDJSON) containing parsed SMS
+// messages from the backup file. Enumerated values include both their raw and decoded values.
+func GenerateSMSJSON(m *Messages, outputDir string, opts ...OutputOption) error {
+	w := newJSONRecordWriter(outputDir, newOutputConfig(opts))
+	err := w.open(&w.sms, "sms")
+	for i := 0; err == nil && i < len(m.SMS); i++ {
+		err = w.WriteSMS(i, &m.SMS[i])
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GenerateMMSJSON outputs a JSON file named "mms.json" (or "mms.ndjson" with WithNDJSON) containing parsed MMS
+// messages from the backup file, with their addresses and parts nested within them. Rather than including base64
+// data, parts such as images reference the file written by DecodeAttachments.
+func GenerateMMSJSON(m *Messages, outputDir string, opts ...OutputOption) error {
+	w := newJSONRecordWriter(outputDir, newOutputConfig(opts))
+	err := w.open(&w.mms, "mms")
+	for i := 0; err == nil && i < len(m.MMS); i++ {
+		err = w.WriteMMS(i, &m.MMS[i])
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GenerateCallJSON outputs a JSON file named "calls.json" (or "calls.ndjson" with WithNDJSON) containing parsed
+// calls from the backup file. Enumerated values include both their raw and decoded values.
+func GenerateCallJSON(c *Calls, outputDir string, opts ...OutputOption) error {
+	w := newJSONRecordWriter(outputDir, newOutputConfig(opts))
+	err := w.open(&w.calls, "calls")
+	for i := 0; err == nil && i < len(c.Calls); i++ {
+		err = w.WriteCall(i, &c.Calls[i])
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readJSON decodes the JSON file with the given name in dir into v.
+func readJSON(t *testing.T, dir string, name string, v interface{}) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func TestGenerateJSON(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	opts := []OutputOption{WithTimeLayout(TimeLayoutISO8601), WithSource("sms.xml")}
+	if err := GenerateSMSJSON(m, dir, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateMMSJSON(m, dir, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateCallJSON(c, dir, opts...); err != nil {
+		t.Fatal(err)
+	}
+
+	var sms []smsJSON
+	readJSON(t, dir, "sms.json", &sms)
+	if len(sms) != 2 {
+		t.Fatalf("got %d SMS, want 2", len(sms))
+	}
+	s := sms[0]
+	if s.Body != m.SMS[0].Body || s.Address != "+44 20 7946 0958" || s.NormalizedAddress != "442079460958" ||
+		s.ContactName != "Smith, Jr., Bob" {
+		t.Errorf("got SMS %+v", s)
+	}
+	if s.Date.Millis == nil || *s.Date.Millis != 1704060000123 || s.Date.Formatted != "2023-12-31T22:00:00.123Z" {
+		t.Errorf("got date %+v", s.Date)
+	}
+	if s.DateSent.Millis != nil || s.DateSent.Formatted != "null" {
+		t.Errorf("got date_sent %+v, want no milliseconds", s.DateSent)
+	}
+	if s.Type != (jsonEnum{1, "Received"}) || s.Status != (jsonEnum{-1, "None"}) {
+		t.Errorf("got type %+v, status %+v", s.Type, s.Status)
+	}
+	if s.OtherAttributes["x_custom"] != "a&b" || s.OtherAttributes["rcs_flag"] != "0" {
+		t.Errorf("got other attributes %v", s.OtherAttributes)
+	}
+
+	var mms []mmsJSON
+	readJSON(t, dir, "mms.json", &mms)
+	if len(mms) != 1 || len(mms[0].Addresses) != 3 || len(mms[0].Parts) != 3 {
+		t.Fatalf("got MMS %+v", mms)
+	}
+	if mms[0].Sender != "13125551212" || mms[0].Addresses[0].Type.Name != "From" {
+		t.Errorf("got sender %q, addresses %+v", mms[0].Sender, mms[0].Addresses)
+	}
+	image := mms[0].Parts[2]
+	if image.DataSize != 8 || image.Attachment != "images/sms_image.png_0-2.png" {
+		t.Errorf("got image part %+v", image)
+	}
+	if text := mms[0].Parts[1]; text.DataSize != 0 || text.Attachment != "" || text.Text != "Look 😀\nhere" {
+		t.Errorf("got text part %+v", text)
+	}
+
+	var calls []callJSON
+	readJSON(t, dir, "calls.json", &calls)
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if calls[0].Number != "+13125551212" || calls[0].NormalizedNumber != "13125551212" || calls[0].Duration != 65 ||
+		calls[0].Features.Value != 5 || calls[0].OtherAttributes["call_screening_app_name"] != "null" {
+		t.Errorf("got call %+v", calls[0])
+	}
+}
+
+func TestGenerateNDJSON(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := GenerateSMSJSON(m, dir, WithNDJSON()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "sms.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// one record per line, even though the body of the first contains line breaks
+	scanner := bufio.NewScanner(f)
+	var lines int
+	for ; scanner.Scan(); lines++ {
+		var s smsJSON
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if s.Index != lines || s.Body != m.SMS[lines].Body {
+			t.Errorf("line %d: got index %d, body %q", lines+1, s.Index, s.Body)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,321 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// xmlDeclaration begins each backup file written by the SMS Backup & Restore app.
+const xmlDeclaration = "<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>\n"
+
+// backupXMLWriter writes elements in the XML dialect of the SMS Backup & Restore app. The first error encountered is
+// returned by flush.
+type backupXMLWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// newBackupXMLWriter returns a backupXMLWriter writing to w.
+func newBackupXMLWriter(w io.Writer) *backupXMLWriter {
+	return &backupXMLWriter{w: bufio.NewWriter(w)}
+}
+
+// raw writes s as-is.
+func (x *backupXMLWriter) raw(s string) {
+	if x.err == nil {
+		_, x.err = x.w.WriteString(s)
+	}
+}
+
+// attr writes an attribute, escaping its value as the app does.
+func (x *backupXMLWriter) attr(name string, value string) {
+	x.raw(" " + name + `="` + EscapeBackupXML(value) + `"`)
+}
+
+// optionalAttr writes an attribute unless its value is empty, as when it was missing from a parsed backup.
+func (x *backupXMLWriter) optionalAttr(name string, value string) {
+	if value != "" {
+		x.attr(name, value)
+	}
+}
+
+// intAttr writes an integer attribute.
+func (x *backupXMLWriter) intAttr(name string, i int) {
+	x.attr(name, strconv.Itoa(i))
+}
+
+// nullableIntAttr writes an integer attribute parsed by parseNullableInt, writing "null" for 0 as the app does for
+// missing values.
+func (x *backupXMLWriter) nullableIntAttr(name string, i int) {
+	if i == 0 {
+		x.attr(name, "null")
+	} else {
+		x.intAttr(name, i)
+	}
+}
+
+// otherAttrs writes attributes not recognized by this parser, which are kept so they are restored along with the rest
+// of the record.
+func (x *backupXMLWriter) otherAttrs(attrs []xml.Attr) {
+	for _, a := range attrs {
+		name := a.Name.Local
+		if a.Name.Space != "" {
+			name = a.Name.Space + ":" + name
+		}
+		x.attr(name, a.Value)
+	}
+}
+
+// root writes the start tag of the root element described by h, with the number of records it contains rather than
+// the count of h.
+func (x *backupXMLWriter) root(h *Header, count int) {
+	x.raw(xmlDeclaration)
+	x.raw("<" + h.XMLName.Local)
+	x.intAttr("count", count)
+	x.optionalAttr("backup_set", h.BackupSet)
+	x.optionalAttr("backup_date", string(h.BackupDate))
+	x.otherAttrs(h.OtherAttributes)
+	x.raw(">\n")
+}
+
+// flush writes any buffered output, returning the first error encountered.
+func (x *backupXMLWriter) flush() error {
+	if err := x.w.Flush(); err != nil && x.err == nil {
+		x.err = err
+	}
+	return x.err
+}
+
+// writeSMS writes an <sms> element.
+func (x *backupXMLWriter) writeSMS(sms *SMS) {
+	x.raw("  <sms")
+	x.attr("protocol", sms.Protocol)
+	x.attr("address", string(sms.Address))
+	x.attr("date", string(sms.Date))
+	x.intAttr("type", int(sms.Type))
+	x.attr("subject", sms.Subject)
+	x.attr("body", sms.Body)
+	x.optionalAttr("toa", sms.TypeOfAddress)
+	x.optionalAttr("sc_toa", sms.ServiceCenterTOA)
+	x.optionalAttr("service_center", string(sms.ServiceCenter))
+	x.intAttr("read", int(sms.Read))
+	x.intAttr("status", int(sms.Status))
+	x.intAttr("locked", int(sms.Locked))
+	x.attr("date_sent", string(sms.DateSent))
+	x.optionalAttr("sub_id", sms.SubscriptionID)
+	x.optionalAttr("thread_id", sms.ThreadID)
+	x.intAttr("seen", int(sms.Seen))
+	x.optionalAttr("error_code", sms.ErrorCode)
+	x.optionalAttr("sim_slot", sms.SimSlot)
+	x.optionalAttr("sim_imsi", sms.SimIMSI)
+	x.otherAttrs(sms.OtherAttributes)
+	x.optionalAttr("readable_date", sms.ReadableDate)
+	x.optionalAttr("contact_name", sms.ContactName)
+	x.raw(" />\n")
+}
+
+// writeMMS writes an <mms> element, with its parts and addresses.
+func (x *backupXMLWriter) writeMMS(mms *MMS) {
+	x.raw("  <mms")
+	x.attr("date", string(mms.Date))
+	x.nullableIntAttr("rr", int(mms.ReadReport))
+	x.optionalAttr("sub", mms.Subject)
+	x.optionalAttr("ct_t", mms.ContentType)
+	x.nullableIntAttr("read_status", int(mms.ReadStatus))
+	x.intAttr("seen", int(mms.Seen))
+	x.nullableIntAttr("msg_box", int(mms.MessageBox))
+	x.attr("address", string(mms.Address))
+	x.optionalAttr("from_address", string(mms.FromAddress))
+	x.nullableIntAttr("sub_cs", int(mms.SubjectCharset))
+	x.nullableIntAttr("resp_st", int(mms.ResponseStatus))
+	x.intAttr("text_only", int(mms.TextOnly))
+	x.optionalAttr("exp", mms.Expiry)
+	x.intAttr("locked", int(mms.Locked))
+	x.optionalAttr("m_id", mms.MessageID)
+	x.attr("date_sent", string(mms.DateSent))
+	x.intAttr("read", int(mms.Read))
+	x.optionalAttr("m_size", mms.MessageSize)
+	x.nullableIntAttr("pri", int(mms.Priority))
+	x.optionalAttr("sub_id", mms.SubscriptionID)
+	x.optionalAttr("tr_id", mms.TransactionID)
+	x.optionalAttr("ct_l", mms.ContentLocation)
+	x.optionalAttr("m_cls", mms.MessageClassifier)
+	x.nullableIntAttr("d_rpt", int(mms.DeliveryReport))
+	x.nullableIntAttr("m_type", int(mms.MessageType))
+	x.otherAttrs(mms.OtherAttributes)
+	x.optionalAttr("readable_date", mms.ReadableDate)
+	x.optionalAttr("contact_name", mms.ContactName)
+	x.raw(">\n")
+
+	x.raw("    <parts>\n")
+	for i := range mms.Parts {
+		part := &mms.Parts[i]
+		x.raw("      <part")
+		x.attr("seq", part.Sequence)
+		x.attr("ct", part.ContentType)
+		x.optionalAttr("name", part.Name)
+		x.nullableIntAttr("chset", int(part.Charset))
+		x.optionalAttr("cd", part.ContentDisplay)
+		x.optionalAttr("fn", part.FileName)
+		x.optionalAttr("cid", part.ContentID)
+		x.optionalAttr("cl", part.ContentLocation)
+		x.optionalAttr("ctt_s", part.ContentTypeStart)
+		x.optionalAttr("ctt_t", part.ContentTypeType)
+		x.optionalAttr("text", part.Text)
+		x.otherAttrs(part.OtherAttributes)
+		x.optionalAttr("data", part.Base64Data)
+		x.raw(" />\n")
+	}
+	x.raw("    </parts>\n")
+
+	x.raw("    <addrs>\n")
+	for _, addr := range mms.Addresses {
+		x.raw("      <addr")
+		x.attr("address", string(addr.Address))
+		x.nullableIntAttr("type", int(addr.Type))
+		x.nullableIntAttr("charset", int(addr.Charset))
+		x.raw(" />\n")
+	}
+	x.raw("    </addrs>\n")
+	x.raw("  </mms>\n")
+}
+
+// writeCall writes a <call> element.
+func (x *backupXMLWriter) writeCall(call *Call) {
+	x.raw("  <call")
+	x.attr("number", string(call.Number))
+	x.intAttr("duration", call.Duration)
+	x.attr("date", string(call.Date))
+	x.intAttr("type", int(call.Type))
+	x.nullableIntAttr("presentation", int(call.Presentation))
+	x.optionalAttr("subscription_id", call.SubscriptionID)
+	x.optionalAttr("post_dial_digits", call.PostDialDigits)
+	x.optionalAttr("subscription_component_name", call.SubscriptionComponentName)
+	// unlike the other nullable attributes, 0 is a valid value of features
+	x.intAttr("features", int(call.Features))
+	x.otherAttrs(call.OtherAttributes)
+	x.optionalAttr("readable_date", call.ReadableDate)
+	x.optionalAttr("contact_name", call.ContactName)
+	x.raw(" />\n")
+}
+
+// writeRecord writes an <sms>, <mms>, or <call> element.
+func (x *backupXMLWriter) writeRecord(record Record) {
+	switch rec := record.(type) {
+	case *SMS:
+		x.writeSMS(rec)
+	case *MMS:
+		x.writeMMS(rec)
+	case *Call:
+		x.writeCall(rec)
+	}
+}
+
+// EscapeBackupXML escapes s for use as an attribute value the way the SMS Backup & Restore app does: markup characters
+// are replaced with entities, line breaks and tabs with character references (so they are not normalized to spaces),
+// and characters outside the Basic Multilingual Plane, such as emoji, with a pair of decimal character references to
+// their UTF-16 surrogates (e.g. "&#55357;&#56832;" for U+1F600).
+func EscapeBackupXML(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '"':
+			b.WriteString("&quot;")
+		case r == '\'':
+			b.WriteString("&apos;")
+		case r == '\t' || r == '\n' || r == '\r':
+			fmt.Fprintf(&b, "&#%d;", r)
+		case r > 0xFFFF:
+			high, low := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, "&#%d;&#%d;", high, low)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// WriteMessagesXML writes an SMS backup to w in the XML dialect accepted by the SMS Backup & Restore app for
+// restoring. The count attribute of the root element is the number of SMS and MMS messages written, and MMS parts
+// include their base64 data. Parsing the output yields the same records as m.
+func WriteMessagesXML(w io.Writer, m *Messages) error {
+	x := newBackupXMLWriter(w)
+	x.root((&Backup{Messages: m}).Header(), len(m.SMS)+len(m.MMS))
+	for i := range m.SMS {
+		x.writeSMS(&m.SMS[i])
+	}
+	for i := range m.MMS {
+		x.writeMMS(&m.MMS[i])
+	}
+	x.raw("</smses>\n")
+	return x.flush()
+}
+
+// WriteCallsXML writes a calls backup to w in the XML dialect accepted by the SMS Backup & Restore app for restoring.
+// The count attribute of the root element is the number of calls written. Parsing the output yields the same records
+// as c.
+func WriteCallsXML(w io.Writer, c *Calls) error {
+	x := newBackupXMLWriter(w)
+	x.root((&Backup{Calls: c}).Header(), len(c.Calls))
+	for i := range c.Calls {
+		x.writeCall(&c.Calls[i])
+	}
+	x.raw("</calls>\n")
+	return x.flush()
+}
+
+// WriteBackupXML writes the SMS or calls backup to the file at outputPath, as with WriteMessagesXML or WriteCallsXML.
+func WriteBackupXML(b *Backup, outputPath string) error {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", filepath.Base(outputPath), err)
+	}
+	defer f.Close()
+
+	if b.Messages != nil {
+		err = WriteMessagesXML(f, b.Messages)
+	} else {
+		err = WriteCallsXML(f, b.Calls)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
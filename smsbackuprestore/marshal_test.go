@@ -0,0 +1,183 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRoundTripXML covers what WriteMessagesXML must reproduce: emoji as surrogate pair references, line breaks and
+// markup characters in text, "null" attributes, attributes the parser does not recognize, and MMS parts and addresses.
+const testRoundTripXML = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>
+<smses count="3" backup_set="0b6b3b5e-6f4a-4d5c-9f6e-2c0a3f1f7f00" backup_date="1704067200000" type="full">
+  <sms protocol="0" address="+44 20 7946 0958" date="1704060000123" type="1" subject="null" body="Hi &#55357;&#56832;&#13;&#10;&quot;Tom &amp; Jerry&quot; &lt;3&#9;it's" toa="null" sc_toa="null" service_center="+447700900000" read="1" status="-1" locked="0" date_sent="null" sub_id="1" readable_date="Dec 31, 2023 4:00:00 PM" contact_name="Smith, Jr., Bob" rcs_flag="0" x_custom="a&amp;b" />
+  <sms protocol="0" address="13125553434" date="1704060200000" type="2" subject="null" body="" toa="null" sc_toa="null" service_center="null" read="1" status="-1" locked="0" date_sent="0" sub_id="1" readable_date="Dec 31, 2023 4:03:20 PM" contact_name="(Unknown)" />
+  <mms date="1704060100000" rr="129" sub="&#55357;&#56397; null" ct_t="application/vnd.wap.multipart.related" read_status="null" seen="1" msg_box="1" address="13125551212~13125553434" sub_cs="null" resp_st="null" retr_st="null" d_tm="null" text_only="0" exp="null" locked="0" m_id="mid" st="null" retr_txt_cs="null" retr_txt="null" creator="com.google.android.apps.messaging" date_sent="0" read="1" m_size="1024" rpt_a="null" ct_cls="null" pri="129" sub_id="1" tr_id="tid" resp_txt="null" ct_l="null" m_cls="personal" d_rpt="129" v="18" _id="12" m_type="132" readable_date="Dec 31, 2023 4:01:40 PM" contact_name="Alice, Bob">
+    <parts>
+      <part seq="-1" ct="application/smil" name="null" chset="null" cd="null" fn="null" cid="&lt;smil&gt;" cl="smil.xml" ctt_s="null" ctt_t="null" text="&lt;smil /&gt;" />
+      <part seq="0" ct="text/plain" name="null" chset="106" cd="null" fn="null" cid="&lt;text&gt;" cl="text.txt" ctt_s="null" ctt_t="null" text="Look &#55357;&#56832;&#10;here" _id="7" />
+      <part seq="0" ct="image/png" name="image.png" chset="null" cd="null" fn="null" cid="&lt;image&gt;" cl="image.png" ctt_s="null" ctt_t="null" text="null" data="iVBORw0KGgo=" />
+    </parts>
+    <addrs>
+      <addr address="13125551212" type="137" charset="106" />
+      <addr address="13125553434" type="151" charset="106" />
+      <addr address="insert-address-token" type="151" charset="106" />
+    </addrs>
+  </mms>
+</smses>
+`
+
+// testRoundTripCallsXML covers "null" enumerated values and attributes the parser does not recognize in calls.
+const testRoundTripCallsXML = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>
+<calls count="2" backup_set="c1a2b3" backup_date="1704067200000" type="full">
+  <call number="+13125551212" duration="65" date="1704060000000" type="1" presentation="1" subscription_id="1" post_dial_digits="" subscription_component_name="com.android.phone/com.android.services.telephony.TelephonyConnectionService" readable_date="Dec 31, 2023 4:00:00 PM" contact_name="Alice &#55357;&#56832;" features="5" call_screening_app_name="null" />
+  <call number="13125553434" duration="0" date="1704060100000" type="3" presentation="null" readable_date="Dec 31, 2023 4:01:40 PM" contact_name="(Unknown)" features="null" />
+</calls>
+`
+
+func TestWriteMessagesXMLRoundTrip(t *testing.T) {
+	want, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.SMS[0].OtherAttributes) != 2 || len(want.MMS[0].OtherAttributes) == 0 || len(want.MMS[0].Parts[1].OtherAttributes) != 1 {
+		t.Fatalf("fixture attributes not parsed as unrecognized: %+v", want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMessagesXML(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Hi &#55357;&#56832;") {
+		t.Errorf("emoji not written as surrogate pair references:\n%s", buf.String())
+	}
+
+	got, err := ParseMessages(&buf)
+	if err != nil {
+		t.Fatalf("parsing written XML: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed messages:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestWriteCallsXMLRoundTrip(t *testing.T) {
+	want, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.Calls[0].OtherAttributes) != 1 {
+		t.Fatalf("fixture attributes not parsed as unrecognized: %+v", want.Calls[0].OtherAttributes)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCallsXML(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseCalls(&buf)
+	if err != nil {
+		t.Fatalf("parsing written XML: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed calls:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestWriteBackupXMLRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for fileName, doc := range map[string]string{
+		"sms-20240101000000.xml":   testRoundTripXML,
+		"calls-20240101000000.xml": testRoundTripCallsXML,
+	} {
+		want := new(Backup)
+		var err error
+		if strings.HasPrefix(fileName, "sms") {
+			want.Messages, err = ParseMessages(strings.NewReader(doc))
+		} else {
+			want.Calls, err = ParseCalls(strings.NewReader(doc))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, fileName)
+		if err := WriteBackupXML(want, path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", fileName, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip changed backup:\ngot  %+v\nwant %+v", fileName, got, want)
+		}
+	}
+}
+
+func TestWriteMessagesXMLCount(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SMS = m.SMS[:1]
+
+	var buf bytes.Buffer
+	if err := WriteMessagesXML(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<smses count="2"`) {
+		t.Errorf("count attribute not updated to the number of messages written:\n%s", buf.String())
+	}
+}
+
+func TestWriteBackupXMLRootAttributes(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	older := &Messages{BackupSet: "old", BackupDate: "1704000000000",
+		OtherAttributes: []xml.Attr{{Name: xml.Name{Local: "type"}, Value: "incremental"}}}
+	merged, _ := MergeMessages(older, m)
+	parts := SplitBackupBySize(&Backup{Messages: m}, 1)
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(parts))
+	}
+
+	for name, backup := range map[string]*Messages{"parsed": m, "merged": merged, "split": parts[1].Backup.Messages} {
+		var buf bytes.Buffer
+		if err := WriteMessagesXML(&buf, backup); err != nil {
+			t.Fatal(err)
+		}
+		root := strings.SplitN(buf.String(), "\n", 3)[1]
+		if !strings.HasSuffix(root, `backup_date="1704067200000" type="full">`) {
+			t.Errorf("%s: root attributes not kept: %s", name, root)
+		}
+	}
+}
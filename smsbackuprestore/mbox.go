@@ -0,0 +1,510 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emailDomain is the domain of the email addresses made up for phone numbers in RFC 5322 output. The .invalid top-level
+// domain is reserved (RFC 2606), so the addresses cannot be mistaken for real ones.
+const emailDomain = "sms.invalid"
+
+// deviceAddress stands in for the owner of the device, whose own number is not recorded in backups.
+var deviceAddress = &mail.Address{Name: "Device Owner", Address: "device@" + emailDomain}
+
+// mboxFromLine matches lines that must be quoted in mbox output (mboxrd format) so they are not taken as the start of
+// another message.
+var mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// emailAddress makes up an email address for a phone number, e.g. "Alice" <13125551212@sms.invalid>. Numbers that are
+// already email addresses (as with messages sent from email to SMS) are kept as-is.
+func emailAddress(number PhoneNumber, name string) *mail.Address {
+	if number == "insert-address-token" {
+		return deviceAddress
+	}
+	if name == "(Unknown)" {
+		name = ""
+	}
+	if strings.Contains(string(number), "@") {
+		return &mail.Address{Name: name, Address: string(number)}
+	}
+
+	local := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, number.String())
+	if local == "" || local == "null" {
+		local = "unknown"
+	}
+	return &mail.Address{Name: name, Address: local + "@" + emailDomain}
+}
+
+// formatAddressList formats addresses for the value of a header such as To or Cc.
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, addr := range addresses {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// threadID returns the Message-ID shared by all messages with the same participants, which is referenced by each of
+// them so that email clients and review platforms group them into a thread.
+func threadID(participants []string) string {
+	sorted := append([]string(nil), participants...)
+	sort.Strings(sorted)
+	sum := sha1.Sum([]byte(strings.Join(sorted, ";")))
+	return "<conversation." + hex.EncodeToString(sum[:8]) + "@" + emailDomain + ">"
+}
+
+// messageID returns a Message-ID derived from the content of a message, so it is the same each time the backup is
+// exported.
+func messageID(kind string, fields ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(fields, "\x00")))
+	return "<" + kind + "." + hex.EncodeToString(sum[:12]) + "@" + emailDomain + ">"
+}
+
+// emailMessage is an SMS or MMS message converted to RFC 5322 form.
+type emailMessage struct {
+	date         time.Time
+	from         *mail.Address
+	to, cc, bcc  []*mail.Address
+	subject      string
+	id           string
+	thread       string
+	extraHeaders [][2]string
+	text         string
+	parts        []Part // attachments
+}
+
+// newSMSEmail converts an SMS message to RFC 5322 form.
+func newSMSEmail(sms *SMS, cfg *outputConfig) *emailMessage {
+	contact := emailAddress(sms.Address, RemoveCommasBeforeSuffixes(sms.ContactName))
+	from, to := contact, deviceAddress
+	if sms.Type != 1 {
+		// everything but received messages was composed on the device
+		from, to = deviceAddress, contact
+	}
+
+	subject := sms.Subject
+	if subject == "" || subject == "null" {
+		subject = "SMS with " + addressDisplayName(contact)
+	}
+
+	return &emailMessage{
+		date:         sms.Date.Time().In(cfg.location),
+		from:         from,
+		to:           []*mail.Address{to},
+		subject:      subject,
+		id:           messageID("sms", string(sms.Date), string(sms.Address), sms.Type.String(), sms.Body),
+		thread:       threadID([]string{sms.Address.String()}),
+		extraHeaders: [][2]string{{"X-SMS-Type", sms.Type.String()}},
+		text:         sms.Body,
+	}
+}
+
+// newMMSEmail converts an MMS message to RFC 5322 form, with text parts as its body and other parts as attachments.
+func newMMSEmail(mms *MMS, cfg *outputConfig) *emailMessage {
+	names := mmsContactNames(mms)
+	var from *mail.Address
+	var to, cc, bcc []*mail.Address
+	for _, addr := range mms.Addresses {
+		a := emailAddress(addr.Address, names[addr.Address.String()])
+		switch addr.Type {
+		case AddressTypeFrom:
+			from = a
+		case AddressTypeTo:
+			to = append(to, a)
+		case AddressTypeCc:
+			cc = append(cc, a)
+		case AddressTypeBcc:
+			bcc = append(bcc, a)
+		}
+	}
+	if from == nil {
+		if mms.Direction() == DirectionSent {
+			from = deviceAddress
+		} else {
+			from = emailAddress(mms.Address, names[mms.Address.String()])
+		}
+	}
+
+	var participants, participantNames []string
+	for _, number := range strings.Split(string(mms.Address), "~") {
+		participants = append(participants, PhoneNumber(number).String())
+		participantNames = append(participantNames, addressDisplayName(emailAddress(PhoneNumber(number), names[PhoneNumber(number).String()])))
+	}
+	subject := mms.Subject
+	if subject == "" || subject == "null" {
+		subject = "MMS with " + strings.Join(participantNames, ", ")
+	}
+
+	e := &emailMessage{
+		date:         mms.Date.Time().In(cfg.location),
+		from:         from,
+		to:           to,
+		cc:           cc,
+		bcc:          bcc,
+		subject:      subject,
+		thread:       threadID(participants),
+		extraHeaders: [][2]string{{"X-MMS-Direction", mms.Direction().String()}},
+	}
+	var text, partHashes []string
+	for _, part := range mms.Parts {
+		switch part.ContentType {
+		case "text/plain":
+			text = append(text, part.Text)
+		case "application/smil":
+			// layout of the parts on screen
+		default:
+			e.parts = append(e.parts, part)
+		}
+		sum := sha1.Sum([]byte(part.Text + part.Base64Data))
+		partHashes = append(partHashes, hex.EncodeToString(sum[:]))
+	}
+	e.text = strings.Join(text, "\n")
+
+	e.id = messageID("mms", string(mms.Date), string(mms.Address), mms.MessageID, strings.Join(partHashes, ","))
+	return e
+}
+
+// mmsContactNames maps the numbers of the participants of an MMS message to their contact names. Names are only
+// known if the contact_name attribute lists one per number.
+func mmsContactNames(mms *MMS) map[string]string {
+	names := make(map[string]string)
+	numbers := strings.Split(string(mms.Address), "~")
+	contactNames := strings.Split(RemoveCommasBeforeSuffixes(mms.ContactName), ",")
+	if len(numbers) == len(contactNames) {
+		for i, number := range numbers {
+			names[PhoneNumber(number).String()] = strings.TrimSpace(contactNames[i])
+		}
+	}
+	return names
+}
+
+// addressDisplayName returns the name of addr, or its address if it has no name.
+func addressDisplayName(addr *mail.Address) string {
+	if addr.Name != "" {
+		return addr.Name
+	}
+	return addr.Address
+}
+
+// writeTo writes the message with CRLF line endings.
+func (e *emailMessage) writeTo(w *bytes.Buffer) error {
+	var header [][2]string
+	if !e.date.IsZero() {
+		header = append(header, [2]string{"Date", e.date.Format(time.RFC1123Z)})
+	}
+	header = append(header, [2]string{"From", e.from.String()})
+	for _, field := range []struct {
+		name      string
+		addresses []*mail.Address
+	}{{"To", e.to}, {"Cc", e.cc}, {"Bcc", e.bcc}} {
+		if len(field.addresses) > 0 {
+			header = append(header, [2]string{field.name, formatAddressList(field.addresses)})
+		}
+	}
+	header = append(header,
+		[2]string{"Subject", mime.QEncoding.Encode("utf-8", e.subject)},
+		[2]string{"Message-ID", e.id},
+		[2]string{"In-Reply-To", e.thread},
+		[2]string{"References", e.thread},
+		[2]string{"MIME-Version", "1.0"},
+	)
+	header = append(header, e.extraHeaders...)
+
+	var mw *multipart.Writer
+	if len(e.parts) > 0 {
+		mw = multipart.NewWriter(w)
+		header = append(header, [2]string{"Content-Type", "multipart/mixed; boundary=" + mw.Boundary()})
+	} else {
+		header = append(header,
+			[2]string{"Content-Type", "text/plain; charset=utf-8"},
+			[2]string{"Content-Transfer-Encoding", "quoted-printable"},
+		)
+	}
+	for _, field := range header {
+		fmt.Fprintf(w, "%s: %s\r\n", field[0], field[1])
+	}
+	w.WriteString("\r\n")
+
+	if mw == nil {
+		return writeQuotedPrintable(w, e.text)
+	}
+
+	textHeader := make(textproto.MIMEHeader)
+	textHeader.Set("Content-Type", "text/plain; charset=utf-8")
+	textHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+	pw, err := mw.CreatePart(textHeader)
+	if err != nil {
+		return err
+	}
+	var text bytes.Buffer
+	if err := writeQuotedPrintable(&text, e.text); err != nil {
+		return err
+	}
+	if _, err := pw.Write(text.Bytes()); err != nil {
+		return err
+	}
+
+	for _, part := range e.parts {
+		name := part.Name
+		if name == "" || name == "null" {
+			name = part.FileName
+		}
+		if name == "" || name == "null" {
+			name = part.ContentLocation
+		}
+
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Set("Content-Type", mime.FormatMediaType(part.ContentType, map[string]string{"name": name}))
+		partHeader.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		if part.ContentID != "" && part.ContentID != "null" {
+			partHeader.Set("Content-ID", part.ContentID)
+		}
+
+		data := part.Base64Data
+		if data == "null" {
+			data = ""
+		}
+		if data != "" {
+			partHeader.Set("Content-Transfer-Encoding", "base64")
+		} else {
+			// parts such as vCards may be stored as text
+			partHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+		}
+		pw, err := mw.CreatePart(partHeader)
+		if err != nil {
+			return err
+		}
+
+		var body bytes.Buffer
+		if data != "" {
+			// the data is already base64 encoded, so it only has to be wrapped at 76 characters
+			data = strings.NewReplacer("\n", "", "\r", "").Replace(data)
+			for len(data) > 76 {
+				body.WriteString(data[:76] + "\r\n")
+				data = data[76:]
+			}
+			body.WriteString(data + "\r\n")
+		} else if err := writeQuotedPrintable(&body, part.Text); err != nil {
+			return err
+		}
+		if _, err := pw.Write(body.Bytes()); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeQuotedPrintable writes text as quoted-printable with CRLF line endings.
+func writeQuotedPrintable(w *bytes.Buffer, text string) error {
+	text = strings.NewReplacer("\r\n", "\r\n", "\r", "\r\n", "\n", "\r\n").Replace(text)
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+	w.WriteString("\r\n")
+	return nil
+}
+
+// GenerateMbox outputs an mbox file named "messages.mbox" containing each parsed SMS and MMS message as an RFC 5322
+// email message, for loading into email clients and review platforms. Phone numbers are given made-up email addresses
+// (e.g. 13125551212@sms.invalid), the owner of the device is device@sms.invalid, and messages with the same
+// participants reference a common Message-ID so they are threaded together. MMS parts other than text are attached
+// using their content type and name. The file is replaced; to combine the messages of several backups in one file,
+// write each of them to the RecordWriter of the mbox format (see NewRecordWriter).
+func GenerateMbox(m *Messages, outputDir string, opts ...OutputOption) error {
+	w, err := newMboxRecordWriter(outputDir, newOutputConfig(opts))
+	if err != nil {
+		return err
+	}
+	err = WriteMessages(w, m)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mboxRecordWriter is the RecordWriter of the mbox format. It appends the SMS and MMS messages of every backup to a
+// single file named "messages.mbox"; calls are not written.
+type mboxRecordWriter struct {
+	file *os.File
+	w    *bufio.Writer
+	cfg  *outputConfig
+}
+
+// newMboxRecordWriter creates "messages.mbox" in outputDir and returns an mboxRecordWriter writing to it according to
+// cfg.
+func newMboxRecordWriter(outputDir string, cfg *outputConfig) (*mboxRecordWriter, error) {
+	file, err := os.Create(filepath.Join(outputDir, "messages.mbox"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create file: messages.mbox\n%q", err)
+	}
+	return &mboxRecordWriter{file: file, w: bufio.NewWriter(file), cfg: cfg}, nil
+}
+
+// WriteSMS appends an SMS message to the mbox file.
+func (b *mboxRecordWriter) WriteSMS(i int, sms *SMS) error {
+	return writeMboxMessage(b.w, newSMSEmail(sms, b.cfg))
+}
+
+// WriteMMS appends an MMS message to the mbox file.
+func (b *mboxRecordWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	return writeMboxMessage(b.w, newMMSEmail(mms, b.cfg))
+}
+
+// WriteCall does nothing, as calls are not email messages.
+func (b *mboxRecordWriter) WriteCall(i int, call *Call) error {
+	return nil
+}
+
+// Close flushes and closes the mbox file, returning the first error encountered.
+func (b *mboxRecordWriter) Close() error {
+	err := b.w.Flush()
+	if closeErr := b.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeMboxMessage writes a message to an mbox file, preceded by its "From " separator line and with line endings and
+// "From " lines in its body converted to the mboxrd format.
+func writeMboxMessage(w *bufio.Writer, e *emailMessage) error {
+	var msg bytes.Buffer
+	if err := e.writeTo(&msg); err != nil {
+		return err
+	}
+	body := bytes.ReplaceAll(msg.Bytes(), []byte("\r\n"), []byte("\n"))
+	body = mboxFromLine.ReplaceAll(body, []byte(">$1"))
+
+	date := e.date
+	if date.IsZero() {
+		date = time.Unix(0, 0)
+	}
+	fmt.Fprintf(w, "From %s %s\n", e.from.Address, date.UTC().Format(time.ANSIC))
+	w.Write(body)
+	_, err := w.WriteString("\n")
+	return err
+}
+
+// GenerateEML outputs each parsed SMS and MMS message as an RFC 5322 email message to a directory named "eml", in
+// files named e.g. "sms-0.eml" and "mms-0.eml" after the index of the message, or e.g.
+// "sms-20180213135542_sms-0.eml" with WithSource so that the messages of several backups do not overwrite each other.
+// See GenerateMbox for how messages are converted.
+func GenerateEML(m *Messages, outputDir string, opts ...OutputOption) error {
+	w, err := newEMLRecordWriter(outputDir, newOutputConfig(opts))
+	if err != nil {
+		return err
+	}
+	return WriteMessages(w, m)
+}
+
+// emlRecordWriter is the RecordWriter of the eml format. It writes each SMS and MMS message to its own file in a
+// directory named "eml", with file names prefixed by the source of its backup (see BeginBackup); calls are not written.
+type emlRecordWriter struct {
+	emlDir string
+	cfg    *outputConfig
+}
+
+// newEMLRecordWriter creates the "eml" directory in outputDir and returns an emlRecordWriter writing files to it
+// according to cfg.
+func newEMLRecordWriter(outputDir string, cfg *outputConfig) (*emlRecordWriter, error) {
+	emlDir := filepath.Join(outputDir, "eml")
+	if err := os.MkdirAll(emlDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("Unable to create directory: %s\n%q", emlDir, err)
+	}
+	return &emlRecordWriter{emlDir: emlDir, cfg: cfg}, nil
+}
+
+// BeginBackup starts the messages of another backup, whose file names are prefixed as with WithSource(source), e.g.
+// "sms-20180213135542_sms-0.eml".
+func (e *emlRecordWriter) BeginBackup(source string, h *Header) error {
+	e.cfg.source = sourceName(source)
+	return nil
+}
+
+// fileName returns the name of the file of the message of the given kind ("sms" or "mms") and index.
+func (e *emlRecordWriter) fileName(kind string, i int) string {
+	fileName := fmt.Sprintf("%s-%d.eml", kind, i)
+	if e.cfg.source != "" {
+		fileName = e.cfg.source + "_" + fileName
+	}
+	return filepath.Join(e.emlDir, fileName)
+}
+
+// WriteSMS writes the SMS message with the given index to e.g. "sms-0.eml".
+func (e *emlRecordWriter) WriteSMS(i int, sms *SMS) error {
+	return writeEML(e.fileName("sms", i), newSMSEmail(sms, e.cfg))
+}
+
+// WriteMMS writes the MMS message with the given index to e.g. "mms-0.eml".
+func (e *emlRecordWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	return writeEML(e.fileName("mms", mmsIndex), newMMSEmail(mms, e.cfg))
+}
+
+// WriteCall does nothing, as calls are not email messages.
+func (e *emlRecordWriter) WriteCall(i int, call *Call) error {
+	return nil
+}
+
+// Close does nothing, as each file is closed once written.
+func (e *emlRecordWriter) Close() error {
+	return nil
+}
+
+// writeEML writes a message to its own file.
+func writeEML(outputPath string, e *emailMessage) error {
+	var msg bytes.Buffer
+	if err := e.writeTo(&msg); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, msg.Bytes(), 0644); err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", outputPath, err)
+	}
+	return nil
+}
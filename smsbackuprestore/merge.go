@@ -0,0 +1,220 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// recordKey identifies a record by its content, so the same record backed up more than once can be recognized.
+type recordKey [sha1.Size]byte
+
+// newRecordKey returns the recordKey of a record with the given fields.
+func newRecordKey(fields ...string) recordKey {
+	h := sha1.New()
+	for _, field := range fields {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	var key recordKey
+	h.Sum(key[:0])
+	return key
+}
+
+// smsKey returns the content key of an SMS: its date, normalized address, type, and body.
+func smsKey(sms *SMS) recordKey {
+	return newRecordKey("sms", string(sms.Date), sms.Address.String(), strconv.Itoa(int(sms.Type)), sms.Body)
+}
+
+// mmsKey returns the content key of an MMS: its date, normalized address, message box, and the content type, text,
+// and data of each of its parts.
+func mmsKey(mms *MMS) recordKey {
+	fields := []string{"mms", string(mms.Date), mms.Address.String(), strconv.Itoa(int(mms.MessageBox))}
+	for _, part := range mms.Parts {
+		partKey := newRecordKey(part.ContentType, part.Text, part.Base64Data)
+		fields = append(fields, string(partKey[:]))
+	}
+	return newRecordKey(fields...)
+}
+
+// callKey returns the content key of a call: its date, normalized number, type, and duration.
+func callKey(call *Call) recordKey {
+	return newRecordKey("call", string(call.Date), call.Number.String(), strconv.Itoa(int(call.Type)),
+		strconv.Itoa(call.Duration))
+}
+
+// MergeSource reports the records read from one backup added to a Merger.
+type MergeSource struct {
+	Name       string // name given when the backup was added, e.g. its file path
+	Records    int    // number of records in the backup
+	Duplicates int    // number of records dropped because an identical record was already added
+}
+
+// Merger combines SMS or calls backups into a single backup, dropping records found in more than one of them. Records
+// are considered the same if they have the same content key: the date, normalized address or number, type, and body
+// (for SMS), part contents (for MMS), or duration (for calls). The first copy of each record is kept. A Merger combines
+// backups of a single type; the zero value is ready to use.
+type Merger struct {
+	messages *Messages
+	calls    *Calls
+	seen     map[recordKey]struct{}
+	sources  []MergeSource
+}
+
+// add reports whether a record with the given key has not been added before, counting it in source otherwise.
+func (mg *Merger) add(key recordKey, source *MergeSource) bool {
+	if mg.seen == nil {
+		mg.seen = make(map[recordKey]struct{})
+	}
+	source.Records++
+	if _, dup := mg.seen[key]; dup {
+		source.Duplicates++
+		return false
+	}
+	mg.seen[key] = struct{}{}
+	return true
+}
+
+// AddMessages adds the SMS and MMS messages of an SMS backup, identified in the report by name.
+func (mg *Merger) AddMessages(name string, m *Messages) error {
+	if mg.calls != nil {
+		return fmt.Errorf("Unable to merge SMS backup into calls backup: %s", name)
+	}
+	if mg.messages == nil {
+		mg.messages = &Messages{XMLName: m.XMLName}
+	}
+	if mergeBackupInfo(&mg.messages.BackupSet, &mg.messages.BackupDate, m.BackupSet, m.BackupDate) {
+		mg.messages.OtherAttributes = m.OtherAttributes
+	}
+
+	source := MergeSource{Name: name}
+	for i := range m.SMS {
+		if mg.add(smsKey(&m.SMS[i]), &source) {
+			mg.messages.SMS = append(mg.messages.SMS, m.SMS[i])
+		}
+	}
+	for i := range m.MMS {
+		if mg.add(mmsKey(&m.MMS[i]), &source) {
+			mg.messages.MMS = append(mg.messages.MMS, m.MMS[i])
+		}
+	}
+	mg.sources = append(mg.sources, source)
+	return nil
+}
+
+// AddCalls adds the calls of a calls backup, identified in the report by name.
+func (mg *Merger) AddCalls(name string, c *Calls) error {
+	if mg.messages != nil {
+		return fmt.Errorf("Unable to merge calls backup into SMS backup: %s", name)
+	}
+	if mg.calls == nil {
+		mg.calls = &Calls{XMLName: c.XMLName}
+	}
+	if mergeBackupInfo(&mg.calls.BackupSet, &mg.calls.BackupDate, c.BackupSet, c.BackupDate) {
+		mg.calls.OtherAttributes = c.OtherAttributes
+	}
+
+	source := MergeSource{Name: name}
+	for i := range c.Calls {
+		if mg.add(callKey(&c.Calls[i]), &source) {
+			mg.calls.Calls = append(mg.calls.Calls, c.Calls[i])
+		}
+	}
+	mg.sources = append(mg.sources, source)
+	return nil
+}
+
+// AddBackup adds an SMS or calls backup, identified in the report by name.
+func (mg *Merger) AddBackup(name string, b *Backup) error {
+	if b.Messages != nil {
+		return mg.AddMessages(name, b.Messages)
+	}
+	return mg.AddCalls(name, b.Calls)
+}
+
+// mergeBackupInfo keeps the backup set and date of the most recent backup, reporting whether they were replaced by
+// set and date so that the other root attributes of the same backup can be kept along with them.
+func mergeBackupInfo(backupSet *string, backupDate *AndroidTS, set string, date AndroidTS) bool {
+	if *backupDate == "" || date.Time().After(backupDate.Time()) {
+		*backupSet, *backupDate = set, date
+		return true
+	}
+	return false
+}
+
+// Backup returns the merged backup, with its records sorted chronologically and its count set to the number of
+// records. It keeps the backup set, date, and other root attributes of the most recent backup added. It returns nil if
+// no backups have been added.
+func (mg *Merger) Backup() *Backup {
+	switch {
+	case mg.messages != nil:
+		m := mg.messages
+		sort.SliceStable(m.SMS, func(i, j int) bool { return m.SMS[i].Date.Time().Before(m.SMS[j].Date.Time()) })
+		sort.SliceStable(m.MMS, func(i, j int) bool { return m.MMS[i].Date.Time().Before(m.MMS[j].Date.Time()) })
+		m.Count = strconv.Itoa(len(m.SMS) + len(m.MMS))
+		return &Backup{Messages: m}
+	case mg.calls != nil:
+		c := mg.calls
+		sort.SliceStable(c.Calls, func(i, j int) bool { return c.Calls[i].Date.Time().Before(c.Calls[j].Date.Time()) })
+		c.Count = strconv.Itoa(len(c.Calls))
+		return &Backup{Calls: c}
+	}
+	return nil
+}
+
+// Sources returns a report of the records read from each backup added, in the order they were added.
+func (mg *Merger) Sources() []MergeSource {
+	return mg.sources
+}
+
+// MergeMessages combines SMS backups as described for Merger, returning the merged backup and a report of the records
+// read from each input, in the same order as the inputs and named by their index.
+func MergeMessages(inputs ...*Messages) (*Messages, []MergeSource) {
+	var mg Merger
+	for i, m := range inputs {
+		mg.AddMessages(strconv.Itoa(i), m)
+	}
+	if b := mg.Backup(); b != nil {
+		return b.Messages, mg.Sources()
+	}
+	return &Messages{}, nil
+}
+
+// MergeCalls combines calls backups as described for Merger, returning the merged backup and a report of the records
+// read from each input, in the same order as the inputs and named by their index.
+func MergeCalls(inputs ...*Calls) (*Calls, []MergeSource) {
+	var mg Merger
+	for i, c := range inputs {
+		mg.AddCalls(strconv.Itoa(i), c)
+	}
+	if b := mg.Backup(); b != nil {
+		return b.Calls, mg.Sources()
+	}
+	return &Calls{}, nil
+}
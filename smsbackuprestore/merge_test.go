@@ -0,0 +1,165 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testMergeMMS returns an MMS with a single image part with the given data.
+func testMergeMMS(date AndroidTS, data string) MMS {
+	return MMS{Date: date, Address: "13125551212", MessageBox: 1,
+		Parts: []Part{{ContentType: "image/png", Base64Data: data}}}
+}
+
+func TestMergerMessages(t *testing.T) {
+	older := &Messages{
+		BackupSet:  "older",
+		BackupDate: "1704067200000",
+		SMS: []SMS{
+			{Date: "1704060300000", Address: "13125551212", Type: 1, Body: "third"},
+			{Date: "1704060100000", Address: "13125551212", Type: 1, Body: "first"},
+		},
+		MMS: []MMS{testMergeMMS("1704060000000", "AAAA")},
+	}
+	newer := &Messages{
+		BackupSet:  "newer",
+		BackupDate: "1704153600000",
+		SMS: []SMS{
+			// the same SMS with its number formatted differently
+			{Date: "1704060100000", Address: "(312) 555-1212", Type: 1, Body: "first"},
+			// not duplicates: a different body, and a different type
+			{Date: "1704060100000", Address: "13125551212", Type: 1, Body: "first!"},
+			{Date: "1704060100000", Address: "13125551212", Type: 2, Body: "first"},
+			{Date: "1704060200000", Address: "13125551212", Type: 1, Body: "second"},
+		},
+		MMS: []MMS{testMergeMMS("1704060000000", "AAAA"), testMergeMMS("1704060000000", "BBBB")},
+	}
+
+	// the newer backup is added first, so its backup set is kept although the older one is added later
+	var mg Merger
+	if err := mg.AddMessages("newer.xml", newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := mg.AddMessages("older.xml", older); err != nil {
+		t.Fatal(err)
+	}
+	b := mg.Backup()
+	if b == nil || b.Messages == nil {
+		t.Fatal("got no merged messages")
+	}
+	m := b.Messages
+
+	var bodies []string
+	for _, sms := range m.SMS {
+		bodies = append(bodies, sms.Body)
+	}
+	if want := []string{"first", "first!", "first", "second", "third"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("got SMS bodies %q, want %q", bodies, want)
+	}
+	if m.SMS[0].Address != "(312) 555-1212" {
+		t.Errorf("got address %q of the first SMS, want the first copy added", m.SMS[0].Address)
+	}
+	if len(m.MMS) != 2 || m.MMS[0].Parts[0].Base64Data != "AAAA" || m.MMS[1].Parts[0].Base64Data != "BBBB" {
+		t.Errorf("got MMS %+v, want those with data AAAA and BBBB", m.MMS)
+	}
+	if m.Count != "7" {
+		t.Errorf("got count %q, want 7", m.Count)
+	}
+	if m.BackupSet != "newer" || m.BackupDate != "1704153600000" {
+		t.Errorf("got backup set %q and date %q, want those of the newer backup", m.BackupSet, m.BackupDate)
+	}
+
+	want := []MergeSource{{Name: "newer.xml", Records: 6}, {Name: "older.xml", Records: 3, Duplicates: 2}}
+	if got := mg.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got sources %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeCalls(t *testing.T) {
+	first := &Calls{BackupSet: "first", BackupDate: "1704067200000", Calls: []Call{
+		{Date: "1704060200000", Number: "13125551212", Type: 1, Duration: 65},
+		{Date: "1704060000000", Number: "13125551212", Type: 3},
+	}}
+	second := &Calls{BackupSet: "second", BackupDate: "1704153600000", Calls: []Call{
+		{Date: "1704060000000", Number: "+1 312 555 1212", Type: 3},
+		{Date: "1704060200000", Number: "13125551212", Type: 1, Duration: 66},
+		{Date: "1704060100000", Number: "13125553434", Type: 2, Duration: 10},
+	}}
+
+	c, sources := MergeCalls(first, second)
+	var got []string
+	for _, call := range c.Calls {
+		got = append(got, string(call.Date)+" "+call.Number.String())
+	}
+	want := []string{"1704060000000 13125551212", "1704060100000 13125553434", "1704060200000 13125551212",
+		"1704060200000 13125551212"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls %q, want %q", got, want)
+	}
+	if c.Calls[2].Duration != 65 || c.Calls[3].Duration != 66 {
+		t.Errorf("got durations %d and %d, want 65 and 66", c.Calls[2].Duration, c.Calls[3].Duration)
+	}
+	if c.Count != "4" || c.BackupSet != "second" || c.BackupDate != "1704153600000" {
+		t.Errorf("got count %q, backup set %q, and date %q", c.Count, c.BackupSet, c.BackupDate)
+	}
+	wantSources := []MergeSource{{Name: "0", Records: 2}, {Name: "1", Records: 3, Duplicates: 1}}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("got sources %+v, want %+v", sources, wantSources)
+	}
+}
+
+func TestMergerMixedTypes(t *testing.T) {
+	m := &Messages{SMS: []SMS{{Date: "1704060000000", Address: "13125551212", Body: "hi"}}}
+	c := &Calls{Calls: []Call{{Date: "1704060000000", Number: "13125551212"}}}
+
+	var messages Merger
+	if err := messages.AddMessages("sms.xml", m); err != nil {
+		t.Fatal(err)
+	}
+	if err := messages.AddBackup("calls.xml", &Backup{Calls: c}); err == nil {
+		t.Error("got no error adding calls to messages")
+	}
+	if b := messages.Backup(); b.Calls != nil || len(messages.Sources()) != 1 {
+		t.Errorf("calls were merged into messages: %+v", messages.Sources())
+	}
+
+	var calls Merger
+	if err := calls.AddCalls("calls.xml", c); err != nil {
+		t.Fatal(err)
+	}
+	if err := calls.AddBackup("sms.xml", &Backup{Messages: m}); err == nil {
+		t.Error("got no error adding messages to calls")
+	}
+	if b := calls.Backup(); b.Messages != nil || len(calls.Sources()) != 1 {
+		t.Errorf("messages were merged into calls: %+v", calls.Sources())
+	}
+
+	var empty Merger
+	if b := empty.Backup(); b != nil {
+		t.Errorf("got backup %+v from an empty Merger, want nil", b)
+	}
+}
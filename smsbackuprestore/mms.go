@@ -25,10 +25,10 @@ SOFTWARE.
 package smsbackuprestore
 
 import (
+	"path"
 	"path/filepath"
 	"os"
 	"strings"
-	"fmt"
 	"strconv"
 )
 
@@ -62,113 +62,302 @@ func DecodeImages(m *Messages, mainOutputDir string) (numImagesIdentified, numIm
 	return numImagesIdentified, numImagesSuccessfullyWritten, errors
 }
 
-// GenerateMMSOutput outputs a tab-delimited file named "mms.tsv" containing parsed MMS messages from the backup file.
-func GenerateMMSOutput(m *Messages, outputDir string) error {
-	mmsOutput, err := os.Create(filepath.Join(outputDir, "mms.tsv"))
-	if err != nil {
-		return fmt.Errorf("Unable to create file: mms.tsv\n%q", err)
-	}
-	defer mmsOutput.Close()
-
-	// print header row
-	headers := []string{
-		"MMS Index #",
-		"MMS Part Index #",
-		"Text Only",
-		"Read",
-		"Date",
-		"Locked",
-		"Date Sent",
-		"Readable Date",
-		"Contact Name",
-		"Seen",
-		"From Address",
-		"Address",
-		"Addresses",
-		"Message Classifier",
-		"Message Size",
-		"Part Content Type",
-		"Part Name",
-		"Part File Name",
-		"Part Text",
-		"Part Content Display",
-		"Part Output Image Name",
+// DecodeAttachments decodes the base64-encoded data of every MMS part that has any, such as images, video, audio, and
+// vCards, and outputs it to files with a unique file name tied to the MMS and part index numbers. Images are written to
+// the "images" directory, as with DecodeImages, and other parts to the "attachments" directory. JSON and Parquet output
+// reference these files rather than including the data itself. When decoding several backups to the same directory,
+// use WithSource so their files are named apart.
+func DecodeAttachments(m *Messages, mainOutputDir string, opts ...OutputOption) (numIdentified, numSuccessfullyWritten int, errors []error) {
+	w := NewAttachmentWriter(mainOutputDir, opts...)
+	for mmsIndex := range m.MMS {
+		w.WriteMMS(mmsIndex, &m.MMS[mmsIndex])
 	}
-	fmt.Fprintf(mmsOutput, "%s\n", strings.Join(headers, "\t"))
+	return w.Identified, w.Written, w.Errors
+}
 
-	// iterate over mms
-	for mmsIndex, mms := range m.MMS {
-		var names []string
-		var numbers []string
-		var addresses []string
-		var contactNameList string
-		var addressList string
-		addressesList := ""
-
-		groupMessage := false
-		if strings.Contains(mms.ContactName, ",") || strings.Contains(mms.Address.String(), "~") {
-			groupMessage = true
-
-			// get names
-			for _, name := range strings.Split(RemoveCommasBeforeSuffixes(mms.ContactName), ",") {
-				names = append(names, strings.TrimSpace(name))
-			}
+// AttachmentWriter is a RecordWriter that decodes the parts of each MMS message as DecodeAttachments does, so that
+// attachments can be written while a backup is read by a Parser. SMS messages and calls are ignored.
+type AttachmentWriter struct {
+	Identified int     // number of parts with data
+	Written    int     // number of parts successfully written to file
+	Errors     []error // errors decoding or writing parts, which do not stop other parts from being written
 
-			// get/normalize phone numbers
-			for _, number := range strings.Split(mms.Address.String(), "~") {
-				numbers = append(numbers, PhoneNumber(number).String())
-			}
+	outputDir string
+	cfg       *outputConfig
+	created   map[string]bool
+}
+
+// NewAttachmentWriter returns an AttachmentWriter writing files to the "images" and "attachments" directories of
+// outputDir according to opts.
+func NewAttachmentWriter(outputDir string, opts ...OutputOption) *AttachmentWriter {
+	return &AttachmentWriter{outputDir: outputDir, cfg: newOutputConfig(opts), created: make(map[string]bool)}
+}
+
+// BeginBackup starts the messages of another backup, whose files are named as with WithSource(source).
+func (w *AttachmentWriter) BeginBackup(source string, h *Header) error {
+	w.cfg.source = sourceName(source)
+	return nil
+}
+
+// WriteSMS does nothing, as SMS messages have no attachments.
+func (w *AttachmentWriter) WriteSMS(i int, sms *SMS) error {
+	return nil
+}
+
+// WriteMMS writes each part of the MMS message with the given index that has data to a file. Errors are recorded in
+// Errors rather than returned.
+func (w *AttachmentWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	for partIndex := range mms.Parts {
+		part := &mms.Parts[partIndex]
+		attachmentPath := w.cfg.attachmentPath(part, mmsIndex, partIndex)
+		if attachmentPath == "" {
+			continue
 		}
+		w.Identified++
 
-		// create semicolon-delimited output for group messages
-		if groupMessage {
-			// semicolon-delimited fields
-			contactNameList = strings.Join(names, ";")
-			addressList = strings.Join(numbers, ";")
+		// create output directory for attachments of this type
+		outputPath := filepath.Join(w.outputDir, filepath.FromSlash(attachmentPath))
+		if outputDir := filepath.Dir(outputPath); !w.created[outputDir] {
+			os.MkdirAll(outputDir, os.ModePerm)
+			w.created[outputDir] = true
+		}
+
+		if err := part.DecodeAndWriteImage(outputPath); err != nil {
+			w.Errors = append(w.Errors, err)
 		} else {
-			contactNameList = RemoveCommasBeforeSuffixes(mms.ContactName)
-			addressList = mms.Address.String()
+			w.Written++
 		}
+	}
+	return nil
+}
+
+// WriteCall does nothing, as calls have no attachments.
+func (w *AttachmentWriter) WriteCall(i int, call *Call) error {
+	return nil
+}
+
+// Close does nothing, as each file is closed once written.
+func (w *AttachmentWriter) Close() error {
+	return nil
+}
+
+// attachmentPath returns the path (relative to the output directory, with forward slashes) of the file written by
+// DecodeAttachments for the MMS part with the given indices, or an empty string if the part has no data.
+func (c *outputConfig) attachmentPath(part *Part, mmsIndex int, partIndex int) string {
+	if !part.HasData() {
+		return ""
+	}
+	dir := "attachments"
+	if strings.Contains(part.ContentType, "image/") {
+		dir = "images"
+	}
+	return path.Join(dir, c.attachmentFileName(part, mmsIndex, partIndex))
+}
+
+// attachmentFileName returns the name of the file written by DecodeAttachments for the MMS part with the given
+// indices, prefixed with the source given by WithSource, if any.
+func (c *outputConfig) attachmentFileName(part *Part, mmsIndex int, partIndex int) string {
+	fileName := part.ImageFileName(mmsIndex, partIndex)
+	if c.source != "" {
+		fileName = c.source + "_" + fileName
+	}
+	return fileName
+}
+
+// mmsHeaders are the column headers of MMS output.
+var mmsHeaders = []string{
+	"MMS Index #",
+	"MMS Part Index #",
+	"Direction",
+	"Text Only",
+	"Read",
+	"Date",
+	"Locked",
+	"Date Sent",
+	"Readable Date",
+	"Contact Name",
+	"Seen",
+	"From Address",
+	"Address",
+	"Addresses",
+	"Sender",
+	"Recipients",
+	"Message Classifier",
+	"Message Size",
+	"Part Content Type",
+	"Part Name",
+	"Part File Name",
+	"Part Text",
+	"Part Content Display",
+	"Part Sequence",
+	"Part Charset",
+	"Part Content ID",
+	"Part Content Location",
+	"Part CTT Start",
+	"Part CTT Type",
+	"Part Other Attributes",
+	"Part Output Image Name",
+	"Message Box",
+	"Message Type",
+	"Message ID",
+	"Subject",
+	"Subject Charset",
+	"Content Type",
+	"Content Location",
+	"Transaction ID",
+	"Delivery Report",
+	"Read Report",
+	"Read Status",
+	"Expiry",
+	"Priority",
+	"Response Status",
+	"Subscription ID",
+	"Other Attributes",
+	"UTC Offset",
+}
+
+// GenerateMMSOutput outputs a tab-delimited file named "mms.tsv" containing parsed MMS messages from the backup file.
+func GenerateMMSOutput(m *Messages, outputDir string, opts ...OutputOption) error {
+	return generateMMSTable(m, outputDir, newOutputConfig(opts))
+}
+
+// GenerateMMSCSV outputs an RFC 4180 comma-separated file named "mms.csv" containing parsed MMS messages from the
+// backup file. Unlike GenerateMMSOutput, the text of message parts is output exactly as it appears in the backup.
+func GenerateMMSCSV(m *Messages, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+	cfg.csv = true
+	return generateMMSTable(m, outputDir, cfg)
+}
+
+// generateMMSTable outputs a delimited file containing parsed MMS messages. The file is created even if there are no
+// messages.
+func generateMMSTable(m *Messages, outputDir string, cfg *outputConfig) error {
+	w := newTableRecordWriter(outputDir, cfg)
+	err := w.open(&w.mms, "mms", mmsHeaders)
+
+	// iterate over mms
+	for mmsIndex := 0; err == nil && mmsIndex < len(m.MMS); mmsIndex++ {
+		err = w.WriteMMS(mmsIndex, &m.MMS[mmsIndex])
+	}
+
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mmsRows returns the rows of MMS output for the MMS with the given index, one per part.
+func mmsRows(mmsIndex int, mms *MMS, cfg *outputConfig) [][]string {
+	var rows [][]string
+
+	var names []string
+	var numbers []string
+	var addresses []string
+	var contactNameList string
+	var addressList string
+	addressesList := ""
 
-		// get any addresses for group message
-		for _, addr := range mms.Addresses {
-			addresses = append(addresses, addr.Address.String())
+	groupMessage := false
+	if strings.Contains(mms.ContactName, ",") || strings.Contains(mms.Address.String(), "~") {
+		groupMessage = true
+
+		// get names
+		for _, name := range strings.Split(RemoveCommasBeforeSuffixes(mms.ContactName), ",") {
+			names = append(names, strings.TrimSpace(name))
 		}
-		if len(addresses) > 0 {
-			addressesList = strings.Join(addresses, ";")
+
+		// get/normalize phone numbers
+		for _, number := range strings.Split(mms.Address.String(), "~") {
+			numbers = append(numbers, PhoneNumber(number).String())
 		}
+	}
 
-		for partIndex, part := range mms.Parts {
-			imageFile := "N/A"
-			if strings.Contains(part.ContentType, "image/") {
-				imageFile = part.ImageFileName(mmsIndex, partIndex)
-			}
+	// create semicolon-delimited output for group messages
+	if groupMessage {
+		// semicolon-delimited fields
+		contactNameList = strings.Join(names, ";")
+		addressList = strings.Join(numbers, ";")
+	} else {
+		contactNameList = RemoveCommasBeforeSuffixes(mms.ContactName)
+		addressList = mms.Address.String()
+	}
 
-			row := []string{
-				strconv.Itoa(mmsIndex),
-				strconv.Itoa(partIndex),
-				mms.TextOnly.String(),
-				mms.Read.String(),
-				mms.Date.String(),
-				mms.Locked.String(),
-				mms.DateSent.String(),
-				mms.ReadableDate,
-				contactNameList,
-				mms.Seen.String(),
-				mms.FromAddress.String(),
-				addressList,
-				addressesList,
-				mms.MessageClassifier,
-				mms.MessageSize,
-				part.ContentType,
-				part.Name,
-				part.FileName,
-				CleanupMessageBody(part.Text),
-				part.ContentDisplay,
-				imageFile,
-			}
-			fmt.Fprintf(mmsOutput, "%s\n", strings.Join(row, "\t"))
+	// get any addresses for group message
+	for _, addr := range mms.Addresses {
+		addresses = append(addresses, addr.Address.String())
+	}
+	if len(addresses) > 0 {
+		addressesList = strings.Join(addresses, ";")
+	}
+
+	recipients := recipientList(mms)
+	utcOffset := formatRecordOffset(mms.UTCOffset())
+
+	for partIndex, part := range mms.Parts {
+		imageFile := "N/A"
+		if strings.Contains(part.ContentType, "image/") {
+			imageFile = cfg.attachmentFileName(&mms.Parts[partIndex], mmsIndex, partIndex)
 		}
+
+		rows = append(rows, []string{
+			strconv.Itoa(mmsIndex),
+			strconv.Itoa(partIndex),
+			mms.Direction().String(),
+			mms.TextOnly.String(),
+			mms.Read.String(),
+			cfg.formatTime(mms.Date),
+			mms.Locked.String(),
+			cfg.formatTime(mms.DateSent),
+			mms.ReadableDate,
+			contactNameList,
+			mms.Seen.String(),
+			mms.FromAddress.String(),
+			addressList,
+			addressesList,
+			mms.Sender().String(),
+			recipients,
+			mms.MessageClassifier,
+			mms.MessageSize,
+			part.ContentType,
+			part.Name,
+			part.FileName,
+			cfg.text(part.Text),
+			part.ContentDisplay,
+			part.Sequence,
+			part.Charset.String(),
+			part.ContentID,
+			part.ContentLocation,
+			part.ContentTypeStart,
+			part.ContentTypeType,
+			cfg.text(FormatAttributes(part.OtherAttributes)),
+			imageFile,
+			mms.MessageBox.String(),
+			mms.MessageType.String(),
+			mms.MessageID,
+			cfg.text(mms.Subject),
+			mms.SubjectCharset.String(),
+			mms.ContentType,
+			mms.ContentLocation,
+			mms.TransactionID,
+			mms.DeliveryReport.String(),
+			mms.ReadReport.String(),
+			mms.ReadStatus.String(),
+			mms.Expiry,
+			mms.Priority.String(),
+			mms.ResponseStatus.String(),
+			mms.SubscriptionID,
+			cfg.text(FormatAttributes(mms.OtherAttributes)),
+			utcOffset,
+		})
 	}
-	return nil
+	return rows
+}
+
+// recipientList returns the recipients of mms tagged with their role, e.g. To:13125551212;Cc:13125553434
+func recipientList(mms *MMS) string {
+	var recipients []string
+	for _, addr := range mms.Recipients() {
+		recipients = append(recipients, addr.Type.String()+":"+addr.Address.String())
+	}
+	return strings.Join(recipients, ";")
 }
@@ -0,0 +1,381 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Layouts for formatting timestamps in output (see WithTimeLayout).
+const (
+	// TimeLayoutDefault is the layout of time.Time.String, e.g. "2018-01-01 00:00:00 +0000 UTC".
+	TimeLayoutDefault = "2006-01-02 15:04:05 -0700 MST"
+	// TimeLayoutRFC3339 is RFC 3339 with whole seconds, e.g. "2018-01-01T00:00:00Z".
+	TimeLayoutRFC3339 = time.RFC3339
+	// TimeLayoutISO8601 is ISO 8601 with milliseconds, e.g. "2018-01-01T00:00:00.000Z".
+	TimeLayoutISO8601 = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// OutputOption configures the output generated by functions such as GenerateSMSOutput, GenerateMMSOutput, and
+// GenerateCallOutput.
+type OutputOption func(*outputConfig)
+
+// outputConfig holds the settings applied by OutputOptions.
+type outputConfig struct {
+	location        *time.Location
+	timeLayout      string
+	csv             bool
+	delimiter       rune
+	quoting         QuoteMode
+	headerStyle     HeaderStyle
+	ndjson          bool
+	attachmentPaths bool
+	source          string // base name of the backup file without extension, if given
+}
+
+// WithTimeZone renders timestamps in the given time zone rather than UTC.
+func WithTimeZone(loc *time.Location) OutputOption {
+	return func(c *outputConfig) {
+		c.location = loc
+	}
+}
+
+// WithTimeLayout renders timestamps using the given layout (see time.Time.Format) rather than TimeLayoutDefault.
+func WithTimeLayout(layout string) OutputOption {
+	return func(c *outputConfig) {
+		c.timeLayout = layout
+	}
+}
+
+// newOutputConfig applies opts to the default output settings.
+func newOutputConfig(opts []OutputOption) *outputConfig {
+	c := &outputConfig{
+		location:   time.UTC,
+		timeLayout: TimeLayoutDefault,
+		delimiter:  ',',
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// formatTime formats timestamp according to the output settings.
+func (c *outputConfig) formatTime(timestamp AndroidTS) string {
+	return timestamp.Format(c.location, c.timeLayout)
+}
+
+// QuoteMode controls which fields are quoted in CSV output.
+type QuoteMode int
+
+const (
+	// QuoteMinimal quotes only fields containing the delimiter, quotes, or line breaks.
+	QuoteMinimal QuoteMode = iota
+	// QuoteAll quotes every field.
+	QuoteAll
+)
+
+// HeaderStyle controls the naming of column headers in delimited output.
+type HeaderStyle int
+
+const (
+	// HeaderDisplay names columns for display, e.g. "Date Sent".
+	HeaderDisplay HeaderStyle = iota
+	// HeaderSnakeCase names columns for use as identifiers, e.g. "date_sent".
+	HeaderSnakeCase
+	// HeaderNone omits the header row.
+	HeaderNone
+)
+
+// ValidDelimiter reports whether r can separate the fields of CSV output. As with encoding/csv, the delimiter may not
+// be a quote, a line break, or an invalid or replacement character.
+func ValidDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// WithDelimiter separates fields of CSV output with the given character rather than a comma. CSV output fails if the
+// delimiter is not valid (see ValidDelimiter).
+func WithDelimiter(delimiter rune) OutputOption {
+	return func(c *outputConfig) {
+		c.delimiter = delimiter
+	}
+}
+
+// WithQuoting sets which fields of CSV output are quoted.
+func WithQuoting(mode QuoteMode) OutputOption {
+	return func(c *outputConfig) {
+		c.quoting = mode
+	}
+}
+
+// WithHeaderStyle sets the naming of column headers in delimited output.
+func WithHeaderStyle(style HeaderStyle) OutputOption {
+	return func(c *outputConfig) {
+		c.headerStyle = style
+	}
+}
+
+// WithSource prefixes the names of the files written for records, such as the attachments decoded by
+// DecodeAttachments, with the base name of the backup file at backupPath (without extension), so that the files of
+// several backups do not overwrite each other. Output that references those files must be given the same source.
+func WithSource(backupPath string) OutputOption {
+	return func(c *outputConfig) {
+		c.source = sourceName(backupPath)
+	}
+}
+
+// sourceName returns the base name of the backup file at backupPath without extension, e.g. "sms-20180213135542" for
+// "/backups/sms-20180213135542.xml", or an empty string if backupPath is empty.
+func sourceName(backupPath string) string {
+	if backupPath == "" {
+		return ""
+	}
+	base := filepath.Base(backupPath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// backupFileName prefixes the name of an output file (e.g. "sms.tsv") with the source name of the backup whose records
+// it contains, e.g. "sms-20180213135542_sms.tsv", so that the output of several backups written to the same directory
+// does not overwrite each other. Without a source name, the file name is returned unchanged.
+func backupFileName(source, fileName string) string {
+	if source == "" {
+		return fileName
+	}
+	return source + "_" + fileName
+}
+
+// text prepares free text such as message bodies for output. Newlines and tabs are replaced in TSV output, which
+// cannot represent them, while CSV output keeps text exactly as it appears in the backup.
+func (c *outputConfig) text(s string) string {
+	if c.csv {
+		return s
+	}
+	return CleanupMessageBody(s)
+}
+
+// SnakeCaseHeader converts a column header such as "Duration (Seconds)" to snake case ("duration_seconds").
+func SnakeCaseHeader(header string) string {
+	words := strings.FieldsFunc(strings.ToLower(header), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "_")
+}
+
+// tableWriter writes rows of delimited output as TSV or, if configured, as RFC 4180 CSV. CSV rows end with a line feed
+// so that line breaks within fields are kept exactly as they appear in the backup.
+type tableWriter struct {
+	w   *bufio.Writer
+	cfg *outputConfig
+	csv *csv.Writer
+	err error
+}
+
+// newTableWriter returns a tableWriter writing to w according to cfg.
+func newTableWriter(w io.Writer, cfg *outputConfig) *tableWriter {
+	t := &tableWriter{w: bufio.NewWriter(w), cfg: cfg}
+	if cfg.csv {
+		if !ValidDelimiter(cfg.delimiter) {
+			t.err = fmt.Errorf("Invalid delimiter: %q", cfg.delimiter)
+		}
+		t.csv = csv.NewWriter(t.w)
+		t.csv.Comma = cfg.delimiter
+		// with UseCRLF, encoding/csv also rewrites line breaks within fields, which would alter message bodies
+		t.csv.UseCRLF = false
+	}
+	return t
+}
+
+// writeHeader writes the header row, named according to the configured HeaderStyle.
+func (t *tableWriter) writeHeader(headers []string) {
+	switch t.cfg.headerStyle {
+	case HeaderNone:
+		return
+	case HeaderSnakeCase:
+		names := make([]string, len(headers))
+		for i, header := range headers {
+			names[i] = SnakeCaseHeader(header)
+		}
+		headers = names
+	}
+	t.writeRow(headers)
+}
+
+// writeRow writes a single row. The first error encountered is returned by flush.
+func (t *tableWriter) writeRow(fields []string) {
+	if t.err != nil {
+		return
+	}
+
+	switch {
+	case t.csv == nil:
+		_, t.err = fmt.Fprintf(t.w, "%s\n", strings.Join(fields, "\t"))
+	case t.cfg.quoting == QuoteAll:
+		// encoding/csv only quotes fields when necessary
+		t.csv.Flush()
+		for i, field := range fields {
+			if i > 0 {
+				t.w.WriteRune(t.cfg.delimiter)
+			}
+			t.w.WriteString(`"` + strings.Replace(field, `"`, `""`, -1) + `"`)
+		}
+		_, t.err = t.w.WriteString("\n")
+	default:
+		t.err = t.csv.Write(fields)
+	}
+}
+
+// flush writes any buffered rows, returning the first error encountered.
+func (t *tableWriter) flush() error {
+	if t.csv != nil {
+		t.csv.Flush()
+		if err := t.csv.Error(); err != nil && t.err == nil {
+			t.err = err
+		}
+	}
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// tableFile is a delimited output file and the tableWriter writing rows to it.
+type tableFile struct {
+	file  *os.File
+	table *tableWriter
+}
+
+// tableRecordWriter is the RecordWriter of the tsv and csv formats. It writes files named "sms.tsv", "mms.tsv", and
+// "calls.tsv" (or "sms.csv" and so on). BeginBackup creates the files for the records of a backup, even if it has none
+// of a type, named after its source (e.g. "sms-20180213135542_sms.tsv") so that each backup has its own files;
+// otherwise each file is created when the first record of its type is written.
+type tableRecordWriter struct {
+	outputDir string
+	cfg       *outputConfig
+	backup    string // source name of the current backup, prefixing file names
+	sms       *tableFile
+	mms       *tableFile
+	calls     *tableFile
+}
+
+// newTableRecordWriter returns a tableRecordWriter writing files to outputDir according to cfg.
+func newTableRecordWriter(outputDir string, cfg *outputConfig) *tableRecordWriter {
+	return &tableRecordWriter{outputDir: outputDir, cfg: cfg}
+}
+
+// open creates the delimited file with the given base name (e.g. "sms") and writes its header row, if not already
+// created.
+func (t *tableRecordWriter) open(f **tableFile, baseName string, headers []string) error {
+	if *f != nil {
+		return nil
+	}
+
+	fileName := baseName + ".tsv"
+	if t.cfg.csv {
+		fileName = baseName + ".csv"
+	}
+	fileName = backupFileName(t.backup, fileName)
+	file, err := os.Create(filepath.Join(t.outputDir, fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", fileName, err)
+	}
+
+	*f = &tableFile{file: file, table: newTableWriter(file, t.cfg)}
+	(*f).table.writeHeader(headers)
+	return nil
+}
+
+// WriteSMS writes the SMS with the given index to "sms.tsv" or "sms.csv".
+func (t *tableRecordWriter) WriteSMS(i int, sms *SMS) error {
+	if err := t.open(&t.sms, "sms", smsHeaders); err != nil {
+		return err
+	}
+	t.sms.table.writeRow(smsRow(i, sms, t.cfg))
+	return t.sms.table.err
+}
+
+// WriteMMS writes the MMS with the given index to "mms.tsv" or "mms.csv", one row per part.
+func (t *tableRecordWriter) WriteMMS(mmsIndex int, mms *MMS) error {
+	if err := t.open(&t.mms, "mms", mmsHeaders); err != nil {
+		return err
+	}
+	for _, row := range mmsRows(mmsIndex, mms, t.cfg) {
+		t.mms.table.writeRow(row)
+	}
+	return t.mms.table.err
+}
+
+// WriteCall writes the call with the given index to "calls.tsv" or "calls.csv".
+func (t *tableRecordWriter) WriteCall(i int, call *Call) error {
+	if err := t.open(&t.calls, "calls", callHeaders); err != nil {
+		return err
+	}
+	t.calls.table.writeRow(callRow(i, call, t.cfg))
+	return t.calls.table.err
+}
+
+// BeginBackup closes the files of the previous backup, if any, and creates "<source>_sms.tsv" and "<source>_mms.tsv"
+// for a messages backup or "<source>_calls.tsv" for a calls backup (or "<source>_sms.csv" and so on), where <source> is
+// the base name of source without extension. Attachment file names are prefixed as with WithSource(source).
+func (t *tableRecordWriter) BeginBackup(source string, h *Header) error {
+	if err := t.Close(); err != nil {
+		return err
+	}
+	t.cfg.source = sourceName(source)
+	t.backup = t.cfg.source
+	if h.BackupType() == CallsBackup {
+		return t.open(&t.calls, "calls", callHeaders)
+	}
+	if err := t.open(&t.sms, "sms", smsHeaders); err != nil {
+		return err
+	}
+	return t.open(&t.mms, "mms", mmsHeaders)
+}
+
+// Close flushes and closes each file, returning the first error encountered.
+func (t *tableRecordWriter) Close() error {
+	var firstErr error
+	for _, f := range []*tableFile{t.sms, t.mms, t.calls} {
+		if f == nil {
+			continue
+		}
+		if err := f.table.flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	t.sms, t.mms, t.calls = nil, nil, nil
+	return firstErr
+}
@@ -0,0 +1,196 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTableWriterDelimiter(t *testing.T) {
+	for _, quoting := range []QuoteMode{QuoteMinimal, QuoteAll} {
+		for _, delimiter := range []rune{'"', '\r', '\n', 0, '\uFFFD', -1} {
+			var b strings.Builder
+			cfg := newOutputConfig([]OutputOption{WithDelimiter(delimiter), WithQuoting(quoting)})
+			cfg.csv = true
+			w := newTableWriter(&b, cfg)
+			w.writeRow([]string{"a", "b"})
+			if err := w.flush(); err == nil {
+				t.Errorf("delimiter %q with quoting %d: got no error", delimiter, quoting)
+			}
+			if b.Len() != 0 {
+				t.Errorf("delimiter %q with quoting %d: wrote %q", delimiter, quoting, b.String())
+			}
+		}
+
+		var b strings.Builder
+		cfg := newOutputConfig([]OutputOption{WithDelimiter(';'), WithQuoting(quoting)})
+		cfg.csv = true
+		w := newTableWriter(&b, cfg)
+		w.writeRow([]string{"a;1", "b"})
+		if err := w.flush(); err != nil {
+			t.Errorf("delimiter ';' with quoting %d: got error %v", quoting, err)
+		}
+		if want := map[QuoteMode]string{QuoteMinimal: "\"a;1\";b\n", QuoteAll: "\"a;1\";\"b\"\n"}[quoting]; b.String() != want {
+			t.Errorf("delimiter ';' with quoting %d: got %q, want %q", quoting, b.String(), want)
+		}
+	}
+}
+
+func TestTableRecordWriterBeginBackup(t *testing.T) {
+	dir := t.TempDir()
+	w := newTableRecordWriter(dir, newOutputConfig(nil))
+
+	// a messages backup without MMS still has an mms.tsv file, and each backup has its own files
+	m1 := &Messages{SMS: []SMS{{Address: "5551212", Body: "hi"}}}
+	if err := WriteBackup(w, "backups/sms-1.xml", &Backup{Messages: m1}); err != nil {
+		t.Fatal(err)
+	}
+	m2 := &Messages{SMS: []SMS{{Address: "5551212", Body: "hi"}, {Address: "5551212", Body: "bye"}}}
+	if err := WriteBackup(w, "backups/sms-2.xml", &Backup{Messages: m2}); err != nil {
+		t.Fatal(err)
+	}
+	c := &Calls{Calls: []Call{{Number: "5551212"}}}
+	if err := WriteBackup(w, "calls.xml", &Backup{Calls: c}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"sms-1_sms.tsv": 2, "sms-1_mms.tsv": 1, "sms-2_sms.tsv": 3, "sms-2_mms.tsv": 1,
+		"calls_calls.tsv": 2}
+	for name, rows := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := strings.Count(string(b), "\n"); got != rows {
+			t.Errorf("%s: got %d rows, want %d", name, got, rows)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != len(want) {
+		t.Errorf("got %d files (%v), want %d", len(entries), err, len(want))
+	}
+}
+
+func TestJSONRecordWriterBeginBackup(t *testing.T) {
+	dir := t.TempDir()
+	w := newJSONRecordWriter(dir, newOutputConfig(nil))
+	for _, source := range []string{"sms-1.xml", "sms-2.xml"} {
+		m := &Messages{SMS: []SMS{{Address: "5551212", Body: source}}}
+		if err := WriteBackup(w, source, &Backup{Messages: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{"sms-1", "sms-2"} {
+		b, err := os.ReadFile(filepath.Join(dir, source+"_sms.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), source+".xml") {
+			t.Errorf("%s_sms.json does not contain the SMS of %s.xml", source, source)
+		}
+		if _, err := os.Stat(filepath.Join(dir, source+"_mms.json")); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestWithSourceAttachmentPath(t *testing.T) {
+	part := &Part{ContentType: "image/jpeg", Name: "photo.jpg", Base64Data: "AA=="}
+	for _, tc := range []struct {
+		backupPath string
+		want       string
+	}{
+		{"", "images/photo.jpg_1-2.jpg"},
+		{"/backups/sms-20240101000000.xml", "images/sms-20240101000000_photo.jpg_1-2.jpg"},
+		{"sms-20240102000000.xml", "images/sms-20240102000000_photo.jpg_1-2.jpg"},
+	} {
+		cfg := newOutputConfig([]OutputOption{WithSource(tc.backupPath)})
+		if got := cfg.attachmentPath(part, 1, 2); got != tc.want {
+			t.Errorf("source %q: got %q, want %q", tc.backupPath, got, tc.want)
+		}
+	}
+}
+
+func TestAttachmentPathTraversal(t *testing.T) {
+	for _, tc := range []struct {
+		part Part
+		want string
+	}{
+		{Part{ContentType: "image/jpeg", Name: "../../../escaped.jpg"}, "images/sms_escaped.jpg_1-2.jpg"},
+		{Part{ContentType: "image/png", Name: "null", FileName: `..\..\win.png`}, "images/sms_win.png_1-2.png"},
+		{Part{ContentType: "image/gif", Name: "../.."}, "images/sms_1-2.gif"},
+		{Part{ContentType: "video/../../mp4", Name: "/"}, "attachments/sms_1-2.mp4"},
+		{Part{ContentType: "text/x-vcard", Name: "a:b?.vcf"}, "attachments/sms_a_b_.vcf_1-2.x-vcard"},
+	} {
+		tc.part.Base64Data = "AA=="
+		cfg := newOutputConfig([]OutputOption{WithSource("sms.xml")})
+		if got := cfg.attachmentPath(&tc.part, 1, 2); got != tc.want {
+			t.Errorf("%q, %q: got %q, want %q", tc.part.ContentType, tc.part.Name, got, tc.want)
+		}
+	}
+
+	// files are written within the attachment directories of the output directory
+	dir := filepath.Join(t.TempDir(), "out")
+	w := NewAttachmentWriter(dir, WithSource("sms.xml"))
+	mms := &MMS{Parts: []Part{{ContentType: "image/jpeg", Name: "../../../escaped.jpg", Base64Data: "AA=="}}}
+	if err := w.WriteMMS(0, mms); err != nil || w.Written != 1 {
+		t.Fatalf("got %d written, error %v, errors %v", w.Written, err, w.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "images", "sms_escaped.jpg_0-0.jpg")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEMLRecordWriterSources(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRecordWriter("eml", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"a/sms-1.xml", "b/sms-2.xml"} {
+		m := &Messages{SMS: []SMS{{Address: "5551212", Body: source}}}
+		if err := WriteBackup(w, source, &Backup{Messages: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sms-1_sms-0.eml", "sms-2_sms-0.eml"} {
+		if _, err := os.Stat(filepath.Join(dir, "eml", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
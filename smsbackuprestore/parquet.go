@@ -0,0 +1,372 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize is the approximate size in bytes of the row groups of Parquet output. Rows are buffered in memory
+// until a row group is full and then written out.
+const parquetRowGroupSize = 64 * 1024 * 1024
+
+type smsParquet struct {
+	Source            string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Index             int64  `parquet:"name=sms_index, type=INT64"`
+	Protocol          string `parquet:"name=protocol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address           string `parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NormalizedAddress string `parquet:"name=normalized_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type              string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Subject           string `parquet:"name=subject, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Body              string `parquet:"name=body, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ServiceCenter     string `parquet:"name=service_center, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Status            string `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Read              bool   `parquet:"name=read, type=BOOLEAN"`
+	Date              *int64 `parquet:"name=date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Locked            bool   `parquet:"name=locked, type=BOOLEAN"`
+	DateSent          *int64 `parquet:"name=date_sent, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	ReadableDate      string `parquet:"name=readable_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactName       string `parquet:"name=contact_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TypeOfAddress     string `parquet:"name=toa, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ServiceCenterTOA  string `parquet:"name=sc_toa, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubscriptionID    string `parquet:"name=sub_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ThreadID          string `parquet:"name=thread_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Seen              bool   `parquet:"name=seen, type=BOOLEAN"`
+	ErrorCode         string `parquet:"name=error_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SimSlot           string `parquet:"name=sim_slot, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SimIMSI           string `parquet:"name=sim_imsi, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTCOffset         string `parquet:"name=utc_offset, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OtherAttributes   string `parquet:"name=other_attributes, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// mmsPartParquet is a single part of an MMS message, along with the attributes of the message itself. The part columns
+// are null for a message without parts.
+type mmsPartParquet struct {
+	Source              string  `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MMSIndex            int64   `parquet:"name=mms_index, type=INT64"`
+	PartIndex           *int64  `parquet:"name=part_index, type=INT64, repetitiontype=OPTIONAL"`
+	Direction           string  `parquet:"name=direction, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TextOnly            bool    `parquet:"name=text_only, type=BOOLEAN"`
+	Read                bool    `parquet:"name=read, type=BOOLEAN"`
+	Date                *int64  `parquet:"name=date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Locked              bool    `parquet:"name=locked, type=BOOLEAN"`
+	DateSent            *int64  `parquet:"name=date_sent, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	ReadableDate        string  `parquet:"name=readable_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactName         string  `parquet:"name=contact_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Seen                bool    `parquet:"name=seen, type=BOOLEAN"`
+	FromAddress         string  `parquet:"name=from_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Address             string  `parquet:"name=address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sender              string  `parquet:"name=sender, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Recipients          string  `parquet:"name=recipients, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageClassifier   string  `parquet:"name=m_cls, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageSize         string  `parquet:"name=m_size, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageBox          string  `parquet:"name=msg_box, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageType         string  `parquet:"name=m_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MessageID           string  `parquet:"name=m_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Subject             string  `parquet:"name=sub, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubjectCharset      string  `parquet:"name=sub_cs, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContentType         string  `parquet:"name=ct_t, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContentLocation     string  `parquet:"name=ct_l, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TransactionID       string  `parquet:"name=tr_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeliveryReport      string  `parquet:"name=d_rpt, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ReadReport          string  `parquet:"name=rr, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ReadStatus          string  `parquet:"name=read_status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Expiry              string  `parquet:"name=exp, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Priority            string  `parquet:"name=pri, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ResponseStatus      string  `parquet:"name=resp_st, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubscriptionID      string  `parquet:"name=sub_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTCOffset           string  `parquet:"name=utc_offset, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OtherAttributes     string  `parquet:"name=other_attributes, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PartSequence        *string `parquet:"name=part_seq, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartContentType     *string `parquet:"name=part_ct, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartName            *string `parquet:"name=part_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartFileName        *string `parquet:"name=part_fn, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartContentDisplay  *string `parquet:"name=part_cd, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartText            *string `parquet:"name=part_text, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartCharset         *string `parquet:"name=part_chset, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartContentID       *string `parquet:"name=part_cid, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartContentLocation *string `parquet:"name=part_cl, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartDataSize        *int64  `parquet:"name=part_data_size, type=INT64, repetitiontype=OPTIONAL"`
+	PartAttachment      *string `parquet:"name=part_attachment, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PartOtherAttributes *string `parquet:"name=part_other_attributes, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+type callParquet struct {
+	Source                    string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Index                     int64  `parquet:"name=call_index, type=INT64"`
+	Number                    string `parquet:"name=number, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NormalizedNumber          string `parquet:"name=normalized_number, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Duration                  int64  `parquet:"name=duration, type=INT64"`
+	Date                      *int64 `parquet:"name=date, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Type                      string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ReadableDate              string `parquet:"name=readable_date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactName               string `parquet:"name=contact_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Presentation              string `parquet:"name=presentation, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubscriptionID            string `parquet:"name=subscription_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubscriptionComponentName string `parquet:"name=subscription_component_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PostDialDigits            string `parquet:"name=post_dial_digits, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Features                  string `parquet:"name=features, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UTCOffset                 string `parquet:"name=utc_offset, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OtherAttributes           string `parquet:"name=other_attributes, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// parquetTimestamp converts timestamp to milliseconds since the Unix epoch, or nil if it is not a valid timestamp.
+func parquetTimestamp(timestamp AndroidTS) *int64 {
+	t := timestamp.Time()
+	if t.IsZero() {
+		return nil
+	}
+	millis := t.UnixMilli()
+	return &millis
+}
+
+// parquetString returns a pointer to s, for optional columns.
+func parquetString(s string) *string {
+	return &s
+}
+
+// parquetInt returns a pointer to i, for optional columns.
+func parquetInt(i int) *int64 {
+	i64 := int64(i)
+	return &i64
+}
+
+// parquetFile is a Parquet file being written one row at a time.
+type parquetFile struct {
+	file   *os.File
+	writer *writer.ParquetWriter
+}
+
+// ParquetExport writes parsed records to Parquet files named "sms.parquet", "mms_parts.parquet", and "calls.parquet",
+// with timestamps as milliseconds since the Unix epoch, enumerated values as their decoded names, and flags as
+// booleans. Records are written out in row groups as they are added, so memory use does not grow with the number of
+// records. Each file is created when the first record of its type is added, and any number of backups may be added
+// before the export is closed, with the source of each row (e.g. the path of the backup file) in its source column.
+// ParquetExport is the RecordWriter of the parquet format.
+type ParquetExport struct {
+	outputDir string
+	cfg       *outputConfig
+	source    string // source of the current backup
+	sms       *parquetFile
+	mms       *parquetFile
+	calls     *parquetFile
+}
+
+// NewParquetExport returns a ParquetExport writing files to outputDir according to opts.
+func NewParquetExport(outputDir string, opts ...OutputOption) *ParquetExport {
+	return &ParquetExport{outputDir: outputDir, cfg: newOutputConfig(opts)}
+}
+
+// open creates the named Parquet file with the schema of the given row type, if not already created.
+func (p *ParquetExport) open(f **parquetFile, fileName string, row interface{}) error {
+	if *f != nil {
+		return nil
+	}
+
+	file, err := os.Create(filepath.Join(p.outputDir, fileName))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", fileName, err)
+	}
+	pw, err := writer.NewParquetWriterFromWriter(file, row, 4)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Unable to create Parquet writer: %s\n%q", fileName, err)
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	*f = &parquetFile{file: file, writer: pw}
+	return nil
+}
+
+// WriteSMS writes the SMS with the given index to "sms.parquet".
+func (p *ParquetExport) WriteSMS(i int, sms *SMS) error {
+	if err := p.open(&p.sms, "sms.parquet", new(smsParquet)); err != nil {
+		return err
+	}
+	return p.sms.writer.Write(&smsParquet{
+		Source:            p.source,
+		Index:             int64(i),
+		Protocol:          sms.Protocol,
+		Address:           string(sms.Address),
+		NormalizedAddress: sms.Address.String(),
+		Type:              sms.Type.String(),
+		Subject:           sms.Subject,
+		Body:              sms.Body,
+		ServiceCenter:     string(sms.ServiceCenter),
+		Status:            sms.Status.String(),
+		Read:              sms.Read == 1,
+		Date:              parquetTimestamp(sms.Date),
+		Locked:            sms.Locked == 1,
+		DateSent:          parquetTimestamp(sms.DateSent),
+		ReadableDate:      sms.ReadableDate,
+		ContactName:       sms.ContactName,
+		TypeOfAddress:     sms.TypeOfAddress,
+		ServiceCenterTOA:  sms.ServiceCenterTOA,
+		SubscriptionID:    sms.SubscriptionID,
+		ThreadID:          sms.ThreadID,
+		Seen:              sms.Seen == 1,
+		ErrorCode:         sms.ErrorCode,
+		SimSlot:           sms.SimSlot,
+		SimIMSI:           sms.SimIMSI,
+		UTCOffset:         formatRecordOffset(sms.UTCOffset()),
+		OtherAttributes:   FormatAttributes(sms.OtherAttributes),
+	})
+}
+
+// WriteMMS writes the parts of the MMS with the given index to "mms_parts.parquet", one row per part, or a single row
+// with null part columns if it has no parts. Rather than including base64 data, parts such as images reference the
+// file written by DecodeAttachments.
+func (p *ParquetExport) WriteMMS(mmsIndex int, mms *MMS) error {
+	if err := p.open(&p.mms, "mms_parts.parquet", new(mmsPartParquet)); err != nil {
+		return err
+	}
+
+	row := mmsPartParquet{
+		Source:            p.source,
+		MMSIndex:          int64(mmsIndex),
+		Direction:         mms.Direction().String(),
+		TextOnly:          mms.TextOnly == 1,
+		Read:              mms.Read == 1,
+		Date:              parquetTimestamp(mms.Date),
+		Locked:            mms.Locked == 1,
+		DateSent:          parquetTimestamp(mms.DateSent),
+		ReadableDate:      mms.ReadableDate,
+		ContactName:       mms.ContactName,
+		Seen:              mms.Seen == 1,
+		FromAddress:       string(mms.FromAddress),
+		Address:           string(mms.Address),
+		Sender:            mms.Sender().String(),
+		Recipients:        recipientList(mms),
+		MessageClassifier: mms.MessageClassifier,
+		MessageSize:       mms.MessageSize,
+		MessageBox:        mms.MessageBox.String(),
+		MessageType:       mms.MessageType.String(),
+		MessageID:         mms.MessageID,
+		Subject:           mms.Subject,
+		SubjectCharset:    mms.SubjectCharset.String(),
+		ContentType:       mms.ContentType,
+		ContentLocation:   mms.ContentLocation,
+		TransactionID:     mms.TransactionID,
+		DeliveryReport:    mms.DeliveryReport.String(),
+		ReadReport:        mms.ReadReport.String(),
+		ReadStatus:        mms.ReadStatus.String(),
+		Expiry:            mms.Expiry,
+		Priority:          mms.Priority.String(),
+		ResponseStatus:    mms.ResponseStatus.String(),
+		SubscriptionID:    mms.SubscriptionID,
+		UTCOffset:         formatRecordOffset(mms.UTCOffset()),
+		OtherAttributes:   FormatAttributes(mms.OtherAttributes),
+	}
+	if len(mms.Parts) == 0 {
+		return p.mms.writer.Write(&row)
+	}
+
+	for partIndex, part := range mms.Parts {
+		partRow := row
+		partRow.PartIndex = parquetInt(partIndex)
+		partRow.PartSequence = parquetString(part.Sequence)
+		partRow.PartContentType = parquetString(part.ContentType)
+		partRow.PartName = parquetString(part.Name)
+		partRow.PartFileName = parquetString(part.FileName)
+		partRow.PartContentDisplay = parquetString(part.ContentDisplay)
+		partRow.PartText = parquetString(part.Text)
+		partRow.PartCharset = parquetString(part.Charset.String())
+		partRow.PartContentID = parquetString(part.ContentID)
+		partRow.PartContentLocation = parquetString(part.ContentLocation)
+		partRow.PartDataSize = parquetInt(base64DecodedLen(part.Base64Data))
+		partRow.PartAttachment = parquetString(p.cfg.attachmentPath(&mms.Parts[partIndex], mmsIndex, partIndex))
+		partRow.PartOtherAttributes = parquetString(FormatAttributes(part.OtherAttributes))
+		if err := p.mms.writer.Write(&partRow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCall writes the call with the given index to "calls.parquet".
+func (p *ParquetExport) WriteCall(i int, call *Call) error {
+	if err := p.open(&p.calls, "calls.parquet", new(callParquet)); err != nil {
+		return err
+	}
+	return p.calls.writer.Write(&callParquet{
+		Source:                    p.source,
+		Index:                     int64(i),
+		Number:                    string(call.Number),
+		NormalizedNumber:          call.Number.String(),
+		Duration:                  int64(call.Duration),
+		Date:                      parquetTimestamp(call.Date),
+		Type:                      call.Type.String(),
+		ReadableDate:              call.ReadableDate,
+		ContactName:               call.ContactName,
+		Presentation:              call.Presentation.String(),
+		SubscriptionID:            call.SubscriptionID,
+		SubscriptionComponentName: call.SubscriptionComponentName,
+		PostDialDigits:            call.PostDialDigits,
+		Features:                  call.Features.String(),
+		UTCOffset:                 formatRecordOffset(call.UTCOffset()),
+		OtherAttributes:           FormatAttributes(call.OtherAttributes),
+	})
+}
+
+// BeginBackup starts the records of another backup, whose rows have the given source (e.g. the path of the backup
+// file) and whose attachment paths are prefixed as with WithSource(source).
+func (p *ParquetExport) BeginBackup(source string, h *Header) error {
+	p.source = source
+	p.cfg.source = sourceName(source)
+	return nil
+}
+
+// AddMessages writes all SMS and MMS messages of the backup read from source (e.g. the path of the backup file).
+func (p *ParquetExport) AddMessages(m *Messages, source string) error {
+	return WriteBackup(p, source, &Backup{Messages: m})
+}
+
+// AddCalls writes all calls of the backup read from source (e.g. the path of the backup file).
+func (p *ParquetExport) AddCalls(c *Calls, source string) error {
+	return WriteBackup(p, source, &Backup{Calls: c})
+}
+
+// Close writes the final row group and footer of each file, returning the first error encountered.
+func (p *ParquetExport) Close() error {
+	var firstErr error
+	for _, f := range []*parquetFile{p.sms, p.mms, p.calls} {
+		if f == nil {
+			continue
+		}
+		if err := f.writer.WriteStop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,127 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// readParquet reads all rows of the Parquet file at path into rows, a pointer to a slice of the row type.
+func readParquet(t *testing.T, path string, row, rows interface{}) {
+	t.Helper()
+	f, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pr, err := reader.NewParquetReader(f, row, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	v := reflect.ValueOf(rows).Elem()
+	v.Set(reflect.MakeSlice(v.Type(), int(pr.GetNumRows()), int(pr.GetNumRows())))
+	if err := pr.Read(rows); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParquetExport(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noParts := &Messages{MMS: []MMS{{Address: "5551212", Date: "1704067200000"}}}
+	dir := t.TempDir()
+	p := NewParquetExport(dir)
+	if err := p.AddMessages(m, "sms-1.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddMessages(noParts, "sms-2.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddCalls(c, "calls.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sms []smsParquet
+	readParquet(t, filepath.Join(dir, "sms.parquet"), new(smsParquet), &sms)
+	if len(sms) != 2 {
+		t.Fatalf("got %d SMS rows, want 2", len(sms))
+	}
+	for i, row := range sms {
+		if row.Source != "sms-1.xml" || row.Index != int64(i) {
+			t.Errorf("SMS row %d: got source %q and index %d", i, row.Source, row.Index)
+		}
+	}
+	if sms[0].Date == nil || *sms[0].Date != 1704060000123 || sms[0].DateSent != nil {
+		t.Errorf("got SMS dates %v and %v, want 1704060000123 and null", sms[0].Date, sms[0].DateSent)
+	}
+
+	var parts []mmsPartParquet
+	readParquet(t, filepath.Join(dir, "mms_parts.parquet"), new(mmsPartParquet), &parts)
+	if len(parts) != 4 {
+		t.Fatalf("got %d MMS part rows, want 4", len(parts))
+	}
+	for i, row := range parts[:3] {
+		if row.Source != "sms-1.xml" || row.MMSIndex != 0 || row.PartIndex == nil || *row.PartIndex != int64(i) {
+			t.Errorf("part row %d: got source %q, MMS index %d and part index %v", i, row.Source, row.MMSIndex,
+				row.PartIndex)
+		}
+	}
+	if image := parts[2]; image.PartDataSize == nil || *image.PartDataSize != 8 || image.PartAttachment == nil ||
+		*image.PartAttachment != filepath.Join("images", "sms-1_image.png_0-2.png") {
+		t.Errorf("got image part data size %v and attachment %v", image.PartDataSize, image.PartAttachment)
+	}
+	empty := parts[3]
+	if empty.Source != "sms-2.xml" || empty.MMSIndex != 0 || empty.Address != "5551212" {
+		t.Errorf("got MMS without parts from %q with index %d and address %q", empty.Source, empty.MMSIndex,
+			empty.Address)
+	}
+	if empty.PartIndex != nil || empty.PartContentType != nil || empty.PartDataSize != nil ||
+		empty.PartAttachment != nil {
+		t.Errorf("got part columns %v, %v, %v and %v for an MMS without parts, want null", empty.PartIndex,
+			empty.PartContentType, empty.PartDataSize, empty.PartAttachment)
+	}
+
+	var calls []callParquet
+	readParquet(t, filepath.Join(dir, "calls.parquet"), new(callParquet), &calls)
+	if len(calls) != 2 || calls[0].Source != "calls.xml" || calls[1].Source != "calls.xml" {
+		t.Errorf("got calls %+v, want 2 calls from calls.xml", calls)
+	}
+}
@@ -0,0 +1,436 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Record is a single record yielded by Parser.Next: one of *SMS, *MMS, or *Call.
+type Record interface{}
+
+// Header contains the attributes of the root element (<smses> or <calls>) of a backup file.
+type Header struct {
+	XMLName         xml.Name
+	Count           string
+	BackupSet       string
+	BackupDate      AndroidTS
+	OtherAttributes []xml.Attr // attributes not recognized by this parser, e.g. type="full"
+}
+
+// Parser reads SMS, MMS, and Call records one at a time from SMS Backup & Restore XML output.
+//
+// Unlike xml.Unmarshal into a Messages or Calls value, a Parser never holds more than a single record in memory, so
+// it can be used on backup files that are many gigabytes in size.
+type Parser struct {
+	decoder *xml.Decoder
+	header  *Header
+	done    bool // the end of the root element has been reached
+}
+
+// NewParser returns a Parser reading XML from r, which is repaired as it is read by NewSanitizingReader.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{decoder: xml.NewDecoder(NewSanitizingReader(r))}
+}
+
+// Header returns the attributes of the root element, reading forward to it if necessary.
+func (p *Parser) Header() (*Header, error) {
+	if p.header != nil {
+		return p.header, nil
+	}
+
+	for {
+		token, err := p.decoder.Token()
+		if err == io.EOF {
+			return nil, p.parseError(fmt.Errorf("no root element found: %w", io.ErrUnexpectedEOF))
+		} else if err != nil {
+			return nil, p.parseError(err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			h := &Header{XMLName: start.Name}
+			for _, attr := range start.Attr {
+				switch attr.Name.Local {
+				case "count":
+					h.Count = attr.Value
+				case "backup_set":
+					h.BackupSet = attr.Value
+				case "backup_date":
+					h.BackupDate = AndroidTS(attr.Value)
+				default:
+					h.OtherAttributes = append(h.OtherAttributes, attr)
+				}
+			}
+			p.header = h
+			return h, nil
+		}
+	}
+}
+
+// Next returns the next *SMS, *MMS, or *Call record in the backup. It returns io.EOF once the end of the root
+// element is reached, and on every call thereafter. Elements other than sms, mms, and call are skipped.
+func (p *Parser) Next() (Record, error) {
+	if _, err := p.Header(); err != nil {
+		return nil, err
+	}
+	if p.done {
+		return nil, io.EOF
+	}
+
+	for {
+		token, err := p.decoder.Token()
+		if err == io.EOF {
+			return nil, p.parseError(io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return nil, p.parseError(err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var record Record
+			switch t.Name.Local {
+			case "sms":
+				record = new(SMS)
+			case "mms":
+				record = new(MMS)
+			case "call":
+				record = new(Call)
+			default:
+				if err := p.decoder.Skip(); err != nil {
+					return nil, p.parseError(err)
+				}
+				continue
+			}
+
+			if err := p.decoder.DecodeElement(record, &t); err != nil {
+				return nil, p.parseError(err)
+			}
+			return record, nil
+		case xml.EndElement:
+			// the only end element seen at this depth is the end of the root element
+			p.done = true
+			return nil, io.EOF
+		}
+	}
+}
+
+// parseError wraps err in a *ParseError recording the current line of the decoder.
+func (p *Parser) parseError(err error) error {
+	line, _ := p.decoder.InputPos()
+	return &ParseError{Line: line, Err: err}
+}
+
+// ErrUnknownBackupType is returned by Open when a file cannot be identified as either an SMS or a calls backup.
+var ErrUnknownBackupType = errors.New("unknown backup type")
+
+// ParseError records an error encountered while decoding the XML of a backup file and the line on which it occurred.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("error parsing backup XML at line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// BackupTypeError is returned when the root element of a backup file does not match the type of backup expected,
+// e.g. when a calls backup is passed to ParseMessages.
+type BackupTypeError struct {
+	Expected string
+	Found    string
+}
+
+func (e *BackupTypeError) Error() string {
+	return fmt.Sprintf("expected <%s> root element but found <%s>", e.Expected, e.Found)
+}
+
+// Backup holds the result of Open. Exactly one of Messages and Calls is non-nil.
+type Backup struct {
+	Messages *Messages
+	Calls    *Calls
+}
+
+// Header method for Backup type returns the attributes of the root element of the backup.
+func (b *Backup) Header() *Header {
+	if m := b.Messages; m != nil {
+		return &Header{XMLName: xml.Name{Local: "smses"}, Count: m.Count, BackupSet: m.BackupSet, BackupDate: m.BackupDate,
+			OtherAttributes: m.OtherAttributes}
+	}
+	c := b.Calls
+	return &Header{XMLName: xml.Name{Local: "calls"}, Count: c.Count, BackupSet: c.BackupSet, BackupDate: c.BackupDate,
+		OtherAttributes: c.OtherAttributes}
+}
+
+// ParseMessages parses an SMS backup (sms-*.xml) from r, repairing the malformed XML the app writes as it is read
+// (see NewSanitizingReader).
+func ParseMessages(r io.Reader) (*Messages, error) {
+	p := NewParser(r)
+	h, err := p.Header()
+	if err != nil {
+		return nil, err
+	}
+	if h.XMLName.Local != "smses" {
+		return nil, &BackupTypeError{Expected: "smses", Found: h.XMLName.Local}
+	}
+
+	m := &Messages{
+		XMLName:         h.XMLName,
+		Count:           h.Count,
+		BackupSet:       h.BackupSet,
+		BackupDate:      h.BackupDate,
+		OtherAttributes: h.OtherAttributes,
+	}
+	for {
+		record, err := p.Next()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch rec := record.(type) {
+		case *SMS:
+			m.SMS = append(m.SMS, *rec)
+		case *MMS:
+			m.MMS = append(m.MMS, *rec)
+		}
+	}
+}
+
+// ParseCalls parses a calls backup (calls-*.xml) from r, repairing the malformed XML the app writes as it is read
+// (see NewSanitizingReader).
+func ParseCalls(r io.Reader) (*Calls, error) {
+	p := NewParser(r)
+	h, err := p.Header()
+	if err != nil {
+		return nil, err
+	}
+	if h.XMLName.Local != "calls" {
+		return nil, &BackupTypeError{Expected: "calls", Found: h.XMLName.Local}
+	}
+
+	c := &Calls{
+		XMLName:         h.XMLName,
+		Count:           h.Count,
+		BackupSet:       h.BackupSet,
+		BackupDate:      h.BackupDate,
+		OtherAttributes: h.OtherAttributes,
+	}
+	for {
+		record, err := p.Next()
+		if err == io.EOF {
+			return c, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if call, ok := record.(*Call); ok {
+			c.Calls = append(c.Calls, *call)
+		}
+	}
+}
+
+// BackupType identifies whether a backup file contains SMS/MMS messages or calls.
+type BackupType int
+
+const (
+	UnknownBackup BackupType = iota
+	MessagesBackup
+	CallsBackup
+)
+
+// String method for BackupType type converts the backup type to the name of its root element.
+func (bt BackupType) String() string {
+	switch bt {
+	case MessagesBackup:
+		return "smses"
+	case CallsBackup:
+		return "calls"
+	default:
+		return "unknown"
+	}
+}
+
+// BackupType method for Header type determines the type of backup from the name of its root element (<smses> or
+// <calls>).
+func (h *Header) BackupType() BackupType {
+	switch h.XMLName.Local {
+	case "smses":
+		return MessagesBackup
+	case "calls":
+		return CallsBackup
+	default:
+		return UnknownBackup
+	}
+}
+
+// DetectBackupType reads from r up to the root element of the backup and determines the type of backup from its
+// name (<smses> or <calls>).
+func DetectBackupType(r io.Reader) (BackupType, error) {
+	h, err := NewParser(r).Header()
+	if err != nil {
+		return UnknownBackup, err
+	}
+
+	if backupType := h.BackupType(); backupType != UnknownBackup {
+		return backupType, nil
+	}
+	return UnknownBackup, fmt.Errorf("<%s> root element: %w", h.XMLName.Local, ErrUnknownBackupType)
+}
+
+// backupTypeFromFileName guesses the type of backup from the default naming convention of the SMS Backup & Restore
+// app (sms-*.xml or calls-*.xml), ignoring case.
+func backupTypeFromFileName(fileName string) BackupType {
+	fileName = strings.ToLower(fileName)
+	if filepath.Ext(fileName) != ".xml" {
+		return UnknownBackup
+	}
+
+	if strings.HasPrefix(fileName, "sms-") {
+		return MessagesBackup
+	} else if strings.HasPrefix(fileName, "calls-") {
+		return CallsBackup
+	}
+	return UnknownBackup
+}
+
+// maxPrologSize is how far into a backup file Open looks for the root element when the start of the file cannot be
+// parsed.
+const maxPrologSize = 64 * 1024
+
+// seekToRoot positions f at the start tag of the named root element, skipping a garbled XML declaration or other
+// content preceding it within the first maxPrologSize bytes of f.
+func seekToRoot(f io.ReadSeeker, name string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, maxPrologSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	buf = buf[:n]
+
+	tag := []byte("<" + name)
+	for offset := 0; ; offset++ {
+		i := bytes.Index(buf[offset:], tag)
+		if i < 0 {
+			return fmt.Errorf("no <%s> root element found: %w", name, ErrUnknownBackupType)
+		}
+		offset += i
+
+		// the name must not merely be the start of a longer name
+		if end := offset + len(tag); end < len(buf) && strings.IndexByte(" \t\r\n/>", buf[end]) >= 0 {
+			_, err := f.Seek(int64(offset), io.SeekStart)
+			return err
+		}
+	}
+}
+
+// openBackupFile opens the backup file at path and determines its type, positioning the file at the start of the
+// XML or, if the start of the file cannot be parsed, at the root element its file name calls for (see Open).
+func openBackupFile(path string) (*os.File, BackupType, error) {
+	fileName := filepath.Base(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, UnknownBackup, err
+	}
+
+	backupType, detectErr := DetectBackupType(f)
+	if detectErr == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	} else if backupType = backupTypeFromFileName(fileName); backupType != UnknownBackup {
+		err = seekToRoot(f, backupType.String())
+	} else if errors.Is(detectErr, ErrUnknownBackupType) {
+		err = detectErr
+	} else {
+		err = fmt.Errorf("%w: %w", ErrUnknownBackupType, detectErr)
+	}
+	if err != nil {
+		f.Close()
+		return nil, UnknownBackup, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return f, backupType, nil
+}
+
+// FileParser is a Parser reading a backup file opened by OpenParser, which must be closed once parsed.
+type FileParser struct {
+	*Parser
+	file *os.File
+}
+
+// OpenParser opens the backup file at path for reading its records one at a time, determining its type as Open does.
+// The Header of the returned parser names the root element of a messages or calls backup.
+func OpenParser(path string) (*FileParser, error) {
+	f, _, err := openBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileParser{Parser: NewParser(f), file: f}, nil
+}
+
+// Close closes the backup file.
+func (p *FileParser) Close() error {
+	return p.file.Close()
+}
+
+// Open parses the backup file at path. The type of backup is determined from the root element of the XML (<smses> or
+// <calls>). If the start of the file cannot be parsed, e.g. because the XML declaration is garbled, the file name
+// (sms-*.xml or calls-*.xml) determines which root element is expected, and parsing starts from that element.
+//
+// Open holds every record of the backup in memory; use OpenParser to read records one at a time instead.
+func Open(path string) (*Backup, error) {
+	fileName := filepath.Base(path)
+
+	f, backupType, err := openBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := new(Backup)
+	switch backupType {
+	case MessagesBackup:
+		b.Messages, err = ParseMessages(f)
+	case CallsBackup:
+		b.Calls, err = ParseCalls(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return b, nil
+}
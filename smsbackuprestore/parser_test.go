@@ -0,0 +1,262 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMessagesXML = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>
+<!--File Created By SMS Backup & Restore v10.05.602 on 01/01/2024 00:00:00-->
+<smses count="3" backup_set="0b6b3b5e-6f4a-4d5c-9f6e-2c0a3f1f7f00" backup_date="1704067200000" type="full">
+  <sms protocol="0" address="+1 (312) 555-1212" date="1704060000123" type="1" subject="null" body="Hi &#55357;&#56832;" toa="null" sc_toa="null" service_center="null" read="1" status="-1" locked="0" date_sent="1704059999000" sub_id="1" readable_date="Dec 31, 2023 4:00:00 PM" contact_name="Alice" />
+  <mms date="1704060100000" rr="129" sub="null" ct_t="application/vnd.wap.multipart.related" read_status="null" seen="1" msg_box="1" address="13125551212" sub_cs="null" resp_st="null" retr_st="null" d_tm="null" text_only="0" exp="null" locked="0" m_id="mid" st="null" retr_txt_cs="null" retr_txt="null" creator="com.google.android.apps.messaging" date_sent="0" read="1" m_size="1024" rpt_a="null" ct_cls="null" pri="129" sub_id="1" tr_id="tid" resp_txt="null" ct_l="null" m_cls="personal" d_rpt="129" v="18" _id="12" m_type="132" readable_date="Dec 31, 2023 4:01:40 PM" contact_name="Alice">
+    <parts>
+      <part seq="-1" ct="application/smil" name="null" chset="null" cd="null" fn="null" cid="&lt;smil&gt;" cl="smil.xml" ctt_s="null" ctt_t="null" text="&lt;smil /&gt;" />
+      <part seq="0" ct="image/png" name="image.png" chset="null" cd="null" fn="null" cid="&lt;image&gt;" cl="image.png" ctt_s="null" ctt_t="null" text="null" data="iVBORw0KGgo=" />
+    </parts>
+    <addrs>
+      <addr address="13125551212" type="137" charset="106" />
+      <addr address="insert-address-token" type="151" charset="106" />
+    </addrs>
+  </mms>
+  <sms protocol="0" address="13125553434" date="1704060200000" type="2" subject="null" body="Bye" toa="null" sc_toa="null" service_center="null" read="1" status="-1" locked="0" date_sent="0" sub_id="1" readable_date="Dec 31, 2023 4:03:20 PM" contact_name="(Unknown)" />
+</smses>
+`
+
+const testCallsXML = `<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>
+<calls count="2" backup_set="c1a2b3" backup_date="1704067200000" type="full">
+  <call number="+13125551212" duration="65" date="1704060000000" type="1" presentation="1" subscription_id="1" post_dial_digits="" subscription_component_name="com.android.phone/com.android.services.telephony.TelephonyConnectionService" readable_date="Dec 31, 2023 4:00:00 PM" contact_name="Alice" features="5" />
+  <call number="13125553434" duration="0" date="1704060100000" type="3" presentation="null" readable_date="Dec 31, 2023 4:01:40 PM" contact_name="(Unknown)" features="null" />
+</calls>
+`
+
+// readAll returns every record from p until io.EOF.
+func readAll(t *testing.T, p *Parser) []Record {
+	t.Helper()
+	var records []Record
+	for {
+		record, err := p.Next()
+		if err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatalf("Next returned error: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestParserMessages(t *testing.T) {
+	p := NewParser(strings.NewReader(testMessagesXML))
+
+	h, err := p.Header()
+	if err != nil {
+		t.Fatalf("Header returned error: %v", err)
+	}
+	if h.XMLName.Local != "smses" || h.Count != "3" || h.BackupSet != "0b6b3b5e-6f4a-4d5c-9f6e-2c0a3f1f7f00" ||
+		h.BackupDate != "1704067200000" {
+		t.Errorf("unexpected header: %+v", h)
+	}
+
+	records := readAll(t, p)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+
+	sms, ok := records[0].(*SMS)
+	if !ok {
+		t.Fatalf("record 0 is %T, want *SMS", records[0])
+	}
+	if sms.Address != "+1 (312) 555-1212" || sms.Type != 1 || sms.Body != "Hi \U0001F600" || sms.Date != "1704060000123" ||
+		sms.Status != -1 || sms.Read != 1 || sms.ContactName != "Alice" {
+		t.Errorf("unexpected SMS: %+v", sms)
+	}
+
+	mms, ok := records[1].(*MMS)
+	if !ok {
+		t.Fatalf("record 1 is %T, want *MMS", records[1])
+	}
+	if mms.MessageBox != 1 || mms.MessageType != 132 || len(mms.Parts) != 2 || len(mms.Addresses) != 2 {
+		t.Errorf("unexpected MMS: %+v", mms)
+	}
+	if part := mms.Parts[1]; part.ContentType != "image/png" || part.Base64Data != "iVBORw0KGgo=" || part.Sequence != "0" {
+		t.Errorf("unexpected MMS part: %+v", part)
+	}
+	if sender := mms.Sender(); sender != "13125551212" {
+		t.Errorf("got sender %q, want 13125551212", sender)
+	}
+	if len(mms.OtherAttributes) == 0 {
+		t.Error("unrecognized MMS attributes were not kept")
+	}
+
+	if sms, ok := records[2].(*SMS); !ok || sms.Body != "Bye" {
+		t.Errorf("unexpected record 2: %+v", records[2])
+	}
+
+	// io.EOF is returned again once the end of the root element is reached
+	if _, err := p.Next(); err != io.EOF {
+		t.Errorf("got error %v after last record, want io.EOF", err)
+	}
+}
+
+func TestParserCalls(t *testing.T) {
+	p := NewParser(strings.NewReader(testCallsXML))
+
+	h, err := p.Header()
+	if err != nil {
+		t.Fatalf("Header returned error: %v", err)
+	}
+	if h.XMLName.Local != "calls" || h.Count != "2" || h.BackupSet != "c1a2b3" || h.BackupDate != "1704067200000" {
+		t.Errorf("unexpected header: %+v", h)
+	}
+
+	records := readAll(t, p)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	call, ok := records[0].(*Call)
+	if !ok {
+		t.Fatalf("record 0 is %T, want *Call", records[0])
+	}
+	if call.Number != "+13125551212" || call.Duration != 65 || call.Type != 1 || call.Presentation != 1 ||
+		call.Features != 5 || call.Date != "1704060000000" {
+		t.Errorf("unexpected call: %+v", call)
+	}
+	if call := records[1].(*Call); call.Presentation != 0 || call.Features != 0 || call.Type != 3 {
+		t.Errorf("unexpected call with null attributes: %+v", call)
+	}
+}
+
+func TestParserNextWithoutHeader(t *testing.T) {
+	// Next reads the header itself if Header was not called
+	records := readAll(t, NewParser(strings.NewReader(testCallsXML)))
+	if len(records) != 2 {
+		t.Errorf("got %d records, want 2", len(records))
+	}
+}
+
+func TestParserSkipsUnknownElements(t *testing.T) {
+	doc := `<smses count="1"><note><sms body="nested" /></note><sms body="top" /></smses>`
+	records := readAll(t, NewParser(strings.NewReader(doc)))
+	if len(records) != 1 || records[0].(*SMS).Body != "top" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func TestParserTruncated(t *testing.T) {
+	for _, truncateAt := range []string{`<sms protocol="0" address="13125553434"`, `<addrs>`, `Bye" toa="null"`} {
+		i := strings.Index(testMessagesXML, truncateAt)
+		p := NewParser(strings.NewReader(testMessagesXML[:i]))
+
+		var err error
+		for err == nil {
+			_, err = p.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("truncated before %q: got io.EOF, want error", truncateAt)
+			continue
+		}
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Line == 0 {
+			t.Errorf("truncated before %q: got %v, want *ParseError with line", truncateAt, err)
+		}
+	}
+
+	// a document without a root element
+	if _, err := NewParser(strings.NewReader(`<?xml version='1.0' ?>`)).Header(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v without root element, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	garbled := "<?xml version='1.0' encoding='UTF-8' standal\x00<<\n" + testMessagesXML[strings.Index(testMessagesXML, "<smses"):]
+
+	tests := []struct {
+		fileName string
+		content  string
+		messages bool
+		calls    bool
+	}{
+		{"sms-20240101000000.xml", testMessagesXML, true, false},
+		{"exhibit-14.xml", testCallsXML, false, true},
+		{"CALLS-20240101000000.XML", testCallsXML, false, true},
+		{"sms-20240102000000.xml", garbled, true, false},
+		{"exhibit-15.xml", garbled, false, false},
+		{"calls-20240102000000.xml", garbled, false, false},
+		{"sms-20240103000000.xml", `<?xml version='1.0' ?><contacts count="0"></contacts>`, false, false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.fileName)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := Open(path)
+		if !tt.messages && !tt.calls {
+			if !errors.Is(err, ErrUnknownBackupType) {
+				t.Errorf("%s: got error %v, want ErrUnknownBackupType", tt.fileName, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Open returned error: %v", tt.fileName, err)
+			continue
+		}
+		if tt.messages && (b.Messages == nil || len(b.Messages.SMS) != 2 || len(b.Messages.MMS) != 1) {
+			t.Errorf("%s: unexpected messages: %+v", tt.fileName, b)
+		}
+		if tt.calls && (b.Calls == nil || len(b.Calls.Calls) != 2) {
+			t.Errorf("%s: unexpected calls: %+v", tt.fileName, b)
+		}
+
+		// OpenParser reads the same records one at a time
+		p, err := OpenParser(path)
+		if err != nil {
+			t.Errorf("%s: OpenParser returned error: %v", tt.fileName, err)
+			continue
+		}
+		h, err := p.Header()
+		if err != nil {
+			t.Errorf("%s: Header returned error: %v", tt.fileName, err)
+		} else if want := b.Header().BackupType(); h.BackupType() != want {
+			t.Errorf("%s: got %v backup from OpenParser, want %v", tt.fileName, h.BackupType(), want)
+		}
+		want := 3 // 2 SMS and 1 MMS
+		if tt.calls {
+			want = 2
+		}
+		if records := readAll(t, p.Parser); len(records) != want {
+			t.Errorf("%s: got %d records from OpenParser, want %d", tt.fileName, len(records), want)
+		}
+		p.Close()
+	}
+}
@@ -0,0 +1,233 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxCharRefLen is the longest character reference (e.g. "&#0000128512;") the sanitizing reader will recognize.
+const maxCharRefLen = 16
+
+// replacementCharRef is written in place of lone UTF-16 surrogates.
+var replacementCharRef = []byte("&#65533;")
+
+// sanitizingReader fixes up the XML written by the SMS Backup & Restore app as it is read. See NewSanitizingReader.
+type sanitizingReader struct {
+	in  *bufio.Reader
+	out []byte // sanitized output not yet returned by Read, starting at pos
+	pos int
+	err error
+}
+
+// NewSanitizingReader returns an io.Reader that repairs the XML written by the SMS Backup & Restore app as bytes
+// stream through it, without holding the file in memory:
+//
+//   - character references to characters that are illegal in XML (e.g. &#0; and other control characters) are removed
+//   - UTF-16 surrogate pairs written as two character references, in decimal or hex and with any number of digits
+//     (e.g. &#55357;&#56832; or &#xD83D;&#xDE00;), are joined into a single reference to the emoji they encode
+//   - lone surrogates, whether written as character references or encoded directly as (CESU-8) bytes, are replaced
+//     with U+FFFD
+//   - raw control characters other than tab, newline, and carriage return are removed
+func NewSanitizingReader(r io.Reader) io.Reader {
+	return &sanitizingReader{in: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (s *sanitizingReader) Read(p []byte) (int, error) {
+	if s.pos == len(s.out) {
+		s.out, s.pos = s.out[:0], 0
+		for len(s.out) == 0 && s.err == nil {
+			s.err = s.fill()
+		}
+	}
+
+	n := copy(p, s.out[s.pos:])
+	s.pos += n
+	if s.pos < len(s.out) {
+		return n, nil
+	}
+	return n, s.err
+}
+
+// fill sanitizes the next chunk of input into s.out.
+func (s *sanitizingReader) fill() error {
+	buf, err := s.in.Peek(1)
+	if len(buf) == 0 {
+		return err
+	}
+	buf, _ = s.in.Peek(s.in.Buffered())
+
+	// copy everything up to the next byte needing attention in one go
+	i := 0
+	for i < len(buf) && !needsSanitizing(buf[i]) {
+		i++
+	}
+	if i > 0 {
+		s.out = append(s.out, buf[:i]...)
+		_, err = s.in.Discard(i)
+		return err
+	}
+
+	switch b := buf[0]; {
+	case b == '&':
+		return s.charRef()
+	case b == 0xED:
+		return s.rawSurrogate()
+	default:
+		// illegal control character
+		_, err = s.in.Discard(1)
+		return err
+	}
+}
+
+// charRef handles a '&' at the start of the input.
+func (s *sanitizingReader) charRef() error {
+	r, n := s.peekCharRef(0)
+	if n == 0 {
+		// not a character reference (e.g. &amp;)
+		s.out = append(s.out, '&')
+		_, err := s.in.Discard(1)
+		return err
+	}
+
+	switch {
+	case utf16.IsSurrogate(r) && r < 0xDC00:
+		// high surrogate, which must be followed by a low surrogate
+		low, lowLen := s.peekCharRef(n)
+		if lowLen > 0 && low >= 0xDC00 && low <= 0xDFFF {
+			s.out = append(s.out, "&#"...)
+			s.out = strconv.AppendInt(s.out, int64(utf16.DecodeRune(r, low)), 10)
+			s.out = append(s.out, ';')
+			n += lowLen
+		} else {
+			s.out = append(s.out, replacementCharRef...)
+		}
+	case utf16.IsSurrogate(r):
+		// low surrogate without a preceding high surrogate
+		s.out = append(s.out, replacementCharRef...)
+	case isXMLChar(r):
+		ref, _ := s.in.Peek(n)
+		s.out = append(s.out, ref...)
+	}
+
+	_, err := s.in.Discard(n)
+	return err
+}
+
+// peekCharRef looks for a numeric character reference at the given offset in the buffered input, returning the code
+// point it refers to and its length in bytes, or a length of 0 if there is none. Code points too large to be valid are
+// returned as utf8.MaxRune+1.
+func (s *sanitizingReader) peekCharRef(offset int) (rune, int) {
+	buf, _ := s.in.Peek(offset + maxCharRefLen)
+	if len(buf) <= offset {
+		return 0, 0
+	}
+	buf = buf[offset:]
+	if len(buf) < 4 || buf[0] != '&' || buf[1] != '#' {
+		return 0, 0
+	}
+
+	i, base := 2, 10
+	if buf[i] == 'x' || buf[i] == 'X' {
+		i, base = 3, 16
+	}
+	start := i
+	for i < len(buf) && isDigit(buf[i], base) {
+		i++
+	}
+	if i == start || i >= len(buf) || buf[i] != ';' {
+		return 0, 0
+	}
+
+	value, err := strconv.ParseInt(string(buf[start:i]), base, 32)
+	if err != nil || value > utf8.MaxRune {
+		value = utf8.MaxRune + 1
+	}
+	return rune(value), i + 1
+}
+
+// rawSurrogate handles a 0xED byte at the start of the input, which may begin a surrogate encoded directly as UTF-8
+// bytes (as Java's "modified UTF-8" does) rather than as a character reference.
+func (s *sanitizingReader) rawSurrogate() error {
+	r, n := s.peekRawSurrogate(0)
+	switch {
+	case n == 0:
+		// ordinary character in the range U+D000-U+D7FF (or invalid UTF-8, left for the XML decoder to report)
+		s.out = append(s.out, 0xED)
+		n = 1
+	case r < 0xDC00:
+		low, lowLen := s.peekRawSurrogate(n)
+		if lowLen > 0 && low >= 0xDC00 {
+			s.out = utf8.AppendRune(s.out, utf16.DecodeRune(r, low))
+			n += lowLen
+		} else {
+			s.out = utf8.AppendRune(s.out, utf8.RuneError)
+		}
+	default:
+		s.out = utf8.AppendRune(s.out, utf8.RuneError)
+	}
+
+	_, err := s.in.Discard(n)
+	return err
+}
+
+// peekRawSurrogate looks for a surrogate encoded as three UTF-8 bytes at the given offset in the buffered input,
+// returning it and its length in bytes, or a length of 0 if there is none.
+func (s *sanitizingReader) peekRawSurrogate(offset int) (rune, int) {
+	buf, _ := s.in.Peek(offset + 3)
+	if len(buf) < offset+3 {
+		return 0, 0
+	}
+	buf = buf[offset:]
+	if buf[0] != 0xED || buf[1] < 0xA0 || buf[1] > 0xBF || buf[2] < 0x80 || buf[2] > 0xBF {
+		return 0, 0
+	}
+	return rune(0xD000) | rune(buf[1]&0x3F)<<6 | rune(buf[2]&0x3F), 3
+}
+
+// needsSanitizing reports whether b may begin a sequence the sanitizing reader has to fix up.
+func needsSanitizing(b byte) bool {
+	return b == '&' || b == 0xED || (b < 0x20 && b != '\t' && b != '\n' && b != '\r')
+}
+
+// isXMLChar reports whether r may appear in an XML 1.0 document.
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= utf8.MaxRune)
+}
+
+// isDigit reports whether b is a digit in the given base (10 or 16).
+func isDigit(b byte, base int) bool {
+	if b >= '0' && b <= '9' {
+		return true
+	}
+	return base == 16 && ((b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F'))
+}
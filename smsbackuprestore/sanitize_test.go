@@ -0,0 +1,114 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSanitizingReader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", `<sms body="hello &amp; goodbye" />`, `<sms body="hello &amp; goodbye" />`},
+		{"decimal surrogate pair", "&#55357;&#56832;", "&#128512;"},
+		{"hex surrogate pair", "&#xD83D;&#xDE00;", "&#128512;"},
+		{"mixed case hex surrogate pair", "&#Xd83d;&#xdE00;", "&#128512;"},
+		{"zero-padded surrogate pair", "&#055357;&#0056832;", "&#128512;"},
+		{"decimal and hex surrogate pair", "&#55357;&#xDE00;", "&#128512;"},
+		{"consecutive surrogate pairs", "&#55357;&#56832;&#55357;&#56833;", "&#128512;&#128513;"},
+		{"lone high surrogate", "a&#55357;b", "a&#65533;b"},
+		{"lone high surrogate at end", "a&#55357;", "a&#65533;"},
+		{"high surrogate followed by non-surrogate", "&#55357;&#65;", "&#65533;&#65;"},
+		{"lone low surrogate", "a&#xDE00;b", "a&#65533;b"},
+		{"two high surrogates", "&#55357;&#55357;&#56832;", "&#65533;&#128512;"},
+		{"null character reference", "a&#0;b", "ab"},
+		{"hex null character reference", "a&#x0;b", "ab"},
+		{"control character reference", "a&#1;&#x1F;b", "ab"},
+		{"allowed control character references", "&#9;&#10;&#13;", "&#9;&#10;&#13;"},
+		{"noncharacter reference", "a&#xFFFE;b", "ab"},
+		{"out of range reference", "a&#x110000;b", "ab"},
+		{"raw null byte", "a\x00b", "ab"},
+		{"raw control characters", "a\x01\x08\x0B\x0C\x1Fb", "ab"},
+		{"raw tab and line breaks", "a\tb\r\nc", "a\tb\r\nc"},
+		{"raw surrogate pair", "\xED\xA0\xBD\xED\xB8\x80", "\U0001F600"},
+		{"raw lone surrogate", "a\xED\xA0\xBDb", "a�b"},
+		{"character in U+D000 block", "\xED\x95\x9C", "한"},
+		{"unterminated reference", "&#55357", "&#55357"},
+		{"entity reference", "&lt;&#38;&gt;", "&lt;&#38;&gt;"},
+		{"bare ampersand", "a & b", "a & b"},
+	}
+
+	readers := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"whole", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"half", iotest.HalfReader},
+	}
+
+	for _, tt := range tests {
+		for _, reader := range readers {
+			t.Run(tt.name+"/"+reader.name, func(t *testing.T) {
+				got, err := io.ReadAll(NewSanitizingReader(reader.wrap(strings.NewReader(tt.in))))
+				if err != nil {
+					t.Fatalf("ReadAll returned error: %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSanitizingReaderChunkBoundaries(t *testing.T) {
+	// a character reference at every offset relative to the 64 KiB buffer of the reader
+	prefix := strings.Repeat("x", 64*1024-20)
+	for i := 0; i < 40; i++ {
+		in := prefix + strings.Repeat("y", i) + "&#55357;&#56832;&#0;z"
+		want := prefix + strings.Repeat("y", i) + "&#128512;z"
+		got, err := io.ReadAll(NewSanitizingReader(iotest.OneByteReader(strings.NewReader(in))))
+		if err != nil {
+			t.Fatalf("offset %d: ReadAll returned error: %v", i, err)
+		}
+		if string(got) != want {
+			t.Errorf("offset %d: got %q, want %q", i, got[len(prefix):], want[len(prefix):])
+		}
+	}
+}
+
+func TestSanitizingReaderReadError(t *testing.T) {
+	r := NewSanitizingReader(iotest.TimeoutReader(strings.NewReader("abc")))
+	if _, err := io.ReadAll(r); err != iotest.ErrTimeout {
+		t.Errorf("got error %v, want %v", err, iotest.ErrTimeout)
+	}
+}
@@ -25,60 +25,105 @@ SOFTWARE.
 package smsbackuprestore
 
 import (
-	"fmt"
-	"os"
-	"strings"
 	"strconv"
-	"path/filepath"
 )
 
+// smsHeaders are the column headers of SMS output.
+// The raw columns contain phone numbers, contact names, and dates as they appear in the backup, rather than normalized
+// or formatted, so that they are imported unchanged.
+var smsHeaders = []string{
+	"SMS Index #",
+	"Protocol",
+	"Address",
+	"Type",
+	"Subject",
+	"Body",
+	"Service Center",
+	"Status",
+	"Read",
+	"Date",
+	"Locked",
+	"Date Sent",
+	"Readable Date",
+	"Contact Name",
+	"TOA",
+	"Service Center TOA",
+	"Subscription ID",
+	"Thread ID",
+	"Seen",
+	"Error Code",
+	"SIM Slot",
+	"SIM IMSI",
+	"Other Attributes",
+	"UTC Offset",
+	"Raw Address",
+	"Raw Service Center",
+	"Raw Contact Name",
+	"Raw Date",
+	"Raw Date Sent",
+}
+
 // GenerateSMSOutput outputs a tab-delimited file named "sms.tsv" containing parsed SMS messages from the backup file.
-func GenerateSMSOutput(m *Messages, outputDir string) error {
-	smsOutput, err := os.Create(filepath.Join(outputDir, "sms.tsv"))
-	if err != nil {
-		return fmt.Errorf("Unable to create file: sms.tsv\n%q", err)
-	}
-	defer smsOutput.Close()
+func GenerateSMSOutput(m *Messages, outputDir string, opts ...OutputOption) error {
+	return generateSMSTable(m, outputDir, newOutputConfig(opts))
+}
 
-	// print header row
-	headers := []string{
-		"SMS Index #",
-		"Protocol",
-		"Address",
-		"Type",
-		"Subject",
-		"Body",
-		"Service Center",
-		"Status",
-		"Read",
-		"Date",
-		"Locked",
-		"Date Sent",
-		"Readable Date",
-		"Contact Name",
-	}
-	fmt.Fprintf(smsOutput, "%s\n", strings.Join(headers, "\t"))
+// GenerateSMSCSV outputs an RFC 4180 comma-separated file named "sms.csv" containing parsed SMS messages from the
+// backup file. Unlike GenerateSMSOutput, message bodies are output exactly as they appear in the backup.
+func GenerateSMSCSV(m *Messages, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+	cfg.csv = true
+	return generateSMSTable(m, outputDir, cfg)
+}
+
+// generateSMSTable outputs a delimited file containing parsed SMS messages. The file is created even if there are no
+// messages.
+func generateSMSTable(m *Messages, outputDir string, cfg *outputConfig) error {
+	w := newTableRecordWriter(outputDir, cfg)
+	err := w.open(&w.sms, "sms", smsHeaders)
 
 	// iterate over sms
-	for i, sms := range m.SMS {
-		row := []string{
-			strconv.Itoa(i),
-			sms.Protocol,
-			sms.Address.String(),
-			sms.Type.String(),
-			sms.Subject,
-			CleanupMessageBody(sms.Body),
-			sms.ServiceCenter.String(),
-			sms.Status.String(),
-			sms.Read.String(),
-			sms.Date.String(),
-			sms.Locked.String(),
-			sms.DateSent.String(),
-			sms.ReadableDate,
-			RemoveCommasBeforeSuffixes(sms.ContactName),
-		}
-		fmt.Fprintf(smsOutput, "%s\n", strings.Join(row, "\t"))
+	for i := 0; err == nil && i < len(m.SMS); i++ {
+		err = w.WriteSMS(i, &m.SMS[i])
 	}
 
-	return nil
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// smsRow returns the columns of SMS output for the SMS with the given index.
+func smsRow(i int, sms *SMS, cfg *outputConfig) []string {
+	return []string{
+		strconv.Itoa(i),
+		sms.Protocol,
+		sms.Address.String(),
+		sms.Type.String(),
+		sms.Subject,
+		cfg.text(sms.Body),
+		sms.ServiceCenter.String(),
+		sms.Status.String(),
+		sms.Read.String(),
+		cfg.formatTime(sms.Date),
+		sms.Locked.String(),
+		cfg.formatTime(sms.DateSent),
+		sms.ReadableDate,
+		RemoveCommasBeforeSuffixes(sms.ContactName),
+		sms.TypeOfAddress,
+		sms.ServiceCenterTOA,
+		sms.SubscriptionID,
+		sms.ThreadID,
+		sms.Seen.String(),
+		sms.ErrorCode,
+		sms.SimSlot,
+		sms.SimIMSI,
+		cfg.text(FormatAttributes(sms.OtherAttributes)),
+		formatRecordOffset(sms.UTCOffset()),
+		string(sms.Address),
+		string(sms.ServiceCenter),
+		sms.ContactName,
+		string(sms.Date),
+		string(sms.DateSent),
+	}
 }
@@ -0,0 +1,387 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SplitBy selects how SplitBackup partitions a backup.
+type SplitBy int
+
+const (
+	// SplitByYear partitions records by the year of their date, e.g. "2018".
+	SplitByYear SplitBy = iota
+	// SplitByMonth partitions records by the month of their date, e.g. "2018-01".
+	SplitByMonth
+	// SplitByContact partitions records by contact name, or by normalized number if the contact is unknown.
+	SplitByContact
+	// SplitByNumber partitions records by normalized phone number.
+	SplitByNumber
+)
+
+// SplitPart is one of the backups produced by splitting a backup.
+type SplitPart struct {
+	Name   string // what the records of the part have in common, e.g. "2018-01" or "13125551212"
+	Backup *Backup
+}
+
+// splitRecords returns the SMS, MMS, and calls of b as records.
+func splitRecords(b *Backup) []Record {
+	var records []Record
+	if m := b.Messages; m != nil {
+		for i := range m.SMS {
+			records = append(records, &m.SMS[i])
+		}
+		for i := range m.MMS {
+			records = append(records, &m.MMS[i])
+		}
+	} else {
+		for i := range b.Calls.Calls {
+			records = append(records, &b.Calls.Calls[i])
+		}
+	}
+	return records
+}
+
+// newSplitPart returns an empty part with the type and metadata of b.
+func newSplitPart(name string, b *Backup) *SplitPart {
+	if m := b.Messages; m != nil {
+		return &SplitPart{Name: name, Backup: &Backup{Messages: &Messages{XMLName: m.XMLName, BackupSet: m.BackupSet,
+			BackupDate: m.BackupDate, OtherAttributes: m.OtherAttributes}}}
+	}
+	c := b.Calls
+	return &SplitPart{Name: name, Backup: &Backup{Calls: &Calls{XMLName: c.XMLName, BackupSet: c.BackupSet,
+		BackupDate: c.BackupDate, OtherAttributes: c.OtherAttributes}}}
+}
+
+// add adds a record to the part.
+func (p *SplitPart) add(record Record) {
+	switch rec := record.(type) {
+	case *SMS:
+		p.Backup.Messages.SMS = append(p.Backup.Messages.SMS, *rec)
+	case *MMS:
+		p.Backup.Messages.MMS = append(p.Backup.Messages.MMS, *rec)
+	case *Call:
+		p.Backup.Calls.Calls = append(p.Backup.Calls.Calls, *rec)
+	}
+}
+
+// finish sets the count of the part to the number of records it contains.
+func (p *SplitPart) finish() SplitPart {
+	if m := p.Backup.Messages; m != nil {
+		m.Count = strconv.Itoa(len(m.SMS) + len(m.MMS))
+	} else {
+		p.Backup.Calls.Count = strconv.Itoa(len(p.Backup.Calls.Calls))
+	}
+	return *p
+}
+
+// splitNumbers returns the normalized numbers of the participants of a record, with their contact names if known.
+func splitNumbers(record Record) (numbers []string, names map[string]string) {
+	names = make(map[string]string)
+	switch rec := record.(type) {
+	case *SMS:
+		number := rec.Address.String()
+		names[number] = RemoveCommasBeforeSuffixes(rec.ContactName)
+		numbers = []string{number}
+	case *MMS:
+		names = mmsContactNames(rec)
+		for _, number := range strings.Split(string(rec.Address), "~") {
+			numbers = append(numbers, PhoneNumber(number).String())
+		}
+	case *Call:
+		number := rec.Number.String()
+		names[number] = RemoveCommasBeforeSuffixes(rec.ContactName)
+		numbers = []string{number}
+	}
+	return numbers, names
+}
+
+// splitKeys returns the names of the parts a record belongs to. Group messages belong to the part of each participant
+// when splitting by contact or number.
+func splitKeys(record Record, by SplitBy, loc *time.Location) []string {
+	switch by {
+	case SplitByYear, SplitByMonth:
+		_, date, _, _ := diffRecordInfo(record)
+		t := date.Time()
+		if t.IsZero() {
+			return []string{"unknown"}
+		}
+		if by == SplitByYear {
+			return []string{t.In(loc).Format("2006")}
+		}
+		return []string{t.In(loc).Format("2006-01")}
+	}
+
+	numbers, names := splitNumbers(record)
+	var keys []string
+	for _, number := range numbers {
+		key := number
+		if name := strings.TrimSpace(names[number]); by == SplitByContact && name != "" && name != "(Unknown)" {
+			key = name
+		}
+		if key == "" {
+			key = "unknown"
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// SplitBackup partitions the records of b into backups by date (in the given time zone) or contact, returning them in
+// order of name. Each part keeps the backup set, date, and other root attributes of b and has its count set to the
+// number of records it contains. Group messages are included in the part of each participant when splitting by contact
+// or number, so that each part holds every conversation the contact took part in.
+func SplitBackup(b *Backup, by SplitBy, loc *time.Location) []SplitPart {
+	parts := make(map[string]*SplitPart)
+	for _, record := range splitRecords(b) {
+		for _, key := range splitKeys(record, by, loc) {
+			part := parts[key]
+			if part == nil {
+				part = newSplitPart(key, b)
+				parts[key] = part
+			}
+			part.add(record)
+		}
+	}
+
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]SplitPart, len(names))
+	for i, name := range names {
+		result[i] = parts[name].finish()
+	}
+	return result
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// xmlSize returns the number of bytes the XML of an empty backup takes up as written by WriteBackupXML.
+func xmlSize(write func(x *backupXMLWriter)) int64 {
+	counter := new(countingWriter)
+	x := newBackupXMLWriter(counter)
+	write(x)
+	x.flush()
+	return counter.n
+}
+
+// sizeSplitter decides where a backup split by size starts a new part, given the size of each of its records in turn.
+type sizeSplitter struct {
+	maxBytes int64
+	overhead int64 // size of the root element, allowing for the digits of the count attribute
+	size     int64 // size of the current part, or 0 before the first record
+}
+
+// newSizeSplitter returns a sizeSplitter for parts no larger than maxBytes of a backup with the root element h.
+func newSizeSplitter(h *Header, maxBytes int64) *sizeSplitter {
+	overhead := xmlSize(func(x *backupXMLWriter) {
+		x.root(h, 0)
+		x.raw("</" + h.XMLName.Local + ">\n")
+	}) + 20
+	return &sizeSplitter{maxBytes: maxBytes, overhead: overhead}
+}
+
+// add adds a record whose XML is recordSize bytes, reporting whether it starts a new part. A record is only added to
+// the current part if the part stays within maxBytes, so a record larger than maxBytes is placed in a part of its own.
+func (s *sizeSplitter) add(recordSize int64) bool {
+	newPart := s.size == 0 || s.size+recordSize > s.maxBytes
+	if newPart {
+		s.size = s.overhead
+	}
+	s.size += recordSize
+	return newPart
+}
+
+// SplitBackupBySize partitions the records of b into backups whose XML (as written by WriteBackupXML) is no larger than
+// maxBytes, named "part-001" and so on. Records are taken in chronological order, so each part covers a range of dates.
+// A single record larger than maxBytes is placed in a part of its own. Each part keeps the backup set, date, and other
+// root attributes of b and has its count set to the number of records it contains. SplitBySize splits a backup
+// without holding it in memory.
+func SplitBackupBySize(b *Backup, maxBytes int64) []SplitPart {
+	records := splitRecords(b)
+	sort.SliceStable(records, func(i, j int) bool {
+		_, first, _, _ := diffRecordInfo(records[i])
+		_, second, _, _ := diffRecordInfo(records[j])
+		return first.Time().Before(second.Time())
+	})
+
+	splitter := newSizeSplitter(b.Header(), maxBytes)
+	var parts []SplitPart
+	var part *SplitPart
+	for _, record := range records {
+		recordSize := xmlSize(func(x *backupXMLWriter) {
+			x.writeRecord(record)
+		})
+		if splitter.add(recordSize) {
+			if part != nil {
+				parts = append(parts, part.finish())
+			}
+			part = newSplitPart(fmt.Sprintf("part-%03d", len(parts)+1), b)
+		}
+		part.add(record)
+	}
+	if part != nil {
+		parts = append(parts, part.finish())
+	}
+	return parts
+}
+
+// SplitFile is a backup file written by SplitBySize.
+type SplitFile struct {
+	Name  string // name of the part, e.g. "part-001"
+	Path  string // path of the backup file
+	Count int    // number of records in the backup
+}
+
+// splitFileWriter writes a part of a backup split by SplitBySize. The count attribute of the root element precedes the
+// records, so they are written to a temporary file next to the backup file until the part is complete.
+type splitFileWriter struct {
+	SplitFile
+	body *os.File
+	x    *backupXMLWriter
+}
+
+// newSplitFileWriter returns a splitFileWriter for the part with the given name, to be written to the file at path.
+func newSplitFileWriter(name string, path string) (*splitFileWriter, error) {
+	body, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create file: %s\n%q", filepath.Base(path), err)
+	}
+	return &splitFileWriter{SplitFile: SplitFile{Name: name, Path: path}, body: body, x: newBackupXMLWriter(body)}, nil
+}
+
+// add adds a record, given its XML, to the part.
+func (w *splitFileWriter) add(recordXML []byte) {
+	if w.x.err == nil {
+		_, w.x.err = w.x.w.Write(recordXML)
+	}
+	w.Count++
+}
+
+// finish writes the backup file of the part with the root element h, followed by its records, and removes the
+// temporary file.
+func (w *splitFileWriter) finish(h *Header) error {
+	defer os.Remove(w.body.Name())
+	defer w.body.Close()
+	if err := w.x.flush(); err != nil {
+		return err
+	}
+	if _, err := w.body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f, err := os.Create(w.Path)
+	if err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", filepath.Base(w.Path), err)
+	}
+	x := newBackupXMLWriter(f)
+	x.root(h, w.Count)
+	if x.err == nil {
+		_, x.err = io.Copy(x.w, w.body)
+	}
+	x.raw("</" + h.XMLName.Local + ">\n")
+	err = x.flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SplitBySize reads the records of a backup from p one at a time and writes them to backups whose XML is no larger
+// than maxBytes, so that backups too large to hold in memory can be split. The parts are named "part-001" and so on,
+// and each is written to the file at partPath(name). Unlike SplitBackupBySize, records are kept in the order they
+// appear in the backup. A single record larger than maxBytes is placed in a part of its own. Each part keeps the root
+// attributes of the backup and has its count set to the number of records it contains. If the backup cannot be parsed
+// to the end, the records preceding the error are still written, and the error is returned with the files written.
+func SplitBySize(p *Parser, maxBytes int64, partPath func(name string) string) ([]SplitFile, error) {
+	h, err := p.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	splitter := newSizeSplitter(h, maxBytes)
+	var files []SplitFile
+	var part *splitFileWriter
+	finish := func() error {
+		if part == nil {
+			return nil
+		}
+		err := part.finish(h)
+		if err == nil {
+			files = append(files, part.SplitFile)
+		}
+		part = nil
+		return err
+	}
+
+	var recordXML bytes.Buffer
+	for {
+		record, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if finishErr := finish(); finishErr != nil {
+				return files, finishErr
+			}
+			return files, err
+		}
+
+		recordXML.Reset()
+		x := newBackupXMLWriter(&recordXML)
+		x.writeRecord(record)
+		x.flush()
+		if splitter.add(int64(recordXML.Len())) {
+			if err := finish(); err != nil {
+				return files, err
+			}
+			name := fmt.Sprintf("part-%03d", len(files)+1)
+			if part, err = newSplitFileWriter(name, partPath(name)); err != nil {
+				return files, err
+			}
+		}
+		part.add(recordXML.Bytes())
+	}
+	return files, finish()
+}
@@ -0,0 +1,227 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitPartString describes a SplitPart for comparison, e.g. "2018-01 3".
+func splitPartString(p SplitPart) string {
+	if m := p.Backup.Messages; m != nil {
+		return fmt.Sprintf("%s %s", p.Name, m.Count)
+	}
+	return fmt.Sprintf("%s %s", p.Name, p.Backup.Calls.Count)
+}
+
+func TestSplitBackup(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dated := &Messages{BackupSet: "set", BackupDate: "1704067200000", SMS: []SMS{
+		{Date: "1704060000000"}, // 2023-12-31 22:00 UTC, 2024-01-01 in Tokyo
+		{Date: "1704153600000"}, // 2024-01-02
+		{Date: "1720000000000"}, // 2024-07-03
+		{Date: "null"},
+	}}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		b    *Backup
+		by   SplitBy
+		loc  *time.Location
+		want []string
+	}{
+		{"year", &Backup{Messages: dated}, SplitByYear, time.UTC, []string{"2023 1", "2024 2", "unknown 1"}},
+		{"year in time zone", &Backup{Messages: dated}, SplitByYear, tokyo, []string{"2024 3", "unknown 1"}},
+		{"month", &Backup{Messages: dated}, SplitByMonth, time.UTC,
+			[]string{"2023-12 1", "2024-01 1", "2024-07 1", "unknown 1"}},
+		// the group MMS is in the part of each of its participants
+		{"number", &Backup{Messages: m}, SplitByNumber, time.UTC,
+			[]string{"13125551212 1", "13125553434 2", "442079460958 1"}},
+		{"contact", &Backup{Messages: m}, SplitByContact, time.UTC,
+			[]string{"13125553434 1", "Alice 1", "Bob 1", "Smith Jr., Bob 1"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, part := range SplitBackup(tt.b, tt.by, tt.loc) {
+			got = append(got, splitPartString(part))
+			h := part.Backup.Header()
+			if want := tt.b.Header(); h.BackupSet != want.BackupSet || h.BackupDate != want.BackupDate ||
+				!reflect.DeepEqual(h.OtherAttributes, want.OtherAttributes) {
+				t.Errorf("%s: part %s has root %+v, want %+v", tt.name, part.Name, h, want)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got parts %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	parts := SplitBackup(&Backup{Messages: m}, SplitByNumber, time.UTC)
+	if group := parts[1].Backup.Messages; len(group.SMS) != 1 || len(group.MMS) != 1 || group.MMS[0].MessageID != "mid" {
+		t.Errorf("got part %+v, want the SMS and group MMS of 13125553434", group)
+	}
+}
+
+func TestSplitBackupBySize(t *testing.T) {
+	c := &Calls{BackupSet: "set", BackupDate: "1704067200000"}
+	for i := 0; i < 10; i++ {
+		// in reverse chronological order, as the parts are chronological
+		c.Calls = append(c.Calls, Call{Date: AndroidTS(fmt.Sprint(1704060000000 - int64(i)*1000)), Number: "13125551212"})
+	}
+	c.Calls[5].ContactName = strings.Repeat("x", 2000)
+
+	// room for three calls, with the digits of the count allowed for
+	var buf strings.Builder
+	three := &Calls{BackupSet: c.BackupSet, BackupDate: c.BackupDate, Calls: c.Calls[:3]}
+	if err := WriteCallsXML(&buf, three); err != nil {
+		t.Fatal(err)
+	}
+	maxBytes := int64(buf.Len()) + 20
+
+	parts := SplitBackupBySize(&Backup{Calls: c}, maxBytes)
+	var got []string
+	for _, part := range parts {
+		got = append(got, splitPartString(part))
+		if part.Backup.Calls.BackupSet != "set" || part.Backup.Calls.BackupDate != "1704067200000" {
+			t.Errorf("part %s has backup set %q and date %q", part.Name, part.Backup.Calls.BackupSet,
+				part.Backup.Calls.BackupDate)
+		}
+
+		var buf strings.Builder
+		if err := WriteCallsXML(&buf, part.Backup.Calls); err != nil {
+			t.Fatal(err)
+		}
+		if size := int64(buf.Len()); size > maxBytes && len(part.Backup.Calls.Calls) > 1 {
+			t.Errorf("part %s is %d bytes, more than %d", part.Name, size, maxBytes)
+		}
+	}
+	// the oversize call is in a part of its own
+	want := []string{"part-001 3", "part-002 1", "part-003 1", "part-004 3", "part-005 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got parts %q, want %q", got, want)
+	}
+	if big := parts[2].Backup.Calls.Calls[0]; len(big.ContactName) != 2000 {
+		t.Errorf("got call %+v in part-003, want the oversize call", big)
+	}
+	if first := parts[0].Backup.Calls.Calls[0]; first.Date != "1704059991000" {
+		t.Errorf("got first call of %s, want the earliest", first.Date)
+	}
+}
+
+func TestSplitBySize(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	partPath := func(name string) string {
+		return filepath.Join(dir, name+".xml")
+	}
+
+	// every message is larger than the maximum, so each is in a part of its own, in the order of the backup
+	files, err := SplitBySize(NewParser(strings.NewReader(testRoundTripXML)), 1, partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	for i, f := range files {
+		name := fmt.Sprintf("part-%03d", i+1)
+		if f.Name != name || f.Path != partPath(name) || f.Count != 1 {
+			t.Errorf("got file %+v", f)
+		}
+		b, err := Open(f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := b.Messages
+		want := &Messages{XMLName: m.XMLName, Count: "1", BackupSet: m.BackupSet, BackupDate: m.BackupDate,
+			OtherAttributes: m.OtherAttributes}
+		if i < 2 {
+			want.SMS = m.SMS[i : i+1]
+		} else {
+			want.MMS = m.MMS
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got\n%+v\nwant\n%+v", f.Name, got, want)
+		}
+	}
+
+	// a single part holds everything, as WriteMessagesXML would write it
+	dir = t.TempDir()
+	files, err = SplitBySize(NewParser(strings.NewReader(testRoundTripXML)), 1<<20, partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Count != 3 {
+		t.Fatalf("got files %+v, want a single file of 3 messages", files)
+	}
+	var want strings.Builder
+	if err := WriteMessagesXML(&want, m); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(files[0].Path); err != nil || string(got) != want.String() {
+		t.Errorf("got part (%v)\n%s\nwant\n%s", err, got, want.String())
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("got %d files in the output directory (%v), want only the part", len(entries), err)
+	}
+}
+
+func TestSplitBySizeTruncated(t *testing.T) {
+	doc := testRoundTripXML[:strings.Index(testRoundTripXML, "<mms ")+20]
+	dir := t.TempDir()
+	files, err := SplitBySize(NewParser(strings.NewReader(doc)), 1<<20, func(name string) string {
+		return filepath.Join(dir, name+".xml")
+	})
+	if err == nil || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Errorf("got error %v, want unexpected EOF", err)
+	}
+	if len(files) != 1 || files[0].Count != 2 {
+		t.Fatalf("got files %+v, want a single file of the 2 SMS preceding the error", files)
+	}
+	b, err := Open(files[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Messages.SMS) != 2 || len(b.Messages.MMS) != 0 {
+		t.Errorf("got %d SMS and %d MMS, want 2 SMS", len(b.Messages.SMS), len(b.Messages.MMS))
+	}
+}
@@ -0,0 +1,641 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver, so builds do not require cgo
+)
+
+// sqliteSchema creates the tables and indexes of SQLite output. Timestamps are stored as milliseconds since the Unix
+// epoch, and enumerated values are stored as integers alongside their decoded names.
+const sqliteSchema = `
+CREATE TABLE backup_metadata (
+	id INTEGER PRIMARY KEY,
+	source TEXT,
+	backup_type TEXT NOT NULL,
+	count INTEGER,
+	backup_set TEXT,
+	backup_date INTEGER
+);
+
+CREATE TABLE contacts (
+	id INTEGER PRIMARY KEY,
+	normalized_number TEXT NOT NULL UNIQUE,
+	name TEXT
+);
+
+CREATE TABLE sms (
+	id INTEGER PRIMARY KEY,
+	backup_id INTEGER NOT NULL REFERENCES backup_metadata(id),
+	sms_index INTEGER NOT NULL,
+	contact_id INTEGER REFERENCES contacts(id),
+	protocol TEXT,
+	address TEXT,
+	normalized_address TEXT,
+	type INTEGER,
+	type_name TEXT,
+	subject TEXT,
+	body TEXT,
+	service_center TEXT,
+	status INTEGER,
+	status_name TEXT,
+	read INTEGER,
+	date INTEGER,
+	locked INTEGER,
+	date_sent INTEGER,
+	readable_date TEXT,
+	contact_name TEXT,
+	toa TEXT,
+	sc_toa TEXT,
+	sub_id TEXT,
+	thread_id TEXT,
+	seen INTEGER,
+	error_code TEXT,
+	sim_slot TEXT,
+	sim_imsi TEXT,
+	utc_offset TEXT,
+	other_attributes TEXT
+);
+
+CREATE TABLE mms (
+	id INTEGER PRIMARY KEY,
+	backup_id INTEGER NOT NULL REFERENCES backup_metadata(id),
+	mms_index INTEGER NOT NULL,
+	contact_id INTEGER REFERENCES contacts(id),
+	direction TEXT,
+	text_only INTEGER,
+	read INTEGER,
+	date INTEGER,
+	locked INTEGER,
+	date_sent INTEGER,
+	readable_date TEXT,
+	contact_name TEXT,
+	seen INTEGER,
+	from_address TEXT,
+	address TEXT,
+	normalized_address TEXT,
+	sender TEXT,
+	m_cls TEXT,
+	m_size TEXT,
+	msg_box INTEGER,
+	msg_box_name TEXT,
+	m_type INTEGER,
+	m_type_name TEXT,
+	m_id TEXT,
+	sub TEXT,
+	sub_cs INTEGER,
+	ct_t TEXT,
+	ct_l TEXT,
+	tr_id TEXT,
+	d_rpt INTEGER,
+	rr INTEGER,
+	read_status INTEGER,
+	exp TEXT,
+	pri INTEGER,
+	resp_st INTEGER,
+	sub_id TEXT,
+	utc_offset TEXT,
+	other_attributes TEXT
+);
+
+CREATE TABLE mms_parts (
+	id INTEGER PRIMARY KEY,
+	mms_id INTEGER NOT NULL REFERENCES mms(id),
+	part_index INTEGER NOT NULL,
+	seq TEXT,
+	ct TEXT,
+	name TEXT,
+	fn TEXT,
+	cd TEXT,
+	text TEXT,
+	chset INTEGER,
+	cid TEXT,
+	cl TEXT,
+	ctt_s TEXT,
+	ctt_t TEXT,
+	data BLOB,
+	file_path TEXT,
+	other_attributes TEXT
+);
+
+CREATE TABLE mms_addresses (
+	id INTEGER PRIMARY KEY,
+	mms_id INTEGER NOT NULL REFERENCES mms(id),
+	contact_id INTEGER REFERENCES contacts(id),
+	address TEXT,
+	normalized_address TEXT,
+	type INTEGER,
+	type_name TEXT,
+	charset INTEGER
+);
+
+CREATE TABLE calls (
+	id INTEGER PRIMARY KEY,
+	backup_id INTEGER NOT NULL REFERENCES backup_metadata(id),
+	call_index INTEGER NOT NULL,
+	contact_id INTEGER REFERENCES contacts(id),
+	number TEXT,
+	normalized_number TEXT,
+	duration INTEGER,
+	date INTEGER,
+	type INTEGER,
+	type_name TEXT,
+	readable_date TEXT,
+	contact_name TEXT,
+	presentation INTEGER,
+	presentation_name TEXT,
+	subscription_id TEXT,
+	subscription_component_name TEXT,
+	post_dial_digits TEXT,
+	features INTEGER,
+	features_name TEXT,
+	utc_offset TEXT,
+	other_attributes TEXT
+);
+
+CREATE INDEX sms_date ON sms(date);
+CREATE INDEX sms_normalized_address ON sms(normalized_address);
+CREATE INDEX mms_date ON mms(date);
+CREATE INDEX mms_normalized_address ON mms(normalized_address);
+CREATE INDEX mms_parts_mms_id ON mms_parts(mms_id);
+CREATE INDEX mms_addresses_mms_id ON mms_addresses(mms_id);
+CREATE INDEX mms_addresses_normalized_address ON mms_addresses(normalized_address);
+CREATE INDEX calls_date ON calls(date);
+CREATE INDEX calls_normalized_number ON calls(normalized_number);
+`
+
+// WithAttachmentPaths stores the path of the file written by DecodeAttachments (relative to the output directory) for
+// image parts in SQLite output, rather than the image data itself. Other parts are still stored as BLOBs.
+func WithAttachmentPaths() OutputOption {
+	return func(c *outputConfig) {
+		c.attachmentPaths = true
+	}
+}
+
+// SQLiteExport writes parsed backups to a single SQLite database with tables for sms, mms, mms_parts, mms_addresses,
+// calls, contacts, and backup_metadata. Any number of SMS and calls backups may be added before it is closed, either
+// whole with AddMessages and AddCalls or record by record as a BackupWriter.
+type SQLiteExport struct {
+	db       *sql.DB
+	cfg      *outputConfig
+	contacts map[string]int64     // contact IDs keyed by normalized number
+	named    map[int64]bool       // contacts whose name is known
+	tx       *sql.Tx              // transaction of the current backup
+	backupID int64                // backup_metadata row of the current backup
+	stmts    map[string]*sql.Stmt // statements prepared in tx, keyed by table
+
+	// Errors lists the MMS parts whose base64 data could not be decoded. Their data is stored as NULL, and the rest of
+	// the backup is still added.
+	Errors []error
+}
+
+// NewSQLiteExport creates a SQLite database at path, replacing any existing file, and creates its schema.
+func NewSQLiteExport(dbPath string, opts ...OutputOption) (*SQLiteExport, error) {
+	if err := os.Remove(dbPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to replace file: %s\n%q", dbPath, err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create database: %s\n%q", dbPath, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to create database schema: %s\n%q", dbPath, err)
+	}
+
+	return &SQLiteExport{
+		db:       db,
+		cfg:      newOutputConfig(opts),
+		contacts: make(map[string]int64),
+		named:    make(map[int64]bool),
+	}, nil
+}
+
+// GenerateSQLite outputs a SQLite database named "backup.sqlite" containing parsed messages and calls. Either m or c
+// may be nil. Use NewSQLiteExport to combine more backups than that in one database.
+func GenerateSQLite(m *Messages, c *Calls, outputDir string, opts ...OutputOption) error {
+	s, err := NewSQLiteExport(filepath.Join(outputDir, "backup.sqlite"), opts...)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if err := s.AddMessages(m, ""); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if c != nil {
+		if err := s.AddCalls(c, ""); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return s.Close()
+}
+
+// BeginBackup commits the records of the previous backup, if any, and begins a transaction for the records of another
+// backup. The source (e.g. the path of the backup file) and root element attributes are recorded in the backup_metadata
+// table, and with WithAttachmentPaths, image paths are prefixed as with WithSource(source).
+func (s *SQLiteExport) BeginBackup(source string, h *Header) error {
+	if err := s.commit(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	backupID, err := insertMetadata(tx, source, h.BackupType(), h.Count, h.BackupSet, h.BackupDate)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	s.tx, s.backupID, s.stmts = tx, backupID, make(map[string]*sql.Stmt)
+	s.cfg.source = sourceName(source)
+	return nil
+}
+
+// stmt returns the statement inserting a row into table, which has the given number of columns after its id, preparing
+// it in the transaction of the current backup if necessary. If no backup has begun, a backup with the given root
+// element and no source is begun.
+func (s *SQLiteExport) stmt(root string, table string, columns int) (*sql.Stmt, error) {
+	if s.tx == nil {
+		if err := s.BeginBackup("", &Header{XMLName: xml.Name{Local: root}}); err != nil {
+			return nil, err
+		}
+	}
+	if stmt, ok := s.stmts[table]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.tx.Prepare("INSERT INTO " + table + " VALUES (NULL" + strings.Repeat(", ?", columns) + ")")
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[table] = stmt
+	return stmt, nil
+}
+
+// commit commits the transaction of the current backup, if any.
+func (s *SQLiteExport) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	err := s.tx.Commit()
+	s.tx, s.stmts = nil, nil
+	return err
+}
+
+// rollback discards the transaction of the current backup, if any. The contacts it added are forgotten, as their IDs
+// are no longer in the contacts table.
+func (s *SQLiteExport) rollback() {
+	if s.tx == nil {
+		return
+	}
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	s.tx.Rollback()
+	s.tx, s.stmts = nil, nil
+	s.contacts, s.named = make(map[string]int64), make(map[int64]bool)
+}
+
+// Close commits the records of the current backup, if any, and closes the database.
+func (s *SQLiteExport) Close() error {
+	err := s.commit()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// AddMessages adds the SMS and MMS messages of a backup to the database in a single transaction. The source (e.g. the
+// path of the backup file) is recorded in the backup_metadata table.
+func (s *SQLiteExport) AddMessages(m *Messages, source string) error {
+	err := s.BeginBackup(source, (&Backup{Messages: m}).Header())
+	if err == nil {
+		err = WriteMessages(s, m)
+	}
+	if err != nil {
+		s.rollback()
+		return fmt.Errorf("Unable to add messages to database: %s\n%q", source, err)
+	}
+	return s.commit()
+}
+
+// AddCalls adds the calls of a backup to the database in a single transaction. The source (e.g. the path of the
+// backup file) is recorded in the backup_metadata table.
+func (s *SQLiteExport) AddCalls(c *Calls, source string) error {
+	err := s.BeginBackup(source, (&Backup{Calls: c}).Header())
+	if err == nil {
+		err = WriteCalls(s, c)
+	}
+	if err != nil {
+		s.rollback()
+		return fmt.Errorf("Unable to add calls to database: %s\n%q", source, err)
+	}
+	return s.commit()
+}
+
+// insertMetadata records the root element attributes of a backup, returning the ID of its backup_metadata row.
+func insertMetadata(tx *sql.Tx, source string, backupType BackupType, count string, backupSet string, backupDate AndroidTS) (int64, error) {
+	result, err := tx.Exec(
+		"INSERT INTO backup_metadata (source, backup_type, count, backup_set, backup_date) VALUES (?, ?, ?, ?, ?)",
+		source, backupType.String(), count, backupSet, sqliteTimestamp(backupDate))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// WriteSMS adds the SMS with the given index to the sms table.
+func (s *SQLiteExport) WriteSMS(i int, sms *SMS) error {
+	stmt, err := s.stmt("smses", "sms", 29)
+	if err != nil {
+		return err
+	}
+	contactID, err := s.contact(s.tx, sms.Address, sms.ContactName)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.backupID, i, contactID,
+		sms.Protocol,
+		string(sms.Address),
+		sms.Address.String(),
+		int(sms.Type), sms.Type.String(),
+		sms.Subject,
+		sms.Body,
+		string(sms.ServiceCenter),
+		int(sms.Status), sms.Status.String(),
+		int(sms.Read),
+		sqliteTimestamp(sms.Date),
+		int(sms.Locked),
+		sqliteTimestamp(sms.DateSent),
+		sms.ReadableDate,
+		sms.ContactName,
+		sms.TypeOfAddress,
+		sms.ServiceCenterTOA,
+		sms.SubscriptionID,
+		sms.ThreadID,
+		int(sms.Seen),
+		sms.ErrorCode,
+		sms.SimSlot,
+		sms.SimIMSI,
+		formatRecordOffset(sms.UTCOffset()),
+		FormatAttributes(sms.OtherAttributes),
+	)
+	return err
+}
+
+// WriteMMS adds the MMS with the given index to the mms table, and its parts and addresses to the mms_parts and
+// mms_addresses tables.
+func (s *SQLiteExport) WriteMMS(mmsIndex int, mms *MMS) error {
+	mmsStmt, err := s.stmt("smses", "mms", 37)
+	if err != nil {
+		return err
+	}
+	partStmt, err := s.stmt("smses", "mms_parts", 16)
+	if err != nil {
+		return err
+	}
+	addrStmt, err := s.stmt("smses", "mms_addresses", 7)
+	if err != nil {
+		return err
+	}
+
+	// group messages have several addresses separated by ~ and a list of contact names, so are not a single contact
+	var contactID interface{}
+	if !strings.Contains(string(mms.Address), "~") {
+		if contactID, err = s.contact(s.tx, mms.Address, mms.ContactName); err != nil {
+			return err
+		}
+	}
+
+	result, err := mmsStmt.Exec(
+		s.backupID, mmsIndex, contactID,
+		mms.Direction().String(),
+		int(mms.TextOnly),
+		int(mms.Read),
+		sqliteTimestamp(mms.Date),
+		int(mms.Locked),
+		sqliteTimestamp(mms.DateSent),
+		mms.ReadableDate,
+		mms.ContactName,
+		int(mms.Seen),
+		string(mms.FromAddress),
+		string(mms.Address),
+		mms.Address.String(),
+		mms.Sender().String(),
+		mms.MessageClassifier,
+		mms.MessageSize,
+		int(mms.MessageBox), mms.MessageBox.String(),
+		int(mms.MessageType), mms.MessageType.String(),
+		mms.MessageID,
+		mms.Subject,
+		int(mms.SubjectCharset),
+		mms.ContentType,
+		mms.ContentLocation,
+		mms.TransactionID,
+		int(mms.DeliveryReport),
+		int(mms.ReadReport),
+		int(mms.ReadStatus),
+		mms.Expiry,
+		int(mms.Priority),
+		int(mms.ResponseStatus),
+		mms.SubscriptionID,
+		formatRecordOffset(mms.UTCOffset()),
+		FormatAttributes(mms.OtherAttributes),
+	)
+	if err != nil {
+		return err
+	}
+	mmsID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for partIndex, part := range mms.Parts {
+		data, filePath, err := s.attachment(part, mmsIndex, partIndex)
+		if err != nil {
+			// stored without data, rather than leaving the MMS without its parts
+			s.Errors = append(s.Errors, err)
+		}
+		_, err = partStmt.Exec(
+			mmsID, partIndex,
+			part.Sequence,
+			part.ContentType,
+			part.Name,
+			part.FileName,
+			part.ContentDisplay,
+			part.Text,
+			int(part.Charset),
+			part.ContentID,
+			part.ContentLocation,
+			part.ContentTypeStart,
+			part.ContentTypeType,
+			data,
+			filePath,
+			FormatAttributes(part.OtherAttributes),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range mms.Addresses {
+		addrContactID, err := s.contact(s.tx, addr.Address, "")
+		if err != nil {
+			return err
+		}
+		_, err = addrStmt.Exec(
+			mmsID, addrContactID,
+			string(addr.Address),
+			addr.Address.String(),
+			int(addr.Type), addr.Type.String(),
+			int(addr.Charset),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCall adds the call with the given index to the calls table.
+func (s *SQLiteExport) WriteCall(i int, call *Call) error {
+	stmt, err := s.stmt("calls", "calls", 20)
+	if err != nil {
+		return err
+	}
+	contactID, err := s.contact(s.tx, call.Number, call.ContactName)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.backupID, i, contactID,
+		string(call.Number),
+		call.Number.String(),
+		call.Duration,
+		sqliteTimestamp(call.Date),
+		int(call.Type), call.Type.String(),
+		call.ReadableDate,
+		call.ContactName,
+		int(call.Presentation), call.Presentation.String(),
+		call.SubscriptionID,
+		call.SubscriptionComponentName,
+		call.PostDialDigits,
+		int(call.Features), call.Features.String(),
+		formatRecordOffset(call.UTCOffset()),
+		FormatAttributes(call.OtherAttributes),
+	)
+	return err
+}
+
+// contact returns the ID of the contact with the given number, adding it to the contacts table if necessary and
+// recording its name if not already known. Contacts are looked up in the table if they are not cached (e.g. after a
+// rollback). A nil ID is returned for empty numbers and for the placeholder Android records in place of the device's
+// own number.
+func (s *SQLiteExport) contact(tx *sql.Tx, number PhoneNumber, name string) (interface{}, error) {
+	normalized := number.String()
+	if normalized == "" || normalized == "null" || number == "insert-address-token" {
+		return nil, nil
+	}
+	name = RemoveCommasBeforeSuffixes(name)
+	if name == "(Unknown)" {
+		name = ""
+	}
+
+	id, ok := s.contacts[normalized]
+	if !ok {
+		var known sql.NullString
+		err := tx.QueryRow("SELECT id, name FROM contacts WHERE normalized_number = ?", normalized).Scan(&id, &known)
+		switch {
+		case err == nil:
+			s.named[id] = known.String != ""
+		case errors.Is(err, sql.ErrNoRows):
+			result, err := tx.Exec("INSERT INTO contacts (normalized_number, name) VALUES (?, ?)", normalized, name)
+			if err != nil {
+				return nil, err
+			}
+			if id, err = result.LastInsertId(); err != nil {
+				return nil, err
+			}
+			s.named[id] = name != ""
+		default:
+			return nil, err
+		}
+		s.contacts[normalized] = id
+	}
+	if !s.named[id] && name != "" {
+		if _, err := tx.Exec("UPDATE contacts SET name = ? WHERE id = ?", name, id); err != nil {
+			return nil, err
+		}
+		s.named[id] = true
+	}
+	return id, nil
+}
+
+// attachment returns the decoded data of an MMS part to store in the database or, with WithAttachmentPaths, the path
+// of the file written for it by DecodeImages.
+func (s *SQLiteExport) attachment(part Part, mmsIndex int, partIndex int) (data []byte, filePath interface{}, err error) {
+	if part.Base64Data == "" || part.Base64Data == "null" {
+		return nil, nil, nil
+	}
+	if s.cfg.attachmentPaths && strings.Contains(part.ContentType, "image/") {
+		return nil, s.cfg.attachmentPath(&part, mmsIndex, partIndex), nil
+	}
+
+	encoded := strings.NewReplacer("\n", "", "\r", "").Replace(part.Base64Data)
+	data, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		if s.cfg.source != "" {
+			return nil, nil, fmt.Errorf("Error decoding base64 data of %s MMS %d part %d: %q", s.cfg.source, mmsIndex,
+				partIndex, err)
+		}
+		return nil, nil, fmt.Errorf("Error decoding base64 data of MMS %d part %d: %q", mmsIndex, partIndex, err)
+	}
+	return data, nil, nil
+}
+
+// sqliteTimestamp converts timestamp to milliseconds since the Unix epoch, or nil if it is not a valid timestamp.
+func sqliteTimestamp(timestamp AndroidTS) interface{} {
+	t := timestamp.Time()
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,244 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/xml"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openTestSQLite opens the database at dbPath for reading, closing it when the test ends.
+func openTestSQLite(t *testing.T, dbPath string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// queryStrings returns the first column of each row returned by query.
+func queryStrings(t *testing.T, db *sql.DB, query string, args ...interface{}) []string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v.String)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestSQLiteExport(t *testing.T) {
+	m, err := ParseMessages(strings.NewReader(testRoundTripXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseCalls(strings.NewReader(testRoundTripCallsXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "backup.sqlite")
+	s, err := NewSQLiteExport(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddMessages(m, "sms.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddCalls(c, "calls.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db := openTestSQLite(t, dbPath)
+
+	tables := queryStrings(t, db, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	wantTables := []string{"backup_metadata", "calls", "contacts", "mms", "mms_addresses", "mms_parts", "sms"}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Errorf("got tables %v, want %v", tables, wantTables)
+	}
+	indexes := queryStrings(t, db,
+		"SELECT name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL ORDER BY name")
+	wantIndexes := []string{"calls_date", "calls_normalized_number", "mms_addresses_mms_id",
+		"mms_addresses_normalized_address", "mms_date", "mms_normalized_address", "mms_parts_mms_id", "sms_date",
+		"sms_normalized_address"}
+	if !reflect.DeepEqual(indexes, wantIndexes) {
+		t.Errorf("got indexes %v, want %v", indexes, wantIndexes)
+	}
+	metadata := queryStrings(t, db,
+		"SELECT source || ' ' || backup_type || ' ' || count FROM backup_metadata ORDER BY id")
+	if want := []string{"sms.xml smses 3", "calls.xml calls 2"}; !reflect.DeepEqual(metadata, want) {
+		t.Errorf("got backup_metadata %v, want %v", metadata, want)
+	}
+
+	// SMS
+	var address, normalized, body, contactName string
+	var date int64
+	var dateSent sql.NullInt64
+	err = db.QueryRow(`SELECT sms.address, sms.normalized_address, sms.body, sms.date, sms.date_sent, contacts.name
+		FROM sms JOIN contacts ON contacts.id = sms.contact_id WHERE sms_index = 0`).Scan(&address, &normalized, &body,
+		&date, &dateSent, &contactName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "+44 20 7946 0958" || normalized != "442079460958" || body != m.SMS[0].Body ||
+		date != 1704060000123 || dateSent.Valid || contactName != RemoveCommasBeforeSuffixes("Smith, Jr., Bob") {
+		t.Errorf("got SMS %q, %q, %q, %d, %v, %q", address, normalized, body, date, dateSent, contactName)
+	}
+
+	// MMS with its parts and addresses
+	var mmsID int64
+	var sender string
+	if err := db.QueryRow("SELECT id, sender FROM mms WHERE mms_index = 0").Scan(&mmsID, &sender); err != nil {
+		t.Fatal(err)
+	}
+	if sender != "13125551212" {
+		t.Errorf("got sender %q", sender)
+	}
+	parts := queryStrings(t, db, "SELECT ct FROM mms_parts WHERE mms_id = ? ORDER BY part_index", mmsID)
+	if !reflect.DeepEqual(parts, []string{"application/smil", "text/plain", "image/png"}) {
+		t.Errorf("got parts %v", parts)
+	}
+	var data []byte
+	err = db.QueryRow("SELECT data FROM mms_parts WHERE mms_id = ? AND part_index = 2", mmsID).Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := base64.StdEncoding.DecodeString("iVBORw0KGgo="); !bytes.Equal(data, want) {
+		t.Errorf("got image data %v, want %v", data, want)
+	}
+	addrs := queryStrings(t, db, `SELECT type_name || ' ' || ifnull(contact_id, 'none') FROM mms_addresses
+		WHERE mms_id = ? ORDER BY id`, mmsID)
+	if len(addrs) != 3 || !strings.HasPrefix(addrs[0], "From ") || addrs[2] != "To none" {
+		t.Errorf("got addresses %v", addrs)
+	}
+
+	// call
+	var duration, features int
+	var number string
+	err = db.QueryRow("SELECT number, duration, features FROM calls WHERE call_index = 0").Scan(&number, &duration,
+		&features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != "+13125551212" || duration != 65 || features != 5 {
+		t.Errorf("got call %q, %d, %d", number, duration, features)
+	}
+
+	// a contact found in both backups is stored once
+	contacts := queryStrings(t, db, "SELECT count(*) FROM contacts WHERE normalized_number = '13125553434'")
+	if contacts[0] != "1" {
+		t.Errorf("got %s contacts for 13125553434, want 1", contacts[0])
+	}
+	if got := queryStrings(t, db, "PRAGMA foreign_key_check"); len(got) != 0 {
+		t.Errorf("got foreign key violations in %v", got)
+	}
+}
+
+func TestSQLiteExportInvalidPartData(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "backup.sqlite")
+	s, err := NewSQLiteExport(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Messages{MMS: []MMS{
+		{Address: "13125551212", Parts: []Part{{ContentType: "image/png", Base64Data: "not base64!"}, {Text: "hi"}}},
+		{Address: "13125553434", Parts: []Part{{ContentType: "image/png", Base64Data: "AA=="}}},
+	}}
+	if err := WriteBackup(s, "sms.xml", &Backup{Messages: m}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Errors) != 1 || !strings.Contains(s.Errors[0].Error(), "sms MMS 0 part 0") {
+		t.Errorf("got errors %v", s.Errors)
+	}
+
+	db := openTestSQLite(t, dbPath)
+	got := queryStrings(t, db, `SELECT mms_index || ' ' || part_index || ' ' || ifnull(length(data), 'null')
+		FROM mms_parts JOIN mms ON mms.id = mms_parts.mms_id ORDER BY mms_index, part_index`)
+	if want := []string{"0 0 null", "0 1 null", "1 0 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got parts %v, want %v", got, want)
+	}
+}
+
+func TestSQLiteExportRollback(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "backup.sqlite")
+	s, err := NewSQLiteExport(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &Calls{Calls: []Call{{Number: "13125551212", ContactName: "Alice"}}}
+	if err := s.AddCalls(alice, "calls-1.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a contact added in a backup that is rolled back is added again by the next backup
+	if err := s.BeginBackup("calls-2.xml", &Header{XMLName: xml.Name{Local: "calls"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteCall(0, &Call{Number: "13125553434", ContactName: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	s.rollback()
+	calls := &Calls{Calls: []Call{{Number: "13125553434"}, {Number: "13125551212"}}}
+	if err := s.AddCalls(calls, "calls-3.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestSQLite(t, dbPath)
+	got := queryStrings(t, db, `SELECT calls.number || ' ' || ifnull(contacts.name, '') FROM calls
+		JOIN contacts ON contacts.id = calls.contact_id ORDER BY calls.id`)
+	if want := []string{"13125551212 Alice", "13125553434 ", "13125551212 Alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls %v, want %v", got, want)
+	}
+	sources := queryStrings(t, db, "SELECT source FROM backup_metadata ORDER BY id")
+	if want := []string{"calls-1.xml", "calls-3.xml"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("got backups %v, want %v", sources, want)
+	}
+}
@@ -0,0 +1,313 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readableDateLayouts are the layouts of the readable_date attribute written by the SMS Backup & Restore app in the
+// locales it supports. Layouts are tried in order, so where day and month order are ambiguous (e.g. 03/04/2018) the
+// first layout yielding a plausible UTC offset wins.
+var readableDateLayouts = []string{
+	"Jan 2, 2006 3:04:05 PM",   // en-US
+	"Jan 2, 2006, 3:04:05 PM",  // en-US (Android 13+)
+	"Jan 2, 2006 15:04:05",
+	"2 Jan 2006 15:04:05",      // en-GB, en-AU
+	"2 Jan 2006 3:04:05 PM",
+	"2 Jan. 2006 15:04:05",
+	"2006-01-02 15:04:05",      // ISO 8601, sv, lt
+	"2006-01-02 3:04:05 PM",
+	"2006/01/02 15:04:05",      // ja, zh
+	"2006/01/02 PM3:04:05",
+	"2006. 1. 2. PM 3:04:05",   // ko
+	"02/01/2006 15:04:05",      // fr, es, it, pt, en-IN
+	"01/02/2006 15:04:05",
+	"1/2/2006 3:04:05 PM",      // en-US (short)
+	"2/1/2006 15:04:05",
+	"02.01.2006 15:04:05",      // de, ru, pl, tr
+	"2.1.2006 15:04:05",
+	"02-01-2006 15:04:05",      // nl
+	"2-1-2006 15:04:05",
+}
+
+// maxUTCOffset is the largest UTC offset in use by any time zone (UTC+14:00, Line Islands).
+const maxUTCOffset = 14 * time.Hour
+
+// ErrUnrecognizedReadableDate is returned when a readable_date value does not match any known layout with a
+// plausible UTC offset.
+var ErrUnrecognizedReadableDate = errors.New("unrecognized readable date")
+
+// normalizeReadableDate removes locale-specific variations in whitespace and AM/PM markers that time.Parse cannot
+// handle.
+func normalizeReadableDate(readableDate string) string {
+	readableDate = strings.NewReplacer(
+		"\u202f", " ",  // narrow no-break space (Android 14+)
+		"\u00a0", " ",  // no-break space
+		"a.m.", "AM",
+		"p.m.", "PM",
+		"오전", "AM",
+		"오후", "PM",
+		"午前", "AM",
+		"午後", "PM",
+		"上午", "AM",
+		"下午", "PM",
+	).Replace(strings.TrimSpace(readableDate))
+	return strings.ToUpper(strings.Join(strings.Fields(readableDate), " "))
+}
+
+// ReadableDateOffset determines the UTC offset of the device when a record was backed up by comparing the local time
+// in its readable_date attribute to the UTC time in its date attribute. The offset is rounded to the nearest 15
+// minutes, as readable_date only has a resolution of seconds.
+func ReadableDateOffset(date AndroidTS, readableDate string) (time.Duration, error) {
+	utc := date.Time()
+	if utc.IsZero() {
+		return 0, fmt.Errorf("invalid date %q", string(date))
+	}
+
+	normalized := normalizeReadableDate(readableDate)
+	for _, layout := range readableDateLayouts {
+		local, err := time.Parse(layout, normalized)
+		if err != nil {
+			continue
+		}
+
+		offset := local.Sub(utc.Truncate(time.Second)).Round(15 * time.Minute)
+		if offset >= -maxUTCOffset && offset <= maxUTCOffset {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnrecognizedReadableDate, readableDate)
+}
+
+// FormatUTCOffset formats a UTC offset as e.g. "+05:30" or "-06:00".
+func FormatUTCOffset(offset time.Duration) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, int(offset.Hours()), int(offset.Minutes())%60)
+}
+
+// UTCOffset method for SMS type determines the UTC offset of the device when the message was backed up.
+func (s SMS) UTCOffset() (time.Duration, error) {
+	return ReadableDateOffset(s.Date, s.ReadableDate)
+}
+
+// UTCOffset method for MMS type determines the UTC offset of the device when the message was backed up.
+func (m MMS) UTCOffset() (time.Duration, error) {
+	return ReadableDateOffset(m.Date, m.ReadableDate)
+}
+
+// UTCOffset method for Call type determines the UTC offset of the device when the call was backed up.
+func (c Call) UTCOffset() (time.Duration, error) {
+	return ReadableDateOffset(c.Date, c.ReadableDate)
+}
+
+// formatRecordOffset formats the result of a UTCOffset method for output, leaving it blank if it could not be
+// determined.
+func formatRecordOffset(offset time.Duration, err error) string {
+	if err != nil {
+		return ""
+	}
+	return FormatUTCOffset(offset)
+}
+
+// offsetRun is a run of records of one type, added consecutively, sharing the same UTC offset.
+type offsetRun struct {
+	offset      time.Duration
+	start       AndroidTS
+	end         AndroidTS
+	startMillis int64
+	endMillis   int64
+	records     int
+}
+
+// OffsetPeriod is a run of consecutive records (ordered by date) sharing the same UTC offset.
+type OffsetPeriod struct {
+	Offset  time.Duration
+	Start   AndroidTS  // date of the first record with this offset
+	End     AndroidTS  // date of the last record with this offset
+	Records int
+}
+
+// TimezoneReport collects the UTC offsets of records from one or more backups to reveal when the device changed time
+// zones, e.g. due to travel, daylight saving time, or the clock being changed manually.
+//
+// Rather than keeping every record, the report keeps a run of records for each change of UTC offset among the records
+// of each type, so its size does not grow with the number of records. Records of each type are expected in
+// chronological order (or reverse chronological order), as they are written by the app.
+type TimezoneReport struct {
+	runs map[string][]offsetRun // by record type
+
+	// Unrecognized is the number of records whose UTC offset could not be determined.
+	Unrecognized int
+}
+
+// Add adds the record with the given date and readable_date attributes to the report.
+func (r *TimezoneReport) Add(date AndroidTS, readableDate string) {
+	r.add("", date, readableDate)
+}
+
+// AddRecord adds an SMS, MMS, or call returned by Parser.Next to the report. Other records are ignored.
+func (r *TimezoneReport) AddRecord(record Record) {
+	switch rec := record.(type) {
+	case *SMS:
+		r.add("sms", rec.Date, rec.ReadableDate)
+	case *MMS:
+		r.add("mms", rec.Date, rec.ReadableDate)
+	case *Call:
+		r.add("call", rec.Date, rec.ReadableDate)
+	}
+}
+
+// add adds a record of the given type to the report, extending the last run of that type if it has the same UTC
+// offset.
+func (r *TimezoneReport) add(recordType string, date AndroidTS, readableDate string) {
+	offset, err := ReadableDateOffset(date, readableDate)
+	if err != nil {
+		r.Unrecognized++
+		return
+	}
+	if r.runs == nil {
+		r.runs = make(map[string][]offsetRun)
+	}
+
+	millis := date.Time().UnixMilli()
+	runs := r.runs[recordType]
+	if n := len(runs); n > 0 && runs[n-1].offset == offset {
+		run := &runs[n-1]
+		if millis < run.startMillis {
+			run.start, run.startMillis = date, millis
+		}
+		if millis >= run.endMillis {
+			run.end, run.endMillis = date, millis
+		}
+		run.records++
+		return
+	}
+	r.runs[recordType] = append(runs, offsetRun{
+		offset:      offset,
+		start:       date,
+		end:         date,
+		startMillis: millis,
+		endMillis:   millis,
+		records:     1,
+	})
+}
+
+// AddMessages adds all SMS and MMS messages to the report.
+func (r *TimezoneReport) AddMessages(m *Messages) {
+	for _, sms := range m.SMS {
+		r.add("sms", sms.Date, sms.ReadableDate)
+	}
+	for _, mms := range m.MMS {
+		r.add("mms", mms.Date, mms.ReadableDate)
+	}
+}
+
+// AddCalls adds all calls to the report.
+func (r *TimezoneReport) AddCalls(c *Calls) {
+	for _, call := range c.Calls {
+		r.add("call", call.Date, call.ReadableDate)
+	}
+}
+
+// Periods returns the periods of time during which the device had the same UTC offset, in chronological order. Each
+// period after the first marks a change of time zone or clock. The runs of each record type are merged by date, so
+// runs of different types that overlap with the same offset form a single period.
+func (r *TimezoneReport) Periods() []OffsetPeriod {
+	var runs []offsetRun
+	for _, recordType := range []string{"", "sms", "mms", "call"} {
+		runs = append(runs, r.runs[recordType]...)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].startMillis < runs[j].startMillis
+	})
+
+	var periods []OffsetPeriod
+	var endMillis int64
+	for _, run := range runs {
+		if n := len(periods); n > 0 && periods[n-1].Offset == run.offset {
+			if run.endMillis > endMillis {
+				periods[n-1].End, endMillis = run.end, run.endMillis
+			}
+			periods[n-1].Records += run.records
+			continue
+		}
+		periods = append(periods, OffsetPeriod{
+			Offset:  run.offset,
+			Start:   run.start,
+			End:     run.end,
+			Records: run.records,
+		})
+		endMillis = run.endMillis
+	}
+	return periods
+}
+
+// GenerateTimezoneOutput outputs a tab-delimited file named "timezones.tsv" listing the periods of time during which
+// the device had the same UTC offset.
+func GenerateTimezoneOutput(r *TimezoneReport, outputDir string, opts ...OutputOption) error {
+	cfg := newOutputConfig(opts)
+
+	tzOutput, err := os.Create(filepath.Join(outputDir, "timezones.tsv"))
+	if err != nil {
+		return fmt.Errorf("Unable to create file: timezones.tsv\n%q", err)
+	}
+	defer tzOutput.Close()
+
+	// print header row
+	headers := []string{
+		"Period #",
+		"UTC Offset",
+		"Start",
+		"End",
+		"Record Count",
+	}
+	fmt.Fprintf(tzOutput, "%s\n", strings.Join(headers, "\t"))
+
+	// iterate over periods
+	for i, period := range r.Periods() {
+		row := []string{
+			strconv.Itoa(i),
+			FormatUTCOffset(period.Offset),
+			cfg.formatTime(period.Start),
+			cfg.formatTime(period.End),
+			strconv.Itoa(period.Records),
+		}
+		fmt.Fprintf(tzOutput, "%s\n", strings.Join(row, "\t"))
+	}
+
+	return nil
+}
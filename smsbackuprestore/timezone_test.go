@@ -0,0 +1,152 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testRecordDate returns the date and readable_date attributes of a record backed up at t, as the app writes them in
+// the time zone of t.
+func testRecordDate(t time.Time) (AndroidTS, string) {
+	return AndroidTS(strconv.FormatInt(t.UnixMilli(), 10)), t.Format("Jan 2, 2006 3:04:05 PM")
+}
+
+func TestReadableDateOffset(t *testing.T) {
+	for _, test := range []struct {
+		date         AndroidTS
+		readableDate string
+		want         time.Duration
+		err          bool
+	}{
+		{"1704067200000", "Dec 31, 2023 6:00:00 PM", -6 * time.Hour, false},
+		{"1704067200000", "Dec 31, 2023, 6:00:00 PM", -6 * time.Hour, false},
+		{"1704067200123", "1 Jan 2024 05:30:00", 5*time.Hour + 30*time.Minute, false},
+		{"1704067200000", "2024-01-01 00:00:00", 0, false},
+		{"1704067200000", "yesterday", 0, true},
+		{"null", "Dec 31, 2023 6:00:00 PM", 0, true},
+	} {
+		got, err := ReadableDateOffset(test.date, test.readableDate)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("%s, %q: got %v, %v, want %v (error %t)", test.date, test.readableDate, got, err, test.want,
+				test.err)
+		}
+	}
+}
+
+func TestTimezoneReportPeriods(t *testing.T) {
+	chicago := time.FixedZone("CST", -6*60*60)
+	chicagoDST := time.FixedZone("CDT", -5*60*60)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	// SMS and MMS are each in chronological order but interleaved with each other, as in a backup
+	var r TimezoneReport
+	add := func(record Record) { r.AddRecord(record) }
+	for day := 1; day <= 3; day++ {
+		date, readable := testRecordDate(time.Date(2024, 1, day, 12, 0, 0, 0, chicago))
+		add(&SMS{Date: date, ReadableDate: readable})
+	}
+	for day := 10; day <= 11; day++ {
+		date, readable := testRecordDate(time.Date(2024, 7, day, 12, 0, 0, 0, chicagoDST))
+		add(&SMS{Date: date, ReadableDate: readable})
+	}
+	date, readable := testRecordDate(time.Date(2024, 1, 2, 18, 0, 0, 0, chicago))
+	add(&MMS{Date: date, ReadableDate: readable})
+	date, readable = testRecordDate(time.Date(2024, 7, 20, 9, 0, 0, 0, tokyo))
+	add(&MMS{Date: date, ReadableDate: readable})
+	date, readable = testRecordDate(time.Date(2024, 7, 12, 8, 0, 0, 0, chicagoDST))
+	add(&Call{Date: date, ReadableDate: readable})
+	add(&SMS{Date: "1704067200000", ReadableDate: "unrecognized"})
+	add(&Header{})
+
+	periods := r.Periods()
+	want := []struct {
+		offset  time.Duration
+		start   time.Time
+		end     time.Time
+		records int
+	}{
+		{-6 * time.Hour, time.Date(2024, 1, 1, 12, 0, 0, 0, chicago), time.Date(2024, 1, 3, 12, 0, 0, 0, chicago), 4},
+		{-5 * time.Hour, time.Date(2024, 7, 10, 12, 0, 0, 0, chicagoDST),
+			time.Date(2024, 7, 12, 8, 0, 0, 0, chicagoDST), 3},
+		{9 * time.Hour, time.Date(2024, 7, 20, 9, 0, 0, 0, tokyo), time.Date(2024, 7, 20, 9, 0, 0, 0, tokyo), 1},
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("got %d periods, want %d: %+v", len(periods), len(want), periods)
+	}
+	for i, w := range want {
+		p := periods[i]
+		if p.Offset != w.offset || !p.Start.Time().Equal(w.start) || !p.End.Time().Equal(w.end) ||
+			p.Records != w.records {
+			t.Errorf("period %d: got %+v, want %+v", i, p, w)
+		}
+	}
+	if r.Unrecognized != 1 {
+		t.Errorf("got %d unrecognized, want 1", r.Unrecognized)
+	}
+}
+
+func TestTimezoneReportSize(t *testing.T) {
+	var r TimezoneReport
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10000; i++ {
+		// in reverse chronological order
+		date, readable := testRecordDate(start.Add(time.Duration(-i) * time.Hour))
+		r.AddRecord(&SMS{Date: date, ReadableDate: readable})
+	}
+	if n := len(r.runs["sms"]); n != 1 {
+		t.Errorf("got %d runs for records with the same offset, want 1", n)
+	}
+	periods := r.Periods()
+	if len(periods) != 1 || periods[0].Records != 10000 || !periods[0].End.Time().Equal(start) ||
+		!periods[0].Start.Time().Equal(start.Add(-9999*time.Hour)) {
+		t.Errorf("got %+v", periods)
+	}
+}
+
+func TestGenerateTimezoneOutput(t *testing.T) {
+	var r TimezoneReport
+	r.AddMessages(&Messages{SMS: []SMS{{Date: "1704067200000", ReadableDate: "Dec 31, 2023 6:00:00 PM"}}})
+	r.AddCalls(&Calls{Calls: []Call{{Date: "1719792000000", ReadableDate: "Jun 30, 2024 7:00:00 PM"}}})
+
+	dir := t.TempDir()
+	if err := GenerateTimezoneOutput(&r, dir, WithTimeLayout(TimeLayoutISO8601)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "timezones.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Period #\tUTC Offset\tStart\tEnd\tRecord Count\n" +
+		"0\t-06:00\t2024-01-01T00:00:00.000Z\t2024-01-01T00:00:00.000Z\t1\n" +
+		"1\t-05:00\t2024-07-01T00:00:00.000Z\t2024-07-01T00:00:00.000Z\t1\n"
+	if got := string(b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
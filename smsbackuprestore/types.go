@@ -34,6 +34,7 @@ import (
 	"encoding/base64"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 type PhoneNumber 		string
@@ -43,6 +44,32 @@ type AndroidTS 			string
 type BoolValue			int
 type ReadStatus			int
 type CallType			int
+type MessageBox			int
+type MMSMessageType		int
+type MMSYesNo			int
+type MMSReadStatus		int
+type MMSPriority		int
+type MMSResponseStatus	int
+type Charset			int
+type AddressType		int
+type MMSDirection		int
+type CallPresentation	int
+type CallFeatures		int
+
+// MMS address types
+const (
+	AddressTypeBcc		AddressType = 129
+	AddressTypeCc		AddressType = 130
+	AddressTypeFrom		AddressType = 137
+	AddressTypeTo		AddressType = 151
+)
+
+// MMS directions
+const (
+	DirectionUnknown	MMSDirection = 0
+	DirectionReceived	MMSDirection = 1
+	DirectionSent		MMSDirection = 2
+)
 
 type Messages struct {
 	XMLName 			xml.Name 		`xml:"smses"`
@@ -51,6 +78,7 @@ type Messages struct {
 	BackupDate			AndroidTS		`xml:"backup_date,string,attr"`
 	SMS 				[]SMS			`xml:"sms"`
 	MMS 				[]MMS			`xml:"mms"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 }
 
 type SMS struct {
@@ -68,6 +96,15 @@ type SMS struct {
 	DateSent			AndroidTS		`xml:"date_sent,string,attr"`
 	ReadableDate		string			`xml:"readable_date,attr"`
 	ContactName			string			`xml:"contact_name,attr"`
+	TypeOfAddress		string			`xml:"toa,attr"`
+	ServiceCenterTOA	string			`xml:"sc_toa,attr"`
+	SubscriptionID		string			`xml:"sub_id,attr"`
+	ThreadID			string			`xml:"thread_id,attr"`
+	Seen				BoolValue		`xml:"seen,string,attr"`
+	ErrorCode			string			`xml:"error_code,attr"`
+	SimSlot				string			`xml:"sim_slot,attr"`
+	SimIMSI				string			`xml:"sim_imsi,attr"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 }
 
 type MMS struct {
@@ -84,6 +121,22 @@ type MMS struct {
 	Address				PhoneNumber		`xml:"address,string,attr"`
 	MessageClassifier	string			`xml:"m_cls,attr"`
 	MessageSize			string			`xml:"m_size,attr"`
+	MessageBox			MessageBox		`xml:"msg_box,attr"`
+	MessageType			MMSMessageType	`xml:"m_type,attr"`
+	MessageID			string			`xml:"m_id,attr"`
+	Subject				string			`xml:"sub,attr"`
+	SubjectCharset		Charset			`xml:"sub_cs,attr"`
+	ContentType			string			`xml:"ct_t,attr"`
+	ContentLocation		string			`xml:"ct_l,attr"`
+	TransactionID		string			`xml:"tr_id,attr"`
+	DeliveryReport		MMSYesNo		`xml:"d_rpt,attr"`
+	ReadReport			MMSYesNo		`xml:"rr,attr"`
+	ReadStatus			MMSReadStatus	`xml:"read_status,attr"`
+	Expiry				string			`xml:"exp,attr"`  // seconds
+	Priority			MMSPriority		`xml:"pri,attr"`
+	ResponseStatus		MMSResponseStatus	`xml:"resp_st,attr"`
+	SubscriptionID		string			`xml:"sub_id,attr"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 	Parts				[]Part			`xml:"parts>part"`
 	Addresses			[]Address		`xml:"addrs>addr"`
 }
@@ -96,11 +149,20 @@ type Part struct {
 	ContentDisplay		string			`xml:"cd,attr"`
 	Text				string			`xml:"text,attr"`
 	Base64Data			string			`xml:"data,attr"`
+	Sequence			string			`xml:"seq,attr"`
+	Charset				Charset			`xml:"chset,attr"`
+	ContentID			string			`xml:"cid,attr"`
+	ContentLocation		string			`xml:"cl,attr"`
+	ContentTypeStart	string			`xml:"ctt_s,attr"`
+	ContentTypeType		string			`xml:"ctt_t,attr"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 }
 
 type Address struct {
 	XMLName 			xml.Name 		`xml:"addr"`
 	Address				PhoneNumber		`xml:"address,string,attr"`
+	Type				AddressType		`xml:"type,attr"`
+	Charset				Charset			`xml:"charset,attr"`
 }
 
 type Calls struct {
@@ -109,6 +171,7 @@ type Calls struct {
 	BackupSet			string			`xml:"backup_set,attr"`
 	BackupDate			AndroidTS		`xml:"backup_date,string,attr"`
 	Calls				[]Call			`xml:"call"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 }
 
 type Call struct {
@@ -119,18 +182,47 @@ type Call struct {
 	Type				CallType		`xml:"type,string,attr"`
 	ReadableDate		string			`xml:"readable_date,attr"`
 	ContactName			string			`xml:"contact_name,attr"`
+	Presentation		CallPresentation	`xml:"presentation,attr"`
+	SubscriptionID		string			`xml:"subscription_id,attr"`
+	SubscriptionComponentName	string	`xml:"subscription_component_name,attr"`
+	PostDialDigits		string			`xml:"post_dial_digits,attr"`
+	Features			CallFeatures	`xml:"features,attr"`
+	OtherAttributes		[]xml.Attr		`xml:",any,attr"`  // attributes not recognized by this parser
 }
 
+// Names of enumerated values, shared by the String methods of their types and by the import of delimited output (see
+// ImportTSV).
+var (
+	// see http://synctech.com.au/fields-in-xml-backup-files/
+	// type – 1 = Received, 2 = Sent, 3 = Draft, 4 = Outbox, 5 = Failed, 6 = Queued
+	smsMessageTypeNames = []string{"Received", "Sent", "Draft", "Outbox", "Failed", "Queued"}
+
+	// status – None = -1, Complete = 0, Pending = 32, Failed = 64
+	smsStatusNames = map[int]string{-1: "None", 0: "Complete", 32: "Pending", 64: "Failed"}
+
+	// read – Read Message = 1, Unread Message = 0
+	readStatusNames = map[int]string{0: "Unread", 1: "Read"}
+
+	boolValueNames = map[int]string{0: "False", 1: "True"}
+
+	// see http://synctech.com.au/wp-content/uploads/2017/12/calls.xsl
+	// Type: 1 = Incoming, 2 = Outgoing, 3 = Missed, 4 = Voicemail, 5 = Rejected, 6 = Refused List
+	callTypeNames = []string{"Incoming", "Outgoing", "Missed", "Voicemail", "Rejected", "Refused List"}
+
+	// see https://developer.android.com/reference/android/provider/CallLog.Calls#NUMBER_PRESENTATION
+	callPresentationNames = []string{"Allowed", "Restricted", "Unknown", "Payphone"}
+
+	// see https://developer.android.com/reference/android/provider/CallLog.Calls#FEATURES (bit 0 first)
+	callFeatureNames = []string{"Video", "Pulled Externally", "HD Call", "Wi-Fi", "Assisted Dialing", "RTT", "VoLTE"}
+)
+
 // String method for SMSMessageType type converts integer to human-readable message type
 //
 // See http://synctech.com.au/fields-in-xml-backup-files/
 //     Type: 1 = Received, 2 = Sent, 3 = Draft, 4 = Outbox, 5 = Failed, 6 = Queued
 func (smt SMSMessageType) String() string {
-	// see http://synctech.com.au/fields-in-xml-backup-files/
-	// type – 1 = Received, 2 = Sent, 3 = Draft, 4 = Outbox, 5 = Failed, 6 = Queued
-	smsMsgType := []string{"Received", "Sent", "Draft", "Outbox", "Failed", "Queued"}
-	if smt > 0 && smt < 7 {
-		return smsMsgType[smt-1]
+	if smt > 0 && int(smt) <= len(smsMessageTypeNames) {
+		return smsMessageTypeNames[smt-1]
 	}
 	return strconv.Itoa(int(smt))  // ignoring error
 }
@@ -140,20 +232,10 @@ func (smt SMSMessageType) String() string {
 // See http://synctech.com.au/fields-in-xml-backup-files/
 //     Status: None = -1, Complete = 0, Pending = 32, Failed = 64
 func (ss SMSStatus) String() string {
-	// see http://synctech.com.au/fields-in-xml-backup-files/
-	// status – None = -1, Complete = 0, Pending = 32, Failed = 64
-	switch ss {
-	case -1:
-		return "None"
-	case 0:
-		return "Complete"
-	case 32:
-		return "Pending"
-	case 64:
-		return "Failed"
-	default:
-		return ""
+	if name, ok := smsStatusNames[int(ss)]; ok {
+		return name
 	}
+	return strconv.Itoa(int(ss))
 }
 
 // String method for CallType type converts integer to human-readable status
@@ -161,47 +243,397 @@ func (ss SMSStatus) String() string {
 // See http://synctech.com.au/wp-content/uploads/2017/12/calls.xsl
 //    Type: 1 = Incoming, 2 = Outgoing, 3 = Missed, 4 = Voicemail, 5 = Rejected, 6 = Refused List
 func (ct CallType) String() string {
-	// see http://synctech.com.au/wp-content/uploads/2017/12/calls.xsl
-	// Type: 1 = Incoming, 2 = Outgoing, 3 = Missed, 4 = Voicemail, 5 = Rejected, 6 = Refused List
-	callType := []string{"Incoming", "Outgoing", "Missed", "Voicemail", "Rejected", "Refused List"}
-	if ct > 0 && ct < 7 {
-		return callType[ct-1]
+	if ct > 0 && int(ct) <= len(callTypeNames) {
+		return callTypeNames[ct-1]
 	}
 	return strconv.Itoa(int(ct))  // ignoring error
 }
 
+// String method for CallPresentation type converts integer to human-readable number presentation
+//
+// See https://developer.android.com/reference/android/provider/CallLog.Calls#NUMBER_PRESENTATION
+//    Presentation: 1 = Allowed, 2 = Restricted, 3 = Unknown, 4 = Payphone
+func (cp CallPresentation) String() string {
+	if cp > 0 && int(cp) <= len(callPresentationNames) {
+		return callPresentationNames[cp-1]
+	}
+	return nullableIntString(int(cp))
+}
+
+// String method for CallFeatures type converts bit mask to semicolon-delimited list of human-readable call features
+//
+// See https://developer.android.com/reference/android/provider/CallLog.Calls#FEATURES
+//    Features: 1 = Video, 2 = Pulled Externally, 4 = HD Call, 8 = Wi-Fi, 16 = Assisted Dialing, 32 = RTT,
+//    64 = VoLTE
+func (cf CallFeatures) String() string {
+	var features []string
+	for i, feature := range callFeatureNames {
+		if cf&(1<<uint(i)) != 0 {
+			features = append(features, feature)
+		}
+	}
+	if unknown := cf &^ (1<<uint(len(callFeatureNames)) - 1); unknown != 0 {
+		features = append(features, strconv.Itoa(int(unknown)))
+	}
+	return strings.Join(features, ";")
+}
+
+// UnmarshalXMLAttr method for CallPresentation type parses the presentation attribute, which may be "null".
+func (cp *CallPresentation) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*cp = CallPresentation(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for CallFeatures type parses the features attribute, which may be "null".
+func (cf *CallFeatures) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*cf = CallFeatures(i)
+	return err
+}
+
 // String method for ReadStatus type converts integer/boolean to human-readable read status
 //
 // See http://synctech.com.au/fields-in-xml-backup-files/
 //     Read: Read Message = 1, Unread Message = 0
 func (rs ReadStatus) String() string {
-	// see http://synctech.com.au/fields-in-xml-backup-files/
-	// read – Read Message = 1, Unread Message = 0
-	if rs == 0 {
-		return "Unread"
-	} else if rs == 1 {
+	if name, ok := readStatusNames[int(rs)]; ok {
+		return name
+	}
+	return strconv.Itoa(int(rs))
+}
+
+// String method for MessageBox type converts integer to human-readable MMS message box
+//
+// See https://developer.android.com/reference/android/provider/Telephony.BaseMmsColumns#MESSAGE_BOX
+//     Message box: 1 = Inbox, 2 = Sent, 3 = Drafts, 4 = Outbox, 5 = Failed
+func (mb MessageBox) String() string {
+	messageBox := []string{"Inbox", "Sent", "Drafts", "Outbox", "Failed"}
+	if mb > 0 && mb < 6 {
+		return messageBox[mb-1]
+	}
+	return nullableIntString(int(mb))
+}
+
+// String method for MMSMessageType type converts integer to human-readable MMS PDU message type
+//
+// See OMA-TS-MMS_ENC (X-Mms-Message-Type)
+//     Message type: 128 = Send Request, 129 = Send Confirmation, 130 = Notification, 131 = Notification Response,
+//     132 = Retrieve Confirmation, 133 = Acknowledge, 134 = Delivery Report, 135 = Read Report (Recipient),
+//     136 = Read Report (Originator), 137 = Forward Request, 138 = Forward Confirmation
+func (mt MMSMessageType) String() string {
+	mmsMsgType := []string{"Send Request", "Send Confirmation", "Notification", "Notification Response",
+		"Retrieve Confirmation", "Acknowledge", "Delivery Report", "Read Report (Recipient)",
+		"Read Report (Originator)", "Forward Request", "Forward Confirmation"}
+	if mt >= 128 && mt < 139 {
+		return mmsMsgType[mt-128]
+	}
+	return nullableIntString(int(mt))
+}
+
+// String method for MMSYesNo type converts integer to human-readable yes/no value of MMS PDU headers such as
+// delivery report (d_rpt) and read report (rr)
+//
+// See OMA-TS-MMS_ENC
+//     Yes = 128, No = 129
+func (yn MMSYesNo) String() string {
+	switch yn {
+	case 128:
+		return "Yes"
+	case 129:
+		return "No"
+	default:
+		return nullableIntString(int(yn))
+	}
+}
+
+// String method for MMSReadStatus type converts integer to human-readable MMS read status
+//
+// See OMA-TS-MMS_ENC (X-Mms-Read-Status)
+//     Read status: 128 = Read, 129 = Deleted Without Being Read
+func (rs MMSReadStatus) String() string {
+	switch rs {
+	case 128:
 		return "Read"
+	case 129:
+		return "Deleted Without Being Read"
+	default:
+		return nullableIntString(int(rs))
+	}
+}
+
+// String method for MMSPriority type converts integer to human-readable MMS priority
+//
+// See OMA-TS-MMS_ENC (X-Mms-Priority)
+//     Priority: 128 = Low, 129 = Normal, 130 = High
+func (p MMSPriority) String() string {
+	priority := []string{"Low", "Normal", "High"}
+	if p >= 128 && p < 131 {
+		return priority[p-128]
+	}
+	return nullableIntString(int(p))
+}
+
+// String method for MMSResponseStatus type converts integer to human-readable MMS response status
+//
+// See OMA-TS-MMS_ENC (X-Mms-Response-Status)
+//     Response status: 128 = Ok, 129-136 = Error, 192-196 = Transient Error, 224-235 = Permanent Error
+func (rs MMSResponseStatus) String() string {
+	switch rs {
+	case 128:
+		return "Ok"
+	case 129:
+		return "Error Unspecified"
+	case 130:
+		return "Error Service Denied"
+	case 131:
+		return "Error Message Format Corrupt"
+	case 132:
+		return "Error Sending Address Unresolved"
+	case 133:
+		return "Error Message Not Found"
+	case 134:
+		return "Error Network Problem"
+	case 135:
+		return "Error Content Not Accepted"
+	case 136:
+		return "Error Unsupported Message"
+	case 192:
+		return "Transient Failure"
+	case 193:
+		return "Transient Sending Address Unresolved"
+	case 194:
+		return "Transient Message Not Found"
+	case 195:
+		return "Transient Network Problem"
+	case 196:
+		return "Transient Partial Success"
+	case 224:
+		return "Permanent Failure"
+	case 225:
+		return "Permanent Service Denied"
+	case 226:
+		return "Permanent Message Format Corrupt"
+	case 227:
+		return "Permanent Sending Address Unresolved"
+	case 228:
+		return "Permanent Message Not Found"
+	case 229:
+		return "Permanent Content Not Accepted"
+	case 230:
+		return "Permanent Reply Charging Limitations Not Met"
+	case 231:
+		return "Permanent Reply Charging Request Not Accepted"
+	case 232:
+		return "Permanent Reply Charging Forwarding Denied"
+	case 233:
+		return "Permanent Reply Charging Not Supported"
+	case 234:
+		return "Permanent Address Hiding Not Supported"
+	case 235:
+		return "Permanent Lack Of Prepaid"
+	default:
+		return nullableIntString(int(rs))
 	}
-	return ""
+}
+
+// String method for Charset type converts IANA MIBenum integer to character set name
+//
+// See https://www.iana.org/assignments/character-sets/character-sets.xhtml
+func (cs Charset) String() string {
+	switch cs {
+	case 3:
+		return "US-ASCII"
+	case 4:
+		return "ISO-8859-1"
+	case 5:
+		return "ISO-8859-2"
+	case 6:
+		return "ISO-8859-3"
+	case 7:
+		return "ISO-8859-4"
+	case 8:
+		return "ISO-8859-5"
+	case 9:
+		return "ISO-8859-6"
+	case 10:
+		return "ISO-8859-7"
+	case 11:
+		return "ISO-8859-8"
+	case 12:
+		return "ISO-8859-9"
+	case 17:
+		return "Shift_JIS"
+	case 18:
+		return "EUC-JP"
+	case 38:
+		return "EUC-KR"
+	case 106:
+		return "UTF-8"
+	case 109:
+		return "ISO-8859-13"
+	case 111:
+		return "ISO-8859-15"
+	case 113:
+		return "GBK"
+	case 114:
+		return "GB18030"
+	case 1000:
+		return "ISO-10646-UCS-2"
+	case 1013:
+		return "UTF-16BE"
+	case 1014:
+		return "UTF-16LE"
+	case 1015:
+		return "UTF-16"
+	case 2025:
+		return "GB2312"
+	case 2026:
+		return "Big5"
+	case 2252:
+		return "windows-1252"
+	default:
+		return nullableIntString(int(cs))
+	}
+}
+
+// String method for AddressType type converts integer to human-readable role of an MMS address
+//
+// See OMA-TS-MMS_ENC (header field names)
+//     Type: 137 = From, 151 = To, 130 = Cc, 129 = Bcc
+func (at AddressType) String() string {
+	switch at {
+	case AddressTypeFrom:
+		return "From"
+	case AddressTypeTo:
+		return "To"
+	case AddressTypeCc:
+		return "Cc"
+	case AddressTypeBcc:
+		return "Bcc"
+	default:
+		return nullableIntString(int(at))
+	}
+}
+
+// String method for MMSDirection type converts integer to human-readable direction, matching the names used for
+// SMSMessageType
+//
+//     Direction: 1 = Received, 2 = Sent
+func (d MMSDirection) String() string {
+	switch d {
+	case DirectionReceived:
+		return "Received"
+	case DirectionSent:
+		return "Sent"
+	default:
+		return "Unknown"
+	}
+}
+
+// UnmarshalXMLAttr method for AddressType type parses the type attribute of an MMS address, which may be "null".
+func (at *AddressType) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*at = AddressType(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MessageBox type parses the msg_box attribute, which may be "null".
+func (mb *MessageBox) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*mb = MessageBox(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MMSMessageType type parses the m_type attribute, which may be "null".
+func (mt *MMSMessageType) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*mt = MMSMessageType(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MMSYesNo type parses yes/no attributes such as d_rpt and rr, which may be "null".
+func (yn *MMSYesNo) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*yn = MMSYesNo(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MMSReadStatus type parses the read_status attribute, which may be "null".
+func (rs *MMSReadStatus) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*rs = MMSReadStatus(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MMSPriority type parses the pri attribute, which may be "null".
+func (p *MMSPriority) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*p = MMSPriority(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for MMSResponseStatus type parses the resp_st attribute, which may be "null".
+func (rs *MMSResponseStatus) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*rs = MMSResponseStatus(i)
+	return err
+}
+
+// UnmarshalXMLAttr method for Charset type parses charset attributes such as sub_cs, which may be "null".
+func (cs *Charset) UnmarshalXMLAttr(attr xml.Attr) error {
+	i, err := parseNullableInt(attr.Value)
+	*cs = Charset(i)
+	return err
 }
 
 // String method for AndroidTS type converts string representing milliseconds since the Unix epoch into a
 // human-readable timestamp in UTC time zone.
 func (timestamp AndroidTS) String() string {
+	return timestamp.Format(time.UTC, TimeLayoutDefault)
+}
+
+// Time method for AndroidTS type converts string representing milliseconds since the Unix epoch into a time.Time in
+// UTC, keeping the milliseconds. The zero time.Time is returned if the timestamp is not a valid integer.
+func (timestamp AndroidTS) Time() time.Time {
 	i, err := strconv.ParseInt(string(timestamp), 10, 64)
 	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(i).UTC()
+}
+
+// Format method for AndroidTS type formats the timestamp in the given time zone using the given layout (see
+// time.Time.Format). The timestamp is returned as-is if it is not a valid integer.
+func (timestamp AndroidTS) Format(loc *time.Location, layout string) string {
+	t := timestamp.Time()
+	if t.IsZero() {
 		return string(timestamp)
 	}
-	t := time.Unix(i/1000, 0).UTC()
-	return t.String()
+	return t.In(loc).Format(layout)
+}
+
+// MarshalText method for AndroidTS type returns the timestamp as milliseconds since the Unix epoch, as written by the
+// SMS Backup & Restore app.
+func (timestamp AndroidTS) MarshalText() ([]byte, error) {
+	return []byte(timestamp), nil
+}
+
+// UnmarshalText method for AndroidTS type accepts milliseconds since the Unix epoch as written by the SMS Backup &
+// Restore app, or an RFC 3339 timestamp, which is converted to milliseconds. Any other value is kept as-is.
+func (timestamp *AndroidTS) UnmarshalText(text []byte) error {
+	if t, err := time.Parse(time.RFC3339Nano, string(text)); err == nil {
+		*timestamp = AndroidTS(strconv.FormatInt(t.UnixMilli(), 10))
+		return nil
+	}
+	*timestamp = AndroidTS(text)
+	return nil
 }
 
 // String method for BoolValue type converts integer/boolean into human-readable boolean value (true/false).
 func (bv BoolValue) String() string {
-	if bv == 0 {
-		return "False"
-	} else if bv == 1 {
-		return "True"
+	if name, ok := boolValueNames[int(bv)]; ok {
+		return name
 	}
 	return strconv.Itoa(int(bv))
 }
@@ -211,9 +643,61 @@ func (p PhoneNumber) String() string {
 	return NormalizePhoneNumber(string(p))
 }
 
+// Sender method for MMS type returns the address of the sender (the address with type From), or an empty PhoneNumber
+// if there is none.
+func (m MMS) Sender() PhoneNumber {
+	for _, addr := range m.Addresses {
+		if addr.Type == AddressTypeFrom {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// Recipients method for MMS type returns the To, Cc, and Bcc addresses of the message.
+func (m MMS) Recipients() []Address {
+	var recipients []Address
+	for _, addr := range m.Addresses {
+		if addr.Type == AddressTypeTo || addr.Type == AddressTypeCc || addr.Type == AddressTypeBcc {
+			recipients = append(recipients, addr)
+		}
+	}
+	return recipients
+}
+
+// Direction method for MMS type determines whether the message was sent or received.
+//
+// The direction is derived from the message box (msg_box), falling back to the PDU message type (m_type) and then to
+// the From address, which Android records as "insert-address-token" for messages sent from the device. Messages in
+// the drafts, outbox, and failed boxes are considered sent, as they were composed on the device.
+func (m MMS) Direction() MMSDirection {
+	switch m.MessageBox {
+	case 1:  // inbox
+		return DirectionReceived
+	case 2, 3, 4, 5:  // sent, drafts, outbox, failed
+		return DirectionSent
+	}
+
+	switch m.MessageType {
+	case 128, 137:  // send request, forward request
+		return DirectionSent
+	case 130, 132:  // notification, retrieve confirmation
+		return DirectionReceived
+	}
+
+	if sender := m.Sender(); sender == "insert-address-token" {
+		return DirectionSent
+	} else if sender != "" {
+		return DirectionReceived
+	}
+	return DirectionUnknown
+}
+
 // ImageFileName method for Part type determines file name of base64-encoded image given Part and MMS and Part indices.
+// The name of the part is reduced by SafeFileName, so the file name never refers to another directory; if nothing is
+// left of the name, the file is named after the indices alone.
 func (p Part) ImageFileName(mmsIndex int, partIndex int) string {
-	ext := GetFileExtensionFromContentType(p.ContentType)
+	ext := SafeFileName(GetFileExtensionFromContentType(p.ContentType))
 	if ext == "jpeg" {
 		ext = "jpg"
 	}
@@ -221,9 +705,18 @@ func (p Part) ImageFileName(mmsIndex int, partIndex int) string {
 	if fileName == "null" {
 		fileName = p.FileName
 	}
+	if fileName = SafeFileName(fileName); fileName == "" {
+		return fmt.Sprintf("%d-%d.%s", mmsIndex, partIndex, ext)
+	}
 	return fmt.Sprintf("%s_%d-%d.%s", fileName, mmsIndex, partIndex, ext)
 }
 
+// HasData method for Part type reports whether the part contains base64-encoded data (e.g. an image or video), rather
+// than only text.
+func (p Part) HasData() bool {
+	return p.Base64Data != "" && p.Base64Data != "null"
+}
+
 // DecodeAndWriteImage decodes and writes base64-encoded image to file output path specified as parameter.
 func (p Part) DecodeAndWriteImage(outputPath string) error {
 	// decode base64 image string as byte slice
@@ -243,49 +736,45 @@ func (p Part) DecodeAndWriteImage(outputPath string) error {
 
 // PrintMessageCountQC performs basic count validation and prints the results to stdout.
 func (m *Messages) PrintMessageCountQC() {
-	lengthSMS := len(m.SMS)
-	lengthMMS := len(m.MMS)
-
-	fmt.Println("\nXML File Validation / QC")
-	fmt.Println("===============================================================")
-	fmt.Printf("Backup Date: %s\n", m.BackupDate.String())
-	fmt.Printf("Message count reported by SMS Backup and Restore app: %s\n", m.Count)
-
-	// convert reportedCount to int for later comparison/validation
-	count, err := strconv.Atoi(m.Count)
-	if err != nil {
-		fmt.Printf("Error converting reported count to integer: %s", m.Count)
-		count = 0
-	}
-
-	fmt.Printf("Actual # SMS messages identified: %d\n", lengthSMS)
-	fmt.Printf("Actual # MMS messages identified: %d\n", lengthMMS)
-	fmt.Printf("Total actual messages identified: %d ... ", lengthSMS + lengthMMS)
-	if lengthSMS + lengthMMS == count {
-		fmt.Print("OK\n")
-	} else {
-		fmt.Print("DISCREPANCY DETECTED\n")
-	}
+	PrintCountQC((&Backup{Messages: m}).Header(), len(m.SMS), len(m.MMS), 0)
 }
 
 // PrintCallCountQC performs basic count validation and prints the results to stdout.
 func (c *Calls) PrintCallCountQC() {
-	lengthCalls := len(c.Calls)
+	PrintCountQC((&Backup{Calls: c}).Header(), 0, 0, len(c.Calls))
+}
+
+// PrintCountQC performs basic count validation of a backup with the given header, e.g. one read by a Parser, against
+// the number of records of each type identified, and prints the results to stdout.
+func PrintCountQC(h *Header, lengthSMS int, lengthMMS int, lengthCalls int) {
+	isCalls := h.BackupType() == CallsBackup
 
 	fmt.Println("\nXML File Validation / QC")
 	fmt.Println("===============================================================")
-	fmt.Printf("Backup Date: %s\n", c.BackupDate.String())
-	fmt.Printf("Call count reported by SMS Backup and Restore app: %s\n", c.Count)
+	fmt.Printf("Backup Date: %s\n", h.BackupDate.String())
+	if isCalls {
+		fmt.Printf("Call count reported by SMS Backup and Restore app: %s\n", h.Count)
+	} else {
+		fmt.Printf("Message count reported by SMS Backup and Restore app: %s\n", h.Count)
+	}
 
 	// convert reportedCount to int for later comparison/validation
-	count, err := strconv.Atoi(c.Count)
+	count, err := strconv.Atoi(h.Count)
 	if err != nil {
-		fmt.Printf("Error converting reported count to integer: %s", c.Count)
+		fmt.Printf("Error converting reported count to integer: %s", h.Count)
 		count = 0
 	}
 
-	fmt.Printf("Total actual calls identified: %d ... ", lengthCalls)
-	if lengthCalls == count {
+	total := lengthCalls
+	if isCalls {
+		fmt.Printf("Total actual calls identified: %d ... ", lengthCalls)
+	} else {
+		fmt.Printf("Actual # SMS messages identified: %d\n", lengthSMS)
+		fmt.Printf("Actual # MMS messages identified: %d\n", lengthMMS)
+		fmt.Printf("Total actual messages identified: %d ... ", lengthSMS + lengthMMS)
+		total = lengthSMS + lengthMMS
+	}
+	if total == count {
 		fmt.Print("OK\n")
 	} else {
 		fmt.Print("DISCREPANCY DETECTED\n")
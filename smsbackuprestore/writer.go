@@ -0,0 +1,188 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// RecordWriter writes parsed records in an output format. Records of each type are written in order of their index
+// within the backup, and Close must be called once all records have been written.
+type RecordWriter interface {
+	// WriteSMS writes the SMS with the given index.
+	WriteSMS(i int, sms *SMS) error
+	// WriteMMS writes the MMS with the given index, including its parts and addresses.
+	WriteMMS(mmsIndex int, mms *MMS) error
+	// WriteCall writes the call with the given index.
+	WriteCall(i int, call *Call) error
+	// Close finishes writing output, returning the first error encountered.
+	Close() error
+}
+
+// BackupWriter is a RecordWriter that is told where the records of each backup begin, e.g. so that it can combine the
+// records of several backups in one output, such as a database. The records of each backup are numbered from 0.
+type BackupWriter interface {
+	RecordWriter
+	// BeginBackup starts the records of another backup, read from source (e.g. the path of the backup file), with the
+	// root element attributes in h.
+	BeginBackup(source string, h *Header) error
+}
+
+// RecordWriterFactory returns a RecordWriter writing files to outputDir according to opts.
+type RecordWriterFactory func(outputDir string, opts ...OutputOption) (RecordWriter, error)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]RecordWriterFactory{
+		"tsv": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return newTableRecordWriter(outputDir, newOutputConfig(opts)), nil
+		},
+		"csv": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			cfg := newOutputConfig(opts)
+			cfg.csv = true
+			return newTableRecordWriter(outputDir, cfg), nil
+		},
+		"json": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return newJSONRecordWriter(outputDir, newOutputConfig(opts)), nil
+		},
+		"ndjson": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			cfg := newOutputConfig(opts)
+			cfg.ndjson = true
+			return newJSONRecordWriter(outputDir, cfg), nil
+		},
+		"sqlite": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return NewSQLiteExport(filepath.Join(outputDir, "backup.sqlite"), opts...)
+		},
+		"xlsx": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return NewXLSXExport(filepath.Join(outputDir, "backup.xlsx"), opts...)
+		},
+		"parquet": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return NewParquetExport(outputDir, opts...), nil
+		},
+		"html": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return newHTMLRecordWriter(outputDir, opts), nil
+		},
+		"mbox": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return newMboxRecordWriter(outputDir, newOutputConfig(opts))
+		},
+		"eml": func(outputDir string, opts ...OutputOption) (RecordWriter, error) {
+			return newEMLRecordWriter(outputDir, newOutputConfig(opts))
+		},
+	}
+)
+
+// RegisterFormat makes an output format available by name to NewRecordWriter. The built-in formats are tsv, csv, json,
+// ndjson, sqlite, xlsx, parquet, html, mbox, and eml. RegisterFormat panics if factory is nil or a format with the same
+// name is already registered.
+func RegisterFormat(name string, factory RecordWriterFactory) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if factory == nil {
+		panic("smsbackuprestore: RegisterFormat factory is nil")
+	}
+	if _, dup := formats[name]; dup {
+		panic("smsbackuprestore: RegisterFormat called twice for format " + name)
+	}
+	formats[name] = factory
+}
+
+// Formats returns the sorted names of the registered output formats.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRecordWriter returns a RecordWriter for the named output format, writing files to outputDir.
+func NewRecordWriter(format string, outputDir string, opts ...OutputOption) (RecordWriter, error) {
+	formatsMu.RLock()
+	factory, ok := formats[format]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown output format: %s", format)
+	}
+	return factory(outputDir, opts...)
+}
+
+// WriteBackup writes all records of a backup read from source (e.g. the path of the backup file) to w, first calling
+// BeginBackup if w is a BackupWriter.
+func WriteBackup(w RecordWriter, source string, b *Backup) error {
+	if bw, ok := w.(BackupWriter); ok {
+		if err := bw.BeginBackup(source, b.Header()); err != nil {
+			return err
+		}
+	}
+	if b.Messages != nil {
+		return WriteMessages(w, b.Messages)
+	}
+	return WriteCalls(w, b.Calls)
+}
+
+// WriteRecord writes a record yielded by Parser.Next, which has the given index among the records of its type, to w.
+func WriteRecord(w RecordWriter, i int, record Record) error {
+	switch rec := record.(type) {
+	case *SMS:
+		return w.WriteSMS(i, rec)
+	case *MMS:
+		return w.WriteMMS(i, rec)
+	case *Call:
+		return w.WriteCall(i, rec)
+	default:
+		return fmt.Errorf("Unknown record type: %T", record)
+	}
+}
+
+// WriteMessages writes all SMS and MMS messages of a backup to w.
+func WriteMessages(w RecordWriter, m *Messages) error {
+	for i := range m.SMS {
+		if err := w.WriteSMS(i, &m.SMS[i]); err != nil {
+			return err
+		}
+	}
+	for i := range m.MMS {
+		if err := w.WriteMMS(i, &m.MMS[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCalls writes all calls of a backup to w.
+func WriteCalls(w RecordWriter, c *Calls) error {
+	for i := range c.Calls {
+		if err := w.WriteCall(i, &c.Calls[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxDateFormat is the number format of timestamp cells in XLSX output, which keeps their milliseconds.
+const xlsxDateFormat = "yyyy-mm-dd hh:mm:ss.000"
+
+// xlsxSheet streams rows of one record type to a worksheet, continuing on a new worksheet (e.g. "SMS (2)") when one
+// reaches the maximum number of rows Excel supports.
+type xlsxSheet struct {
+	name    string
+	headers []string
+	stream  *excelize.StreamWriter
+	sheets  int // number of worksheets used so far
+	row     int // last row written to the current worksheet
+}
+
+// XLSXExport writes parsed backups to a single Excel workbook with SMS, MMS, and Calls worksheets. Any number of SMS
+// and calls backups may be added before it is closed.
+//
+// Timestamps are written as date cells in the configured time zone, indices and durations as numbers, and all other
+// values, including phone numbers, as text so that leading digits are kept. Text longer than an Excel cell can hold
+// (32,767 characters) is truncated, and the number of truncated cells is counted in Truncated.
+type XLSXExport struct {
+	Truncated int // number of cells whose text was truncated to fit
+
+	file      *excelize.File
+	path      string
+	cfg       *outputConfig
+	dateStyle int
+	sms       *xlsxSheet
+	mms       *xlsxSheet
+	calls     *xlsxSheet
+}
+
+// NewXLSXExport creates an Excel workbook to be written to path when closed.
+func NewXLSXExport(path string, opts ...OutputOption) (*XLSXExport, error) {
+	cfg := newOutputConfig(opts)
+	cfg.csv = true // cells can hold line breaks and tabs, so text is kept exactly as backed up
+
+	x := &XLSXExport{
+		file:  excelize.NewFile(),
+		path:  path,
+		cfg:   cfg,
+		sms:   &xlsxSheet{name: "SMS", headers: smsHeaders},
+		mms:   &xlsxSheet{name: "MMS", headers: mmsHeaders},
+		calls: &xlsxSheet{name: "Calls", headers: callHeaders},
+	}
+
+	dateFormat := xlsxDateFormat
+	dateStyle, err := x.file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return nil, err
+	}
+	x.dateStyle = dateStyle
+
+	// the default worksheet of a new workbook becomes the SMS worksheet
+	if err := x.file.SetSheetName("Sheet1", x.sms.name); err != nil {
+		return nil, err
+	}
+	for _, s := range []*xlsxSheet{x.sms, x.mms, x.calls} {
+		if err := x.nextSheet(s); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// GenerateXLSX outputs an Excel workbook named "backup.xlsx" containing parsed messages and calls on separate
+// worksheets. Either m or c may be nil. Use NewXLSXExport to combine more backups than that in one workbook.
+func GenerateXLSX(m *Messages, c *Calls, outputDir string, opts ...OutputOption) error {
+	x, err := NewXLSXExport(filepath.Join(outputDir, "backup.xlsx"), opts...)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if err := x.AddMessages(m); err != nil {
+			x.file.Close()
+			return err
+		}
+	}
+	if c != nil {
+		if err := x.AddCalls(c); err != nil {
+			x.file.Close()
+			return err
+		}
+	}
+	return x.Close()
+}
+
+// nextSheet starts the first or a continuation worksheet for s, with a frozen header row.
+func (x *XLSXExport) nextSheet(s *xlsxSheet) error {
+	s.sheets++
+	name := s.name
+	if s.sheets > 1 {
+		name = fmt.Sprintf("%s (%d)", s.name, s.sheets)
+	}
+	if _, err := x.file.NewSheet(name); err != nil {
+		return err
+	}
+
+	stream, err := x.file.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	s.stream, s.row = stream, 0
+
+	if x.cfg.headerStyle == HeaderNone {
+		return nil
+	}
+	err = stream.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(s.headers))
+	for i, h := range s.headers {
+		if x.cfg.headerStyle == HeaderSnakeCase {
+			h = SnakeCaseHeader(h)
+		}
+		header[i] = h
+	}
+	return x.writeRow(s, header)
+}
+
+// writeRow writes a row to the current worksheet of s, first starting a continuation worksheet if it is full.
+func (x *XLSXExport) writeRow(s *xlsxSheet, values []interface{}) error {
+	if s.row == excelize.TotalRows {
+		if err := x.finishSheet(s); err != nil {
+			return err
+		}
+		if err := x.nextSheet(s); err != nil {
+			return err
+		}
+	}
+
+	s.row++
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return err
+	}
+	return s.stream.SetRow(cell, values)
+}
+
+// finishSheet adds an autofilter to the header row of the current worksheet of s and ends it.
+func (x *XLSXExport) finishSheet(s *xlsxSheet) error {
+	if x.cfg.headerStyle != HeaderNone {
+		lastCell, err := excelize.CoordinatesToCellName(len(s.headers), s.row)
+		if err != nil {
+			return err
+		}
+		if err := x.file.AutoFilter(s.stream.Sheet, "A1:"+lastCell, nil); err != nil {
+			return err
+		}
+	}
+	return s.stream.Flush()
+}
+
+// Close ends each worksheet, writes the workbook to its path, and releases its resources.
+func (x *XLSXExport) Close() error {
+	defer x.file.Close()
+
+	for _, s := range []*xlsxSheet{x.sms, x.mms, x.calls} {
+		if err := x.finishSheet(s); err != nil {
+			return err
+		}
+	}
+	x.file.SetActiveSheet(0)
+	if err := x.file.SaveAs(x.path); err != nil {
+		return fmt.Errorf("Unable to create file: %s\n%q", x.path, err)
+	}
+	return nil
+}
+
+// BeginBackup starts the records of another backup, whose image file names in the MMS worksheet are prefixed as with
+// WithSource(source).
+func (x *XLSXExport) BeginBackup(source string, h *Header) error {
+	x.cfg.source = sourceName(source)
+	return nil
+}
+
+// AddMessages adds the SMS and MMS messages of a backup to the SMS and MMS worksheets.
+func (x *XLSXExport) AddMessages(m *Messages) error {
+	return WriteMessages(x, m)
+}
+
+// AddCalls adds the calls of a backup to the Calls worksheet.
+func (x *XLSXExport) AddCalls(c *Calls) error {
+	return WriteCalls(x, c)
+}
+
+// WriteSMS adds the SMS with the given index to the SMS worksheet.
+func (x *XLSXExport) WriteSMS(i int, sms *SMS) error {
+	row := x.row(smsRow(i, sms, x.cfg))
+	row[0] = i
+	x.setTime(row, smsHeaders, "Date", sms.Date)
+	x.setTime(row, smsHeaders, "Date Sent", sms.DateSent)
+	return x.writeRow(x.sms, row)
+}
+
+// WriteMMS adds the MMS with the given index to the MMS worksheet, one row per part.
+func (x *XLSXExport) WriteMMS(mmsIndex int, mms *MMS) error {
+	for partIndex, fields := range mmsRows(mmsIndex, mms, x.cfg) {
+		row := x.row(fields)
+		row[0], row[1] = mmsIndex, partIndex
+		x.setTime(row, mmsHeaders, "Date", mms.Date)
+		x.setTime(row, mmsHeaders, "Date Sent", mms.DateSent)
+		if err := x.writeRow(x.mms, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCall adds the call with the given index to the Calls worksheet.
+func (x *XLSXExport) WriteCall(i int, call *Call) error {
+	row := x.row(callRow(i, call, x.cfg))
+	row[0] = i
+	row[columnIndex(callHeaders, "Duration (Seconds)")] = call.Duration
+	x.setTime(row, callHeaders, "Date", call.Date)
+	return x.writeRow(x.calls, row)
+}
+
+// row converts the fields of a row of delimited output to cell values, which are all text, truncating any that are too
+// long for a cell.
+func (x *XLSXExport) row(fields []string) []interface{} {
+	row := make([]interface{}, len(fields))
+	for i, field := range fields {
+		text, truncated := xlsxCellText(field)
+		if truncated {
+			x.Truncated++
+		}
+		row[i] = text
+	}
+	return row
+}
+
+// xlsxCellText truncates text to the number of characters an Excel cell can hold, reporting whether it was truncated.
+// Excel counts characters in UTF-16 code units, so characters outside the Basic Multilingual Plane, such as emoji,
+// count twice.
+func xlsxCellText(text string) (string, bool) {
+	// no string has more UTF-16 code units than UTF-8 bytes
+	if len(text) <= excelize.TotalCellChars {
+		return text, false
+	}
+
+	units := 0
+	for i, r := range text {
+		n := 1
+		if r > 0xFFFF {
+			n = 2
+		}
+		if units+n > excelize.TotalCellChars {
+			return text[:i], true
+		}
+		units += n
+	}
+	return text, false
+}
+
+// setTime replaces the text of the named column of row with a date cell holding timestamp in the configured time
+// zone. Excel has no notion of time zones, so the cell holds the local date and time. Timestamps that are not valid are
+// left as text.
+func (x *XLSXExport) setTime(row []interface{}, headers []string, name string, timestamp AndroidTS) {
+	t := timestamp.Time()
+	if t.IsZero() {
+		return
+	}
+	t = t.In(x.cfg.location)
+	local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	row[columnIndex(headers, name)] = excelize.Cell{StyleID: x.dateStyle, Value: local}
+}
+
+// columnIndex returns the index of the named column in headers, or -1 if there is none.
+func columnIndex(headers []string, name string) int {
+	for i, header := range headers {
+		if header == name {
+			return i
+		}
+	}
+	return -1
+}
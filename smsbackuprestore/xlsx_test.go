@@ -0,0 +1,100 @@
+/*
+SBRParser: SMS Backup & Restore Android app parser
+
+Copyright (c) 2018 Dan O'Day <d@4n68r.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+ */
+
+package smsbackuprestore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXCellText(t *testing.T) {
+	limit := excelize.TotalCellChars
+	tests := []struct {
+		name      string
+		text      string
+		wantUnits int
+		truncated bool
+	}{
+		{"short", "hello", 5, false},
+		{"at limit", strings.Repeat("a", limit), limit, false},
+		{"over limit", strings.Repeat("a", limit+1), limit, true},
+		{"multibyte at limit", strings.Repeat("é", limit), limit, false},
+		{"emoji over limit", strings.Repeat("😀", limit/2+1), limit - 1, true},
+		{"emoji straddling limit", strings.Repeat("a", limit-1) + "😀", limit - 1, true},
+	}
+
+	for _, tt := range tests {
+		got, truncated := xlsxCellText(tt.text)
+		if truncated != tt.truncated {
+			t.Errorf("%s: got truncated %v, want %v", tt.name, truncated, tt.truncated)
+		}
+		if units := len(utf16.Encode([]rune(got))); units != tt.wantUnits {
+			t.Errorf("%s: got %d UTF-16 code units, want %d", tt.name, units, tt.wantUnits)
+		}
+		if !strings.HasPrefix(tt.text, got) {
+			t.Errorf("%s: result is not a prefix of the text", tt.name)
+		}
+	}
+}
+
+func TestXLSXExportTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.xlsx")
+	x, err := NewXLSXExport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", excelize.TotalCellChars+100)
+	m := &Messages{SMS: []SMS{{Address: "5551212", Body: long}, {Address: "5551212", Body: "short"}}}
+	if err := x.AddMessages(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if x.Truncated != 1 {
+		t.Errorf("got %d truncated cells, want 1", x.Truncated)
+	}
+
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("SMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := columnIndex(smsHeaders, "Body")
+	if got := rows[1][body]; got != long[:excelize.TotalCellChars] {
+		t.Errorf("got body of %d characters, want %d", len(got), excelize.TotalCellChars)
+	}
+	if got := rows[2][body]; got != "short" {
+		t.Errorf("got body %q, want %q", got, "short")
+	}
+}